@@ -2,84 +2,126 @@ package main
 
 import (
 	"bytes"
-	"encoding/json"
 	"errors"
+	"flag"
 	"fmt"
 	"io"
 	"os"
 	"sort"
-	"time"
+	"strings"
 	"unsafe"
 
 	"github.com/RaduBerinde/pebble_analysis/objiotracing/lib"
 	"github.com/cockroachdb/pebble/objstorage/objstorageprovider/objiotracing"
-	gzip "github.com/klauspost/pgzip"
 )
 
 type Event = objiotracing.Event
 
 const eventSize = int(unsafe.Sizeof(Event{}))
 
+var tracesDir = flag.String("traces", "traces", "trace library directory to add the trace to")
+var rawDir = flag.String("raw", "", "raw trace directory to add (instead of a list of trace files)")
+
+const usage = `usage: addtrace [-traces <dir>] <trace-name> [<source>=]<trace-file>...
+       addtrace [-traces <dir>] -raw <raw-trace-dir> <trace-name>
+
+Each trace file can optionally be prefixed with a source identifier (e.g.
+"n1/s1=n1/IOTRACES-xyz"), which should be set when the trace combines files from
+multiple nodes or stores.
+
+A raw trace directory contains Pebble IOTRACES- files, either directly or in a
+subdirectory per node (the subdirectory names are used as source identifiers).`
+
 func main() {
-	if len(os.Args) < 2 {
-		checkErr(errors.New("usage: addtrace <trace-name> <trace-files>..."))
-		os.Exit(1)
+	flag.Parse()
+	if *rawDir != "" {
+		if flag.NArg() != 1 {
+			checkErr(errors.New(usage))
+		}
+		addRaw(lib.NewDirStore(*tracesDir), *rawDir, flag.Arg(0))
+		return
+	}
+	if flag.NArg() < 2 {
+		checkErr(errors.New(usage))
+	}
+	store := lib.NewDirStore(*tracesDir)
+	traceName := flag.Arg(0)
+
+	var md lib.TraceMetadata
+	md.Name = traceName
+	type input struct {
+		filename string
+		source   uint32
+	}
+	var inputs []input
+	sourceIDs := make(map[string]uint32)
+	for _, arg := range flag.Args()[1:] {
+		source, filename, ok := strings.Cut(arg, "=")
+		if !ok {
+			inputs = append(inputs, input{filename: arg})
+			continue
+		}
+		id, ok := sourceIDs[source]
+		if !ok {
+			md.Sources = append(md.Sources, source)
+			id = uint32(len(md.Sources))
+			sourceIDs[source] = id
+		}
+		inputs = append(inputs, input{filename: filename, source: id})
 	}
-	traceName := os.Args[1]
-	filenames := os.Args[2:]
+
 	fmt.Printf("Creating trace %q\n", traceName)
 	var size int64
-	for _, name := range filenames {
-		info, err := os.Stat(name)
+	for _, in := range inputs {
+		info, err := os.Stat(in.filename)
 		checkErr(err)
 		size += info.Size()
 	}
 
 	buf := bytes.NewBuffer(make([]byte, 0, int(size)))
-	for _, name := range filenames {
-		fmt.Printf("Reading %s..\n", name)
-		f, err := os.Open(name)
+	// Event ranges (in the buffer) for each input.
+	ranges := make([][2]int, len(inputs))
+	for i, in := range inputs {
+		fmt.Printf("Reading %s..\n", in.filename)
+		f, err := os.Open(in.filename)
 		checkErr(err)
+		ranges[i][0] = buf.Len() / eventSize
 		_, err = io.Copy(buf, f)
 		checkErr(err)
 		checkErr(f.Close())
+		// Should be a no-op, but just in case.
+		buf.Truncate(buf.Len() / eventSize * eventSize)
+		ranges[i][1] = buf.Len() / eventSize
 	}
 
 	asBytes := buf.Bytes()
-	// Should be a no-op, but just in case.
-	asBytes = asBytes[:len(asBytes)/eventSize*eventSize]
 	if len(asBytes) == 0 {
 		checkErr(errors.New("no traces"))
 	}
 	p := unsafe.Pointer(&asBytes[0])
 	events := unsafe.Slice((*Event)(p), len(asBytes)/eventSize)
+	for i, in := range inputs {
+		if in.source != 0 {
+			for j := ranges[i][0]; j < ranges[i][1]; j++ {
+				lib.SetEventSource(&events[j], in.source)
+			}
+		}
+	}
 
 	fmt.Printf("Sorting %d events..\n", len(events))
 	sort.Slice(events, func(i, j int) bool {
 		return events[i].StartUnixNano < events[j].StartUnixNano
 	})
 
-	var md lib.TraceMetadata
-	md.Name = traceName
-	startTime := time.Unix(0, events[0].StartUnixNano)
-	endTime := time.Unix(0, events[len(events)-1].StartUnixNano)
-	md.StartTime = startTime.Format(time.RFC3339)
-	md.DurationSecs = int((endTime.Sub(startTime) + time.Second - 1) / time.Second)
-	md.NumEvents = len(events)
-
-	outFilename := fmt.Sprintf("traces/%s.gz", traceName)
-	fmt.Printf("Writing %s..\n", outFilename)
-	out, err := os.Create(outFilename)
-	checkErr(err)
-	w := gzip.NewWriter(out)
-	_, err = w.Write(asBytes)
-	checkErr(err)
-	checkErr(w.Close())
-	checkErr(out.Close())
+	fmt.Printf("Writing trace %q to %s..\n", traceName, *tracesDir)
+	checkErr(store.Add(md, lib.SliceIterator(events)))
+}
 
-	jsonBuf, err := json.Marshal(&md)
+func addRaw(store lib.TraceStore, dir string, traceName string) {
+	md, it, err := lib.OpenRawTrace(dir, traceName)
 	checkErr(err)
-	checkErr(os.WriteFile(fmt.Sprintf("traces/%s.json", traceName), jsonBuf, 0666))
+	fmt.Printf("Writing trace %q (%d events) to %s..\n", traceName, md.NumEvents, *tracesDir)
+	checkErr(store.Add(md, it))
 }
 
 func checkErr(err error) {
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/RaduBerinde/pebble_analysis/objiotracing/lib"
+)
+
+var tracesDir = flag.String("traces", "traces", "trace library directory")
+var listFiles = flag.Bool("files", false, "print the recovered sstable metadata (as CSV) instead of a summary")
+
+const usage = `usage: attachmanifest [-traces <dir>] <trace-name> [<source>=]<file>...
+       attachmanifest [-traces <dir>] [-files] <trace-name>
+
+Attaches Pebble MANIFEST- and OPTIONS- files (captured alongside the trace) to a
+trace, and prints a summary of the sstable metadata recovered from them. For
+traces with multiple sources, each file must be prefixed with the source
+identifier it belongs to (e.g. "n1=n1/MANIFEST-000123"). A rotated MANIFEST can
+be attached as multiple files; they are replayed in order.
+
+Without files, prints the metadata already attached to the trace. For raw
+traces, the files are read from the trace directory.`
+
+func main() {
+	flag.Parse()
+	if flag.NArg() < 1 {
+		checkErr(errors.New(usage))
+	}
+	store := lib.NewDirStore(*tracesDir)
+	traceName := flag.Arg(0)
+	for _, arg := range flag.Args()[1:] {
+		source, filename, ok := strings.Cut(arg, "=")
+		if !ok {
+			source, filename = "", arg
+		}
+		fmt.Printf("Attaching %s..\n", filename)
+		checkErr(store.AttachManifest(traceName, source, filename))
+	}
+
+	md, err := store.Stat(traceName)
+	checkErr(err)
+	files, err := store.FileTable(traceName)
+	checkErr(err)
+	if *listFiles {
+		fmt.Printf("source,file_num,level,size,created_by,creation_time,deleted,deletion_time,smallest_key,largest_key\n")
+		for _, f := range files.Files() {
+			fmt.Printf("%s,%d,%d,%d,%s,%d,%t,%d,%q,%q\n",
+				md.SourceName(f.Source), f.FileNum, f.Level, f.Size, f.CreatedBy,
+				f.CreationTime, f.Deleted, f.DeletionTime, f.SmallestKey, f.LargestKey)
+		}
+		return
+	}
+
+	if files.Len() == 0 {
+		fmt.Printf("No sstable metadata for trace %q\n", traceName)
+		return
+	}
+	var deleted int
+	var totalSize uint64
+	byReason := make(map[lib.FileCreationReason]int)
+	for _, f := range files.Files() {
+		if f.Deleted {
+			deleted++
+		}
+		totalSize += f.Size
+		byReason[f.CreatedBy]++
+	}
+	fmt.Printf("%d sstables (%d deleted), %.1f MB total\n", files.Len(), deleted, float64(totalSize)/(1<<20))
+	for _, r := range []lib.FileCreationReason{
+		lib.CreatedByFlush, lib.CreatedByCompaction, lib.CreatedByIngestion, lib.CreatedByUnknown,
+	} {
+		if byReason[r] > 0 {
+			fmt.Printf("  %-11s %d\n", r+":", byReason[r])
+		}
+	}
+	for source, opts := range files.Options {
+		name := md.SourceName(source)
+		if name == "" {
+			name = "-"
+		}
+		fmt.Printf("Options (source %s): %d entries\n", name, len(opts))
+	}
+}
+
+func checkErr(err error) {
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/RaduBerinde/pebble_analysis/objiotracing/lib"
+)
+
+var tracesDir = flag.String("traces", "traces", "trace library directory to add the trace to")
+
+const usage = `usage: gentrace [flags] <trace-name>
+
+Generates a synthetic trace from a parametric LSM workload model and adds it to
+the trace library.
+
+Flags:`
+
+func main() {
+	cfg := lib.DefaultWorkloadConfig()
+	flag.Int64Var(&cfg.Seed, "seed", cfg.Seed, "random seed")
+	flag.DurationVar(&cfg.Duration, "duration", cfg.Duration, "trace duration")
+	flag.Int64Var(&cfg.NumKeys, "keys", cfg.NumKeys, "number of keys")
+	flag.Int64Var(&cfg.ValueSize, "value-size", cfg.ValueSize, "size of each key-value pair, in bytes")
+	flag.Float64Var(&cfg.ZipfS, "zipf", cfg.ZipfS, "skew of the key popularity (must be > 1)")
+	flag.Float64Var(&cfg.ReadsPerSec, "reads-per-sec", cfg.ReadsPerSec, "user reads per second")
+	flag.Float64Var(&cfg.ScanFraction, "scan-fraction", cfg.ScanFraction, "fraction of user reads that are scans")
+	flag.IntVar(&cfg.ScanBlocks, "scan-blocks", cfg.ScanBlocks, "number of data blocks read by a scan in each level")
+	flag.Float64Var(&cfg.WritesPerSec, "writes-per-sec", cfg.WritesPerSec, "user writes per second")
+	flag.Int64Var(&cfg.MemtableSize, "memtable-size", cfg.MemtableSize, "memtable size, in bytes")
+	flag.Int64Var(&cfg.BlockSize, "block-size", cfg.BlockSize, "sstable data block size, in bytes")
+	flag.Int64Var(&cfg.TargetFileSize, "target-file-size", cfg.TargetFileSize, "target sstable size, in bytes")
+	flag.IntVar(&cfg.LevelMultiplier, "level-multiplier", cfg.LevelMultiplier, "ratio between the sizes of adjacent levels")
+	flag.Int64Var(&cfg.BaseLevelSize, "base-level-size", cfg.BaseLevelSize, "minimum target size of the base level, in bytes")
+	flag.IntVar(&cfg.L0CompactionThreshold, "l0-compaction-threshold", cfg.L0CompactionThreshold, "number of L0 files that triggers a compaction")
+	flag.Float64Var(&cfg.CompactionMBPerSec, "compaction-mbps", cfg.CompactionMBPerSec, "flush and compaction throughput, in MB/s")
+	flag.Usage = func() {
+		fmt.Fprintln(flag.CommandLine.Output(), usage)
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() != 1 {
+		flag.Usage()
+		os.Exit(1)
+	}
+	store := lib.NewDirStore(*tracesDir)
+	traceName := flag.Arg(0)
+
+	fmt.Printf("Generating trace %q..\n", traceName)
+	events, err := lib.GenerateWorkload(cfg)
+	checkErr(err)
+	if len(events) == 0 {
+		checkErr(errors.New("no events"))
+	}
+	fmt.Printf("Writing trace %q (%d events) to %s..\n", traceName, len(events), *tracesDir)
+	checkErr(store.Add(lib.TraceMetadata{Name: traceName}, lib.SliceIterator(events)))
+}
+
+func checkErr(err error) {
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		os.Exit(1)
+	}
+}
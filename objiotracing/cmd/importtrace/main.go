@@ -0,0 +1,87 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/RaduBerinde/pebble_analysis/objiotracing/lib"
+	gzip "github.com/klauspost/pgzip"
+)
+
+var (
+	tracesDir  = flag.String("traces", "traces", "trace library directory to add the trace to")
+	format     = flag.String("format", "", "input format: msr (MSR Cambridge), twitter (Twitter cache traces) or csv (timestamp,object,offset,size,op)")
+	timeUnit   = flag.Duration("time-unit", 0, "unit of the timestamps for the csv format (e.g. 1s, 1us); default is nanoseconds")
+	skipHeader = flag.Bool("skip-header", false, "skip the first line of each input file")
+)
+
+const usage = `usage: importtrace [-traces <dir>] -format msr|twitter|csv <trace-name> <files>...
+
+Imports third-party cache or block traces into the trace library. Files ending
+in .gz are decompressed.`
+
+func main() {
+	flag.Parse()
+	if *format == "" || flag.NArg() < 2 {
+		checkErr(errors.New(usage))
+	}
+	store := lib.NewDirStore(*tracesDir)
+	traceName := flag.Arg(0)
+
+	im, err := lib.NewImporter(lib.ImportOptions{
+		Format:     lib.ImportFormat(*format),
+		TimeUnit:   *timeUnit,
+		SkipHeader: *skipHeader,
+	})
+	checkErr(err)
+	for _, filename := range flag.Args()[1:] {
+		fmt.Printf("Reading %s..\n", filename)
+		checkErr(importFile(im, filename))
+	}
+	events, sources, skipped := im.Finish()
+	if len(events) == 0 {
+		checkErr(errors.New("no events"))
+	}
+	if skipped > 0 {
+		fmt.Printf("Skipped %d records which are not reads or writes\n", skipped)
+	}
+
+	md := lib.TraceMetadata{
+		Name:    traceName,
+		Sources: sources,
+	}
+	fmt.Printf("Writing trace %q (%d events) to %s..\n", traceName, len(events), *tracesDir)
+	checkErr(store.Add(md, lib.SliceIterator(events)))
+}
+
+func importFile(im *lib.Importer, filename string) error {
+	f, err := os.Open(filename)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	var r io.Reader = f
+	if strings.HasSuffix(filename, ".gz") {
+		gz, err := gzip.NewReader(f)
+		if err != nil {
+			return err
+		}
+		defer gz.Close()
+		r = gz
+	}
+	if err := im.Add(r); err != nil {
+		return fmt.Errorf("%s: %v", filename, err)
+	}
+	return nil
+}
+
+func checkErr(err error) {
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		os.Exit(1)
+	}
+}
@@ -2,156 +2,355 @@ package main
 
 import (
 	"encoding/json"
+	"flag"
 	"fmt"
 	"io"
 	"log"
 	"net/http"
+	"sort"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/RaduBerinde/pebble_analysis/objiotracing/lib"
-	"github.com/cockroachdb/pebble/objstorage/objstorageprovider/objiotracing"
 )
 
 const port = 8089
 
+var tracesDirs = flag.String(
+	"traces", "traces",
+	"comma-separated list of trace library directories; new traces are added to the first one",
+)
+
+// store is the trace library; it is set up in main based on flags.
+var store lib.TraceStore
+
+// fileTables caches the sstable metadata of the traces, which is loaded the
+// first time a simulation needs it. Requests are handled concurrently, so the
+// map is protected by a mutex.
+var fileTables = struct {
+	sync.Mutex
+	m map[string]*lib.FileTable
+}{m: make(map[string]*lib.FileTable)}
+
+// fileTable returns the sstable metadata of a trace. Note that the metadata is
+// only loaded once; the server needs to be restarted to pick up files attached
+// later.
+func fileTable(trace string) *lib.FileTable {
+	fileTables.Lock()
+	defer fileTables.Unlock()
+	files, ok := fileTables.m[trace]
+	if !ok {
+		var err error
+		files, err = store.FileTable(trace)
+		checkErr(err, fmt.Sprintf("loading sstable metadata for %q", trace))
+		fileTables.m[trace] = files
+	}
+	return files
+}
+
 type ListTracesResponse struct {
 	Traces []string `json:"traces"`
 }
 
-// ListTraces returns all traces available in the traces/ directory.
+// ListTraces returns all traces available in the trace library.
 func ListTraces() ListTracesResponse {
-	traces, err := lib.ListTraces()
-	checkErr(err, "reading traces directory")
+	traces, err := store.List()
+	checkErr(err, "listing traces")
 	return ListTracesResponse{Traces: traces}
 }
 
 type PlotTraceRequest struct {
 	Trace string `json:"trace"`
+	// Metric is the plotted value (see lib.ParsePlotMetric); defaults to "mbps".
+	Metric string `json:"metric,omitempty"`
+	// Ops restricts the plot to the given op types (see lib.ParseOp); empty
+	// means all ops.
+	Ops []string `json:"ops,omitempty"`
+	// GroupBy is the dimension by which the events are split into series (see
+	// lib.ParsePlotGroupBy); defaults to "none".
+	GroupBy string `json:"group_by,omitempty"`
+	// StartSecs and EndSecs restrict the plot to a time range, relative to the
+	// start of the trace; EndSecs = 0 means the end of the trace.
+	StartSecs float64 `json:"start_secs,omitempty"`
+	EndSecs   float64 `json:"end_secs,omitempty"`
+	// TickSecs is the resolution of the plot (it can be fractional). By default,
+	// the range is split into about 10000 ticks of at least one second.
+	TickSecs float64 `json:"tick_secs,omitempty"`
+	// IOPS adds an ops/s series for each group (see lib.PlotConfig.IOPS).
+	IOPS bool `json:"iops,omitempty"`
+	// SizePercentiles adds a series for each percentile (between 0 and 100,
+	// e.g. 99) of the operation sizes, for each group. There are no latency
+	// percentiles: the trace events don't record the duration of the operations.
+	SizePercentiles []float64 `json:"size_percentiles,omitempty"`
+	// WorkingSet requests the working set size over time (see
+	// PlotTraceResponse.WorkingSet), which is costly to compute for large
+	// traces.
+	WorkingSet bool `json:"working_set,omitempty"`
 }
 
 type PlotTraceResponse struct {
-	NumTicks         int `json:"num_ticks"`
-	TickDurationSecs int `json:"tick_duration_secs"`
+	NumTicks         int     `json:"num_ticks"`
+	TickDurationSecs float64 `json:"tick_duration_secs"`
 
-	TimeAxisUnixSecs []int64 `json:"time_axis_unix_secs"`
+	// TraceStartUnixSecs is the start time of the trace (which the request's
+	// StartSecs and EndSecs are relative to).
+	TraceStartUnixSecs int64     `json:"trace_start_unix_secs"`
+	TimeAxisUnixSecs   []float64 `json:"time_axis_unix_secs"`
 
-	ReadMBPS     []float64 `json:"read_mbps"`
-	WriteMBPS    []float64 `json:"write_mbps"`
-	CacheHitMBPS []float64 `json:"cache_hit_mbps"`
+	// Series contains a series for each value of the group-by dimension,
+	// followed by its IOPS and size percentile series (if requested); the series
+	// can have different units.
+	Series []lib.PlotSeries `json:"series"`
 
-	ReadMBPSL5L6     []float64 `json:"read_mbps_l5_l6"`
-	WriteMBPSL5L6    []float64 `json:"write_mbps_l5_l6"`
-	CacheHitMBPSL5L6 []float64 `json:"cache_hit_mbps_l5_l6"`
+	// WorkingSet contains the working set size over time (aligned with
+	// TimeAxisUnixSecs), for lib.DefaultWorkingSetWindows, for all levels and
+	// for each level. It is only set if the request sets WorkingSet.
+	WorkingSet []lib.WorkingSetSeries `json:"working_set,omitempty"`
 }
 
 
 // TODO(josh): Produce a hit rate graph, to compare hit rate of productionized
 // pebble block clock to simulated algorithms.
 func Plot(req PlotTraceRequest) PlotTraceResponse {
-	md, it, err := lib.Load(req.Trace)
+	var config lib.PlotConfig
+	var err error
+	if req.Metric != "" {
+		config.Metric, err = lib.ParsePlotMetric(req.Metric)
+		checkErr(err, "parsing metric")
+	}
+	if req.GroupBy != "" {
+		config.GroupBy, err = lib.ParsePlotGroupBy(req.GroupBy)
+		checkErr(err, "parsing group-by dimension")
+	}
+	config.IOPS = req.IOPS
+	for _, p := range req.SizePercentiles {
+		if p < 0 || p > 100 {
+			checkErr(fmt.Errorf("invalid size percentile %g", p), "validating request")
+		}
+		config.SizePercentiles = append(config.SizePercentiles, p/100)
+	}
+	for _, o := range req.Ops {
+		op, err := lib.ParseOp(o)
+		checkErr(err, "parsing op")
+		config.Ops = append(config.Ops, op)
+	}
+
+	md, it, err := store.Open(req.Trace)
 	checkErr(err, fmt.Sprintf("loading trace %q", req.Trace))
+	defer it.Close()
 
-	const targetTicks = 10000
-	tickSecs := md.DurationSecs / targetTicks
-	if tickSecs < 1 {
-		tickSecs = 1
-	}
 	startTime, err := time.Parse(time.RFC3339, md.StartTime)
 	checkErr(err, "parsing trace start time")
-	var r PlotTraceResponse
-	r.NumTicks = 1 + md.DurationSecs/tickSecs
-	r.TickDurationSecs = tickSecs
-	r.TimeAxisUnixSecs = make([]int64, 0, r.NumTicks)
+	secs := func(s float64) time.Duration {
+		return time.Duration(s * float64(time.Second))
+	}
+	start := startTime.Add(secs(req.StartSecs))
+	end := startTime.Add(time.Duration(md.DurationSecs) * time.Second)
+	if req.EndSecs != 0 {
+		end = startTime.Add(secs(req.EndSecs))
+	}
+	if !end.After(start) {
+		checkErr(fmt.Errorf("invalid range [%gs, %gs)", req.StartSecs, req.EndSecs), "parsing request")
+	}
 
-	const (
-		read = iota
-		write
-		hit
-		readL56
-		writeL56
-		hitL56
-	)
-	metrics := [...]*[]float64{
-		read:     &r.ReadMBPS,
-		write:    &r.WriteMBPS,
-		hit:      &r.CacheHitMBPS,
-		readL56:  &r.ReadMBPSL5L6,
-		writeL56: &r.WriteMBPSL5L6,
-		hitL56:   &r.CacheHitMBPSL5L6,
-	}
-	curr := make([]float64, len(metrics))
-	for i := range metrics {
-		*metrics[i] = make([]float64, 0, r.NumTicks)
-	}
-
-	currentTick := startTime
-	tickDuration := time.Second * time.Duration(tickSecs)
-	toMBPS := 1.0 / (1024 * 1024) / float64(tickSecs)
-
-	flush := func() {
-		r.TimeAxisUnixSecs = append(r.TimeAxisUnixSecs, currentTick.Unix())
-		for i := range metrics {
-			*metrics[i] = append(*metrics[i], curr[i])
-			curr[i] = 0
+	// The filter stops the iteration once we reach the end of the range.
+	filter := lib.EventFilter{StartUnixNano: start.UnixNano()}
+	if req.EndSecs != 0 {
+		filter.EndUnixNano = end.UnixNano()
+	}
+	it = lib.FilterIterator(it, filter)
+
+	const targetTicks = 10000
+	const maxTicks = 1000000
+	tick := secs(req.TickSecs)
+	if tick <= 0 {
+		tick = end.Sub(start) / targetTicks
+		if tick < time.Second {
+			tick = time.Second
 		}
 	}
+	if minTick := end.Sub(start) / maxTicks; tick < minTick {
+		tick = minTick
+	}
+	if tick < time.Microsecond {
+		tick = time.Microsecond
+	}
+	var r PlotTraceResponse
+	r.NumTicks = 1 + int(end.Sub(start)/tick)
+	r.TickDurationSecs = tick.Seconds()
+	r.TraceStartUnixSecs = startTime.Unix()
+
+	axis := lib.TimeAxis{
+		StartUnixNano: start.UnixNano(),
+		TickNanos:     int64(tick),
+		NumTicks:      r.NumTicks,
+	}
+	plotter := lib.NewPlotter(axis, config)
+	var ws *lib.WorkingSet
+	if req.WorkingSet {
+		ws = lib.NewWorkingSet(axis, 0 /* blockSize */, lib.DefaultWorkingSetWindows)
+	}
 	for {
 		events, err := it.NextBatch()
 		checkErr(err, "iterating")
 		if events == nil {
 			break
 		}
-		for i := range events {
-			ev := &events[i]
-			t := time.Unix(0, ev.StartUnixNano)
-			for t.Sub(currentTick) >= tickDuration {
-				flush()
-				currentTick = currentTick.Add(tickDuration)
-			}
-			isL56 := ev.LevelPlusOne > 5
-			size := float64(ev.Size) * toMBPS
-			switch ev.Op {
-			case objiotracing.ReadOp:
-				curr[read] += size
-				if isL56 {
-					curr[readL56] += size
-				}
-			case objiotracing.WriteOp:
-				curr[write] += size
-				if isL56 {
-					curr[writeL56] += size
-				}
-			case objiotracing.RecordCacheHitOp:
-				curr[hit] += size
-				if isL56 {
-					curr[hitL56] += size
-				}
-			}
+		plotter.Add(events)
+		if ws != nil {
+			ws.Add(events)
 		}
 	}
-	flush()
-
+	r.TimeAxisUnixSecs, r.Series = plotter.Finish()
+	if ws != nil {
+		r.WorkingSet = ws.Finish()
+	}
 	return r
 }
 
 type SimulateTraceRequest struct {
 	Trace string `json:"trace"`
+	// If set, cache capacities are in bytes rather than entries (see
+	// lib.Config.ByteWeighted); only the policies that support it are simulated.
+	ByteWeighted bool `json:"byte_weighted,omitempty"`
+	// Admission lists the admission policies (see lib.ParseAdmissionPolicy) to
+	// combine with each replacement policy and option set. Defaults to
+	// "always".
+	Admission []string `json:"admission,omitempty"`
+	// Parameters for the admission policies (see lib.Config); zero values use
+	// defaults.
+	AdmissionMinFrequency int      `json:"admission_min_frequency,omitempty"`
+	AdmissionMaxSize      int64    `json:"admission_max_size,omitempty"`
+	AdmissionLevels       []string `json:"admission_levels,omitempty"`
+	AdmissionReasons      []string `json:"admission_reasons,omitempty"`
+	AdmissionBlockTypes   []string `json:"admission_block_types,omitempty"`
+	AdmissionProbability  float64  `json:"admission_probability,omitempty"`
+	// AdmissionMinFileLifetimeSecs defaults to 60.
+	AdmissionMinFileLifetimeSecs int `json:"admission_min_file_lifetime_secs,omitempty"`
+	// Deletion is a deletion mode (see lib.ParseDeletionMode). If set, each
+	// option set is simulated with and without invalidating the blocks of
+	// deleted files, and only policies that support invalidation are used.
+	Deletion          string `json:"deletion,omitempty"`
+	DeletionGraceSecs int    `json:"deletion_grace_secs,omitempty"`
+	DeletionsFile     string `json:"deletions_file,omitempty"`
+	// Readahead lists readahead modes (see lib.ParseReadaheadMode). If set, each
+	// option set is simulated without readahead and with each mode, using
+	// ReadaheadBlockSize blocks (default 32KB); only policies that support
+	// invalidation are used.
+	Readahead          []string `json:"readahead,omitempty"`
+	ReadaheadSize      int64    `json:"readahead_size,omitempty"`
+	ReadaheadBlockSize int64    `json:"readahead_block_size,omitempty"`
+	// If CoalesceWindowMicros is set, object store reads for misses on the same
+	// file within this window and at most CoalesceMaxGap bytes apart are merged
+	// (see lib.Config.CoalesceWindow).
+	CoalesceWindowMicros int64 `json:"coalesce_window_micros,omitempty"`
+	CoalesceMaxGap       int64 `json:"coalesce_max_gap,omitempty"`
+	// BlockSizes lists cache block sizes in bytes (see lib.Config.BlockSize).
+	// If set, each option set is simulated with each block size, with reads
+	// accessing all the blocks they overlap (see lib.Config.AllBlocks). Cache
+	// sizes are then in bytes, and the same total capacity is used for all
+	// block sizes.
+	BlockSizes []int64 `json:"block_sizes,omitempty"`
+	// CostModel is used to price the results (see ResultsPerOptionSet.Cost).
+	// Fields which are not set in the request default to lib.DefaultCostModel.
+	CostModel *lib.CostModel `json:"cost_model,omitempty"`
+}
+
+// withAdmission returns a copy of the given config for each admission policy
+// in the request.
+func withAdmission(config lib.Config, req SimulateTraceRequest) []lib.Config {
+	if len(req.Admission) == 0 {
+		return []lib.Config{config}
+	}
+	var res []lib.Config
+	for _, name := range req.Admission {
+		policy, err := lib.ParseAdmissionPolicy(name)
+		checkErr(err, "parsing admission policy")
+		c := config
+		c.Admission = policy
+		switch policy {
+		case lib.AdmitTinyLFU:
+			c.AdmissionMinFrequency = req.AdmissionMinFrequency
+		case lib.AdmitSizeThreshold:
+			c.AdmissionMaxSize = req.AdmissionMaxSize
+			if c.AdmissionMaxSize == 0 {
+				c.AdmissionMaxSize = 32 * 1024
+			}
+		case lib.AdmitPredicate:
+			for _, l := range req.AdmissionLevels {
+				v, err := lib.ParseLevel(l)
+				checkErr(err, "parsing admission levels")
+				c.AdmissionLevels |= 1 << v
+			}
+			for _, r := range req.AdmissionReasons {
+				v, err := lib.ParseReason(r)
+				checkErr(err, "parsing admission reasons")
+				c.AdmissionReasons |= 1 << v
+			}
+			for _, b := range req.AdmissionBlockTypes {
+				v, err := lib.ParseBlockType(b)
+				checkErr(err, "parsing admission block types")
+				c.AdmissionBlockTypes |= 1 << v
+			}
+		case lib.AdmitProbabilistic:
+			c.AdmissionProbability = req.AdmissionProbability
+			if c.AdmissionProbability == 0 {
+				c.AdmissionProbability = 0.5
+			}
+		case lib.AdmitFileLifetime:
+			c.AdmissionMinFileLifetimeSecs = req.AdmissionMinFileLifetimeSecs
+			if c.AdmissionMinFileLifetimeSecs == 0 {
+				c.AdmissionMinFileLifetimeSecs = 60
+			}
+		}
+		res = append(res, c)
+	}
+	return res
 }
 
 // TODO(josh): Consider returning a set of points to graph instead.
 type SimulateTraceResponse struct {
-	CacheSize []int `json:"cache_size"`
-	Results   []ResultsPerReplacementPolicy `json:"results_per_replacement_policy"`
+	// CacheSize is in entries, or in bytes if ByteWeighted or BlockSizes is
+	// set.
+	CacheSize    []int                         `json:"cache_size"`
+	ByteWeighted bool                          `json:"byte_weighted,omitempty"`
+	BlockSizes   []int64                       `json:"block_sizes,omitempty"`
+	Results      []ResultsPerReplacementPolicy `json:"results_per_replacement_policy"`
 }
 
 type ResultsPerReplacementPolicy struct {
 	ReplacementPolicy string `json:"replacement_policy"`
-	Results           []ResultsPerOptionSet `json:"results_per_option_set"`
+	// Reference is set for offline policies (OPT), which are an upper bound
+	// rather than a practical policy.
+	Reference bool                  `json:"reference,omitempty"`
+	Results   []ResultsPerOptionSet `json:"results_per_option_set"`
 }
 
 type ResultsPerOptionSet struct {
 	OptionSet string `json:"option_set"`
 	HitRate   []float64 `json:"hit_rate"`
+	// Cost contains the object store costs and read latencies for each cache
+	// size.
+	Cost []lib.Cost `json:"cost"`
+	// WastedCapacity is the average fraction of the cache occupied by blocks of
+	// deleted files; only set if the request enables deletion modeling.
+	WastedCapacity []float64 `json:"wasted_capacity,omitempty"`
+	// The fields below are only set if the request enables readahead.
+	ObjectStoreRequests []int     `json:"object_store_requests,omitempty"`
+	PrefetchAccuracy    []float64 `json:"prefetch_accuracy,omitempty"`
+	WastedPrefetchBytes []int64   `json:"wasted_prefetch_bytes,omitempty"`
+	// ObjectStoreBytes is set if the request enables readahead or sets block
+	// sizes, and BlockOverReadBytes and ReadHitRate if it sets block sizes (see
+	// lib.Results.BlockOverReadBytes and lib.Results.ReadHits). HitRate counts
+	// the blocks accessed by each read, whereas ReadHitRate counts a read as a
+	// hit only if all its blocks hit, so it is comparable across block sizes.
+	ObjectStoreBytes   []int64   `json:"object_store_bytes,omitempty"`
+	BlockOverReadBytes []int64   `json:"block_over_read_bytes,omitempty"`
+	ReadHitRate        []float64 `json:"read_hit_rate,omitempty"`
+	// The fields below are only set if the request enables coalescing.
+	OverReadBytes []int64                 `json:"over_read_bytes,omitempty"`
+	RequestSizes  [][]lib.HistogramBucket `json:"request_sizes,omitempty"`
 }
 
 var configs = []lib.Config{
@@ -172,46 +371,209 @@ var configs = []lib.Config{
 	},
 }
 
-// TODO(josh): Enable varying block size.
+var allPolicies = []lib.ReplacementPolicy{
+	lib.TinyLFU, lib.ClockPro, lib.S4LRU,
+	lib.LRU, lib.ARC, lib.LIRS, lib.TwoQ, lib.WTinyLFU, lib.S3FIFO, lib.SIEVE,
+	lib.OPT,
+}
+
+// optOptionSets returns the option sets to simulate with OPT. OPT ignores
+// deletions, so it is simulated once without deletion modeling rather than for
+// each deletion variant, and it doesn't prefetch, so it is only simulated
+// without readahead.
+func optOptionSets(optionSets []lib.Config) []lib.Config {
+	var res []lib.Config
+	for _, config := range optionSets {
+		if config.InvalidateOnDelete || config.Readahead != lib.ReadaheadNone {
+			continue
+		}
+		config.Deletion = lib.DeleteNone
+		config.DeletionGraceSecs = 0
+		config.DeletionsFile = ""
+		res = append(res, config)
+	}
+	return res
+}
+
 // TODO(josh): Enable measure hit rate over time.
 func Simulate(req SimulateTraceRequest) SimulateTraceResponse {
 	const (
-		start = 1024      // 1K
-		end = 1024 * 1000 // 1MB
+		start       = 1024        // 1K
+		end         = 1024 * 1000 // 1MB
 		targetTicks = 10
-		increment = (end - start) / targetTicks
+		increment   = (end - start) / targetTicks
+		// In byte-weighted mode, the sizes are scaled by a typical block size.
+		bytesPerEntry = 4096
 	)
 
-	resp := SimulateTraceResponse{}
+	md, err := store.Stat(req.Trace)
+	checkErr(err, fmt.Sprintf("loading trace %q", req.Trace))
+	configs := configs
+	if len(md.Sources) > 1 {
+		// For traces from multiple nodes, compare a shared cache against one
+		// cache per node.
+		configs = append(configs[:len(configs):len(configs)], lib.Config{
+			CachePerSource: true,
+		})
+	}
+	// Combine each option set with each admission policy.
+	var optionSets []lib.Config
+	for _, config := range configs {
+		if config.L5AndL6Only && md.NoLevels {
+			// The trace doesn't record levels, so the cache would see no
+			// accesses.
+			continue
+		}
+		optionSets = append(optionSets, withAdmission(config, req)...)
+	}
+	var deletion lib.DeletionMode
+	if req.Deletion != "" {
+		deletion, err = lib.ParseDeletionMode(req.Deletion)
+		checkErr(err, "parsing deletion mode")
+	}
+	if deletion != lib.DeleteNone {
+		var withDeletion []lib.Config
+		for _, config := range optionSets {
+			config.Deletion = deletion
+			config.DeletionGraceSecs = req.DeletionGraceSecs
+			config.DeletionsFile = req.DeletionsFile
+			withDeletion = append(withDeletion, config)
+			config.InvalidateOnDelete = true
+			withDeletion = append(withDeletion, config)
+		}
+		optionSets = withDeletion
+	}
+	if len(req.Readahead) > 0 {
+		blockSize := req.ReadaheadBlockSize
+		if blockSize == 0 {
+			blockSize = 32 * 1024
+		}
+		var withReadahead []lib.Config
+		for _, config := range optionSets {
+			config.BlockSize = blockSize
+			config.ReadaheadSize = req.ReadaheadSize
+			withReadahead = append(withReadahead, config)
+			for _, name := range req.Readahead {
+				mode, err := lib.ParseReadaheadMode(name)
+				checkErr(err, "parsing readahead mode")
+				if mode != lib.ReadaheadNone {
+					config.Readahead = mode
+					withReadahead = append(withReadahead, config)
+				}
+			}
+		}
+		optionSets = withReadahead
+	}
+	if len(req.BlockSizes) > 0 {
+		var withBlockSizes []lib.Config
+		for _, config := range optionSets {
+			for _, blockSize := range req.BlockSizes {
+				if blockSize <= 0 {
+					checkErr(fmt.Errorf("invalid block size %d", blockSize), "validating request")
+				}
+				config.BlockSize = blockSize
+				config.AllBlocks = true
+				withBlockSizes = append(withBlockSizes, config)
+			}
+		}
+		optionSets = withBlockSizes
+	}
+	needsInvalidation := deletion != lib.DeleteNone || len(req.Readahead) > 0
+	var files *lib.FileTable
+	for _, config := range optionSets {
+		if config.Admission == lib.AdmitFileLifetime || config.Deletion == lib.DeleteManifest ||
+			config.Readahead == lib.ReadaheadWholeFile {
+			files = fileTable(req.Trace)
+			break
+		}
+	}
+
+	var policies []lib.ReplacementPolicy
+	for _, p := range allPolicies {
+		if (!req.ByteWeighted || p.SupportsByteWeighted()) &&
+			(!needsInvalidation || p.SupportsInvalidation() || p == lib.OPT) {
+			policies = append(policies, p)
+		}
+	}
+	scale := 1
+	if req.ByteWeighted || len(req.BlockSizes) > 0 {
+		scale = bytesPerEntry
+	}
+
+	costModel := lib.DefaultCostModel
+	if req.CostModel != nil {
+		costModel = *req.CostModel
+	}
+	checkErr(costModel.Validate(), "validating cost model")
+
+	resp := SimulateTraceResponse{ByteWeighted: req.ByteWeighted, BlockSizes: req.BlockSizes}
 	for cacheSize := start; cacheSize < end; cacheSize += increment {
-		resp.CacheSize = append(resp.CacheSize, cacheSize)
+		resp.CacheSize = append(resp.CacheSize, cacheSize*scale)
 	}
-	for i, policy := range []lib.ReplacementPolicy{lib.TinyLFU, lib.ClockPro, lib.S4LRU} {
+	for i, policy := range policies {
 		resp.Results = append(resp.Results, ResultsPerReplacementPolicy{
 			ReplacementPolicy: policy.String(),
+			Reference:         policy == lib.OPT,
 		})
-		for j, config := range configs {
+		policyOptionSets := optionSets
+		if policy == lib.OPT {
+			policyOptionSets = optOptionSets(optionSets)
+		}
+		for j, config := range policyOptionSets {
 			config.Policy = policy
+			config.ByteWeighted = req.ByteWeighted
+			if policy != lib.OPT {
+				// OPT doesn't coalesce reads.
+				config.CoalesceWindow = time.Duration(req.CoalesceWindowMicros) * time.Microsecond
+				config.CoalesceMaxGap = req.CoalesceMaxGap
+			}
 			resp.Results[i].Results = append(resp.Results[i].Results, ResultsPerOptionSet{
 				OptionSet: config.String(),
 			})
 			for cacheSize := start; cacheSize < end; cacheSize += increment {
-				config.CacheSize = cacheSize
+				config.CacheSize = cacheSize * scale
+				if len(req.BlockSizes) > 0 && !req.ByteWeighted {
+					// The cache size is in bytes; convert it to entries of the
+					// block size (keeping at least one entry).
+					config.CacheSize = int(int64(config.CacheSize) / config.BlockSize)
+					if config.CacheSize == 0 {
+						config.CacheSize = 1
+					}
+				}
 				if policy == lib.TinyLFU {
 					config.TinyLFUSamples = 10 * config.CacheSize
 				}
 				func() {
-					log.Printf("simulate %s / %v / %s / %s\n", req.Trace, cacheSize, policy.String(), config.String())
+					log.Printf("simulate %s / %v / %s / %s\n", req.Trace, config.CacheSize, policy.String(), config.String())
 
-					_, it, err := lib.Load(req.Trace)
+					_, it, err := store.Open(req.Trace)
 					checkErr(err, fmt.Sprintf("loading trace %q", req.Trace))
 					defer it.Close()
 
-					results, err := lib.Simulate(req.Trace, it, config)
+					results, err := lib.Simulate(req.Trace, it, config, files)
 					checkErr(err, fmt.Sprintf("calling simulate %q", req.Trace))
-					hitRate := float64(results.Hits) / float64(results.Hits + results.Misses)
-
-					resp.Results[i].Results[j].HitRate = append(resp.Results[i].Results[j].HitRate, hitRate)
+					r := &resp.Results[i].Results[j]
+					r.HitRate = append(r.HitRate, results.HitRate())
+					r.Cost = append(r.Cost, costModel.Cost(results))
+					if config.Deletion != lib.DeleteNone {
+						r.WastedCapacity = append(r.WastedCapacity, results.WastedCapacity)
+					}
+					if len(req.Readahead) > 0 {
+						r.ObjectStoreRequests = append(r.ObjectStoreRequests, results.ObjectStoreRequests)
+						r.PrefetchAccuracy = append(r.PrefetchAccuracy, results.PrefetchAccuracy())
+						r.WastedPrefetchBytes = append(r.WastedPrefetchBytes, results.WastedPrefetchBytes)
+					}
+					if len(req.Readahead) > 0 || len(req.BlockSizes) > 0 {
+						r.ObjectStoreBytes = append(r.ObjectStoreBytes, results.ObjectStoreBytes)
+					}
+					if len(req.BlockSizes) > 0 {
+						r.BlockOverReadBytes = append(r.BlockOverReadBytes, results.BlockOverReadBytes)
+						r.ReadHitRate = append(r.ReadHitRate, results.ReadHitRate())
+					}
+					if config.CoalesceWindow > 0 {
+						r.OverReadBytes = append(r.OverReadBytes, results.OverReadBytes)
+						r.RequestSizes = append(r.RequestSizes, results.RequestSizes.Buckets())
+					}
 				}()
 			}
 		}
@@ -220,7 +582,358 @@ func Simulate(req SimulateTraceRequest) SimulateTraceResponse {
 	return resp
 }
 
+type ReuseRequest struct {
+	Trace string `json:"trace"`
+	// BlockSize is the granularity of the accesses (see lib.Config.BlockSize);
+	// if 0, accesses are tracked by offset.
+	BlockSize int64 `json:"block_size,omitempty"`
+}
+
+// ReuseResponse contains reuse histograms (see lib.AnalyzeReuse). All series
+// share the same buckets.
+type ReuseResponse struct {
+	// DistanceBuckets and TimeBucketsMicros are the upper bounds of the buckets
+	// of the reuse distance and reuse time histograms.
+	DistanceBuckets   []int64       `json:"distance_buckets"`
+	TimeBucketsMicros []int64       `json:"time_buckets_micros"`
+	Series            []ReuseSeries `json:"series"`
+}
+
+type ReuseSeries struct {
+	// Name is "all", or the level, reason or block type of the accesses (e.g.
+	// "level L6", "reason compaction" or "block data").
+	Name       string  `json:"name"`
+	Cold       int64   `json:"cold"`
+	Distance   []int64 `json:"distance"`
+	TimeMicros []int64 `json:"time_micros"`
+}
+
+func Reuse(req ReuseRequest) ReuseResponse {
+	_, it, err := store.Open(req.Trace)
+	checkErr(err, fmt.Sprintf("loading trace %q", req.Trace))
+	defer it.Close()
+	r, err := lib.AnalyzeReuse(it, req.BlockSize)
+	checkErr(err, fmt.Sprintf("analyzing reuse of %q", req.Trace))
+
+	// All the accesses are included in r.All, so its range covers all series.
+	distFirst, distLast := r.All.Distance.NonEmptyRange()
+	timeFirst, timeLast := r.All.TimeMicros.NonEmptyRange()
+	var resp ReuseResponse
+	for i := distFirst; i >= 0 && i <= distLast; i++ {
+		resp.DistanceBuckets = append(resp.DistanceBuckets, r.All.Distance.Bucket(i).Hi)
+	}
+	for i := timeFirst; i >= 0 && i <= timeLast; i++ {
+		resp.TimeBucketsMicros = append(resp.TimeBucketsMicros, r.All.TimeMicros.Bucket(i).Hi)
+	}
+	addSeries := func(name string, h *lib.ReuseHistograms) {
+		s := ReuseSeries{Name: name, Cold: h.Cold}
+		for i := distFirst; i >= 0 && i <= distLast; i++ {
+			s.Distance = append(s.Distance, h.Distance[i])
+		}
+		for i := timeFirst; i >= 0 && i <= timeLast; i++ {
+			s.TimeMicros = append(s.TimeMicros, h.TimeMicros[i])
+		}
+		resp.Series = append(resp.Series, s)
+	}
+	addSeries("all", &r.All)
+	for _, split := range []struct {
+		prefix string
+		m      map[string]*lib.ReuseHistograms
+	}{
+		{"level", r.ByLevel},
+		{"reason", r.ByReason},
+		{"block", r.ByBlockType},
+	} {
+		var names []string
+		for name := range split.m {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			addSeries(split.prefix+" "+name, split.m[name])
+		}
+	}
+	return resp
+}
+
+type FileAgeRequest struct {
+	Trace string `json:"trace"`
+	// BlockSize is the granularity of the accesses (see lib.Config.BlockSize);
+	// if 0, accesses are tracked by offset.
+	BlockSize int64 `json:"block_size,omitempty"`
+}
+
+// FileAgeResponse contains the file age statistics (see lib.AnalyzeFileAge).
+type FileAgeResponse struct {
+	// UnknownAgeReadBytes is the number of bytes read from files which were not
+	// written in the trace.
+	UnknownAgeReadBytes int64           `json:"unknown_age_read_bytes"`
+	Series              []FileAgeSeries `json:"series"`
+}
+
+type FileAgeSeries struct {
+	// Name is "all", or the level of the written files (e.g. "level L6").
+	Name                  string                `json:"name"`
+	FilesWritten          int64                 `json:"files_written"`
+	BytesWritten          int64                 `json:"bytes_written"`
+	FilesRead             int64                 `json:"files_read"`
+	BytesRead             int64                 `json:"bytes_read"`
+	ReadFraction          float64               `json:"read_fraction"`
+	TimeToFirstReadMicros []lib.HistogramBucket `json:"time_to_first_read_micros"`
+	ReadBytesByAgeSecs    []lib.HistogramBucket `json:"read_bytes_by_age_secs"`
+}
+
+func FileAge(req FileAgeRequest) FileAgeResponse {
+	_, it, err := store.Open(req.Trace)
+	checkErr(err, fmt.Sprintf("loading trace %q", req.Trace))
+	defer it.Close()
+	r, err := lib.AnalyzeFileAge(it, req.BlockSize)
+	checkErr(err, fmt.Sprintf("analyzing file age of %q", req.Trace))
+
+	resp := FileAgeResponse{UnknownAgeReadBytes: r.UnknownAgeReadBytes}
+	addSeries := func(name string, s *lib.FileAgeStats) {
+		resp.Series = append(resp.Series, FileAgeSeries{
+			Name:                  name,
+			FilesWritten:          s.FilesWritten,
+			BytesWritten:          s.BytesWritten,
+			FilesRead:             s.FilesRead,
+			BytesRead:             s.BytesRead,
+			ReadFraction:          s.ReadFraction(),
+			TimeToFirstReadMicros: s.TimeToFirstReadMicros.Buckets(),
+			ReadBytesByAgeSecs:    s.ReadBytesByAgeSecs.Buckets(),
+		})
+	}
+	addSeries("all", &r.All)
+	levels := make([]string, 0, len(r.ByLevel))
+	for l := range r.ByLevel {
+		levels = append(levels, l)
+	}
+	sort.Strings(levels)
+	for _, l := range levels {
+		addSeries("level "+l, r.ByLevel[l])
+	}
+	return resp
+}
+
+type SequentialRequest struct {
+	Trace string `json:"trace"`
+	// MaxGap is the maximum gap between sequential reads, in bytes (see
+	// lib.ClassifyAccesses); defaults to lib.DefaultSequentialGap.
+	MaxGap int64 `json:"max_gap,omitempty"`
+}
+
+// SequentialResponse contains the access pattern statistics (see
+// lib.ClassifyAccesses).
+type SequentialResponse struct {
+	// Summary is the summary stored in the trace metadata, if any.
+	Summary *lib.AccessPatternSummary `json:"summary,omitempty"`
+	Series  []SequentialSeries        `json:"series"`
+}
+
+type SequentialSeries struct {
+	// Name is "all", or the level or reason of the reads (e.g. "level L6" or
+	// "reason compaction").
+	Name                    string                `json:"name"`
+	Reads                   int64                 `json:"reads"`
+	Bytes                   int64                 `json:"bytes"`
+	SequentialReads         int64                 `json:"sequential_reads"`
+	SequentialBytes         int64                 `json:"sequential_bytes"`
+	SequentialFraction      float64               `json:"sequential_fraction"`
+	Runs                    int64                 `json:"runs"`
+	MeanSequentialRunLength float64               `json:"mean_sequential_run_length"`
+	RunLengths              []lib.HistogramBucket `json:"run_lengths"`
+	RunBytes                []lib.HistogramBucket `json:"run_bytes"`
+}
+
+func Sequential(req SequentialRequest) SequentialResponse {
+	md, it, err := store.Open(req.Trace)
+	checkErr(err, fmt.Sprintf("loading trace %q", req.Trace))
+	defer it.Close()
+	maxGap := req.MaxGap
+	if maxGap == 0 {
+		maxGap = lib.DefaultSequentialGap
+	}
+	r, err := lib.ClassifyAccesses(it, maxGap)
+	checkErr(err, fmt.Sprintf("classifying accesses of %q", req.Trace))
+
+	resp := SequentialResponse{Summary: md.AccessPattern}
+	addSeries := func(name string, s *lib.AccessPatternStats) {
+		resp.Series = append(resp.Series, SequentialSeries{
+			Name:                    name,
+			Reads:                   s.Reads,
+			Bytes:                   s.Bytes,
+			SequentialReads:         s.SequentialReads,
+			SequentialBytes:         s.SequentialBytes,
+			SequentialFraction:      s.SequentialFraction(),
+			Runs:                    s.Runs,
+			MeanSequentialRunLength: s.MeanSequentialRunLength(),
+			RunLengths:              s.RunLengths.Buckets(),
+			RunBytes:                s.RunBytes.Buckets(),
+		})
+	}
+	addSeries("all", &r.All)
+	for _, split := range []struct {
+		prefix string
+		m      map[string]*lib.AccessPatternStats
+	}{
+		{prefix: "level", m: r.ByLevel},
+		{prefix: "reason", m: r.ByReason},
+	} {
+		names := make([]string, 0, len(split.m))
+		for name := range split.m {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			addSeries(split.prefix+" "+name, split.m[name])
+		}
+	}
+	return resp
+}
+
+type HeatmapRequest struct {
+	Trace string `json:"trace"`
+	// TimeBuckets and FileBuckets are the size of the heatmap; they default to
+	// 200 columns and 200 rows.
+	TimeBuckets int `json:"time_buckets,omitempty"`
+	FileBuckets int `json:"file_buckets,omitempty"`
+	// Order is the order of the files (see lib.ParseHeatmapOrder); defaults to
+	// "file".
+	Order string `json:"order,omitempty"`
+	// TopN is the number of hottest files to list; defaults to 20.
+	TopN             int  `json:"top_n,omitempty"`
+	IncludeCacheHits bool `json:"include_cache_hits,omitempty"`
+}
+
+// Heatmap returns the heatmap of the bytes read from each file over time (see
+// lib.ComputeHeatmap).
+func Heatmap(req HeatmapRequest) *lib.Heatmap {
+	md, it, err := store.Open(req.Trace)
+	checkErr(err, fmt.Sprintf("loading trace %q", req.Trace))
+	defer it.Close()
+	startTime, err := time.Parse(time.RFC3339, md.StartTime)
+	checkErr(err, "parsing trace start time")
+
+	config := lib.HeatmapConfig{
+		StartUnixNano:    startTime.UnixNano(),
+		EndUnixNano:      startTime.Add(time.Duration(md.DurationSecs+1) * time.Second).UnixNano(),
+		TimeBuckets:      req.TimeBuckets,
+		FileBuckets:      req.FileBuckets,
+		TopN:             req.TopN,
+		IncludeCacheHits: req.IncludeCacheHits,
+	}
+	if config.TimeBuckets == 0 {
+		config.TimeBuckets = 200
+	}
+	if config.FileBuckets == 0 {
+		config.FileBuckets = 200
+	}
+	if config.TopN == 0 {
+		config.TopN = 20
+	}
+	if req.Order != "" {
+		config.Order, err = lib.ParseHeatmapOrder(req.Order)
+		checkErr(err, "parsing heatmap order")
+	}
+	h, err := lib.ComputeHeatmap(it, config)
+	checkErr(err, fmt.Sprintf("computing heatmap of %q", req.Trace))
+	return h
+}
+
+type TopKRequest struct {
+	Trace string `json:"trace"`
+	// K is the number of files and blocks to report; defaults to 100.
+	K                int   `json:"k,omitempty"`
+	ByBytes          bool  `json:"by_bytes,omitempty"`
+	BlockSize        int64 `json:"block_size,omitempty"`
+	IncludeCacheHits bool  `json:"include_cache_hits,omitempty"`
+	// StartSecs and EndSecs restrict the report to a time range, relative to
+	// the start of the trace; EndSecs = 0 means the end of the trace.
+	StartSecs float64 `json:"start_secs,omitempty"`
+	EndSecs   float64 `json:"end_secs,omitempty"`
+}
+
+// TopK returns the files and blocks with the most reads (see lib.TopK).
+func TopK(req TopKRequest) *lib.TopKReport {
+	md, it, err := store.Open(req.Trace)
+	checkErr(err, fmt.Sprintf("loading trace %q", req.Trace))
+	defer it.Close()
+	startTime, err := time.Parse(time.RFC3339, md.StartTime)
+	checkErr(err, "parsing trace start time")
+
+	filter := lib.EventFilter{
+		StartUnixNano: startTime.Add(time.Duration(req.StartSecs * float64(time.Second))).UnixNano(),
+	}
+	if req.EndSecs != 0 {
+		filter.EndUnixNano = startTime.Add(time.Duration(req.EndSecs * float64(time.Second))).UnixNano()
+	}
+	config := lib.TopKConfig{
+		K:                req.K,
+		ByBytes:          req.ByBytes,
+		BlockSize:        req.BlockSize,
+		IncludeCacheHits: req.IncludeCacheHits,
+	}
+	if config.K == 0 {
+		config.K = 100
+	}
+	r, err := lib.TopK(lib.FilterIterator(it, filter), config)
+	checkErr(err, fmt.Sprintf("finding top files of %q", req.Trace))
+	return r
+}
+
+type EpisodesRequest struct {
+	Trace string `json:"trace"`
+	// GapMillis is the maximum gap between the events of an episode (see
+	// lib.DetectEpisodes); defaults to lib.DefaultEpisodeGap.
+	GapMillis int64 `json:"gap_millis,omitempty"`
+	// Policy is the replacement policy of the simulated cache (see
+	// lib.ParseReplacementPolicy); if empty, no cache is simulated.
+	Policy string `json:"policy,omitempty"`
+	// CacheSize is in entries, or in bytes if ByteWeighted is set.
+	CacheSize    int   `json:"cache_size,omitempty"`
+	BlockSize    int64 `json:"block_size,omitempty"`
+	ByteWeighted bool  `json:"byte_weighted,omitempty"`
+	WriteThru    bool  `json:"write_thru,omitempty"`
+}
+
+// Episodes returns the flush and compaction episodes of a trace, and the cache
+// churn they cause (see lib.DetectEpisodes).
+func Episodes(req EpisodesRequest) *lib.EpisodeAnalysis {
+	_, it, err := store.Open(req.Trace)
+	checkErr(err, fmt.Sprintf("loading trace %q", req.Trace))
+	defer it.Close()
+
+	gap := lib.DefaultEpisodeGap
+	if req.GapMillis != 0 {
+		gap = time.Duration(req.GapMillis) * time.Millisecond
+	}
+	var config *lib.Config
+	if req.Policy != "" {
+		policy, err := lib.ParseReplacementPolicy(req.Policy)
+		checkErr(err, "parsing replacement policy")
+		config = &lib.Config{
+			Policy:       policy,
+			CacheSize:    req.CacheSize,
+			BlockSize:    req.BlockSize,
+			ByteWeighted: req.ByteWeighted,
+			WriteThru:    req.WriteThru,
+		}
+		if config.ByteWeighted && !policy.SupportsByteWeighted() {
+			checkErr(fmt.Errorf("%s does not support byte-weighted capacity", policy), "validating request")
+		}
+		if policy == lib.TinyLFU {
+			config.TinyLFUSamples = 10 * config.CacheSize
+		}
+	}
+	r, err := lib.DetectEpisodes(it, gap, config)
+	checkErr(err, fmt.Sprintf("detecting episodes of %q", req.Trace))
+	return r
+}
+
 func main() {
+	flag.Parse()
+	store = lib.NewDirStore(strings.Split(*tracesDirs, ",")...)
+
 	http.HandleFunc("/list", func(w http.ResponseWriter, r *http.Request) {
 		log.Printf("list\n")
 		w.Header().Add("Access-Control-Allow-Origin", "*")
@@ -238,7 +951,10 @@ func main() {
 		checkErr(err, "reading body")
 		var req PlotTraceRequest
 		checkErr(json.Unmarshal(reqBuf, &req), "unmarshalling request")
-		log.Printf("plot %s\n", req.Trace)
+		log.Printf(
+			"plot %s (metric: %q, group by: %q, range: %gs-%gs, tick: %gs)\n",
+			req.Trace, req.Metric, req.GroupBy, req.StartSecs, req.EndSecs, req.TickSecs,
+		)
 		res := Plot(req)
 
 		respBuf, err := json.Marshal(&res)
@@ -246,14 +962,107 @@ func main() {
 		_, _ = w.Write(respBuf)
 	})
 
+	http.HandleFunc("/reuse", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Access-Control-Allow-Origin", "*")
+
+		reqBuf, err := io.ReadAll(r.Body)
+		checkErr(err, "reading body")
+		var req ReuseRequest
+		checkErr(json.Unmarshal(reqBuf, &req), "unmarshalling request")
+		log.Printf("reuse %s\n", req.Trace)
+		res := Reuse(req)
+
+		respBuf, err := json.Marshal(&res)
+		checkErr(err, "marshalling response")
+		_, _ = w.Write(respBuf)
+	})
+
+	http.HandleFunc("/fileage", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Access-Control-Allow-Origin", "*")
+
+		reqBuf, err := io.ReadAll(r.Body)
+		checkErr(err, "reading body")
+		var req FileAgeRequest
+		checkErr(json.Unmarshal(reqBuf, &req), "unmarshalling request")
+		log.Printf("fileage %s\n", req.Trace)
+		res := FileAge(req)
+
+		respBuf, err := json.Marshal(&res)
+		checkErr(err, "marshalling response")
+		_, _ = w.Write(respBuf)
+	})
+
+	http.HandleFunc("/sequential", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Access-Control-Allow-Origin", "*")
+
+		reqBuf, err := io.ReadAll(r.Body)
+		checkErr(err, "reading body")
+		var req SequentialRequest
+		checkErr(json.Unmarshal(reqBuf, &req), "unmarshalling request")
+		log.Printf("sequential %s\n", req.Trace)
+		res := Sequential(req)
+
+		respBuf, err := json.Marshal(&res)
+		checkErr(err, "marshalling response")
+		_, _ = w.Write(respBuf)
+	})
+
+	http.HandleFunc("/heatmap", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Access-Control-Allow-Origin", "*")
+
+		reqBuf, err := io.ReadAll(r.Body)
+		checkErr(err, "reading body")
+		var req HeatmapRequest
+		checkErr(json.Unmarshal(reqBuf, &req), "unmarshalling request")
+		log.Printf("heatmap %s (order: %q)\n", req.Trace, req.Order)
+		res := Heatmap(req)
+
+		respBuf, err := json.Marshal(res)
+		checkErr(err, "marshalling response")
+		_, _ = w.Write(respBuf)
+	})
+
+	http.HandleFunc("/topk", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Access-Control-Allow-Origin", "*")
+
+		reqBuf, err := io.ReadAll(r.Body)
+		checkErr(err, "reading body")
+		var req TopKRequest
+		checkErr(json.Unmarshal(reqBuf, &req), "unmarshalling request")
+		log.Printf("topk %s (k: %d)\n", req.Trace, req.K)
+		res := TopK(req)
+
+		respBuf, err := json.Marshal(res)
+		checkErr(err, "marshalling response")
+		_, _ = w.Write(respBuf)
+	})
+
+	http.HandleFunc("/episodes", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Access-Control-Allow-Origin", "*")
+
+		reqBuf, err := io.ReadAll(r.Body)
+		checkErr(err, "reading body")
+		var req EpisodesRequest
+		checkErr(json.Unmarshal(reqBuf, &req), "unmarshalling request")
+		log.Printf("episodes %s (policy: %q)\n", req.Trace, req.Policy)
+		res := Episodes(req)
+
+		respBuf, err := json.Marshal(res)
+		checkErr(err, "marshalling response")
+		_, _ = w.Write(respBuf)
+	})
+
 	http.HandleFunc("/simulate", func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Add("Access-Control-Allow-Origin", "*")
 
 		reqBuf, err := io.ReadAll(r.Body)
 		checkErr(err, "reading body")
-		var req SimulateTraceRequest
+		// Unmarshalling into a copy of the default cost model overrides only
+		// the fields that are set.
+		defaultCostModel := lib.DefaultCostModel
+		req := SimulateTraceRequest{CostModel: &defaultCostModel}
 		checkErr(json.Unmarshal(reqBuf, &req), "unmarshalling request")
-		log.Printf("simulate %s\n", req.Trace)
+		log.Printf("simulate %s (byte-weighted: %t)\n", req.Trace, req.ByteWeighted)
 		res := Simulate(req)
 
 		respBuf, err := json.Marshal(&res)
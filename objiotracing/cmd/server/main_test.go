@@ -0,0 +1,145 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/RaduBerinde/pebble_analysis/objiotracing/lib"
+	"github.com/stretchr/testify/require"
+)
+
+// addImportedTrace sets up a store with a small imported trace.
+func addImportedTrace(t *testing.T) {
+	store = lib.NewDirStore(t.TempDir())
+	im, err := lib.NewImporter(lib.ImportOptions{Format: lib.ImportCSV})
+	require.NoError(t, err)
+	var buf strings.Builder
+	for i := 0; i < 200; i++ {
+		fmt.Fprintf(&buf, "%d,obj%d,%d,4096,r\n", i, i%7, (i%5)*4096)
+	}
+	require.NoError(t, im.Add(strings.NewReader(buf.String())))
+	events, _, _ := im.Finish()
+	require.NoError(t, store.Add(lib.TraceMetadata{Name: "imported"}, lib.SliceIterator(events)))
+}
+
+// findPolicy returns the results of the given replacement policy.
+func findPolicy(
+	t *testing.T, res SimulateTraceResponse, policy lib.ReplacementPolicy,
+) ResultsPerReplacementPolicy {
+	for _, p := range res.Results {
+		if p.ReplacementPolicy == policy.String() {
+			return p
+		}
+	}
+	t.Fatalf("no results for %s", policy)
+	return ResultsPerReplacementPolicy{}
+}
+
+func TestSimulateImportedTrace(t *testing.T) {
+	addImportedTrace(t)
+	// Imported traces don't record levels.
+	md, err := store.Stat("imported")
+	require.NoError(t, err)
+	require.True(t, md.NoLevels)
+
+	res := Simulate(SimulateTraceRequest{Trace: "imported"})
+	_, err = json.Marshal(&res)
+	require.NoError(t, err)
+	require.NotEmpty(t, res.Results)
+	for _, p := range res.Results {
+		require.NotEmpty(t, p.Results)
+		for _, o := range p.Results {
+			require.NotContains(t, o.OptionSet, "L5AndL6Only:true")
+			for _, hitRate := range o.HitRate {
+				require.False(t, math.IsNaN(hitRate))
+			}
+		}
+	}
+}
+
+func TestSimulateOPTDeletion(t *testing.T) {
+	addImportedTrace(t)
+	res := Simulate(SimulateTraceRequest{Trace: "imported", Deletion: "last-access"})
+	lru := findPolicy(t, res, lib.LRU)
+	opt := findPolicy(t, res, lib.OPT)
+	require.True(t, opt.Reference)
+	// OPT ignores deletions, so it is only simulated once per option set.
+	require.Len(t, opt.Results, len(lru.Results)/2)
+	for _, o := range opt.Results {
+		require.NotContains(t, o.OptionSet, "InvalidateOnDelete:true")
+		require.Empty(t, o.WastedCapacity)
+	}
+	for _, o := range lru.Results {
+		require.NotEmpty(t, o.WastedCapacity)
+	}
+}
+
+func TestSimulateOPTReadahead(t *testing.T) {
+	addImportedTrace(t)
+	res := Simulate(SimulateTraceRequest{Trace: "imported", Readahead: []string{"fixed"}})
+	lru := findPolicy(t, res, lib.LRU)
+	opt := findPolicy(t, res, lib.OPT)
+	// OPT doesn't prefetch, so it is only simulated without readahead.
+	require.Len(t, opt.Results, len(lru.Results)/2)
+	for _, o := range opt.Results {
+		require.Contains(t, o.OptionSet, "Readahead:none")
+	}
+}
+
+func TestSimulateOPTCoalescing(t *testing.T) {
+	addImportedTrace(t)
+	res := Simulate(SimulateTraceRequest{Trace: "imported", CoalesceWindowMicros: 1000})
+	for _, o := range findPolicy(t, res, lib.LRU).Results {
+		require.NotEmpty(t, o.RequestSizes)
+	}
+	// OPT doesn't coalesce reads.
+	for _, o := range findPolicy(t, res, lib.OPT).Results {
+		require.Contains(t, o.OptionSet, "CoalesceWindow:0s")
+		require.Empty(t, o.RequestSizes)
+		require.Empty(t, o.OverReadBytes)
+	}
+}
+
+func TestPlotWorkingSet(t *testing.T) {
+	addImportedTrace(t)
+	res := Plot(PlotTraceRequest{Trace: "imported"})
+	require.NotEmpty(t, res.Series)
+	require.Empty(t, res.WorkingSet)
+	res = Plot(PlotTraceRequest{Trace: "imported", WorkingSet: true})
+	require.NotEmpty(t, res.WorkingSet)
+}
+
+func TestSimulateBlockSizes(t *testing.T) {
+	addImportedTrace(t)
+	res := Simulate(SimulateTraceRequest{Trace: "imported", BlockSizes: []int64{1024, 16384}})
+	for _, p := range res.Results {
+		for _, o := range p.Results {
+			require.Len(t, o.ReadHitRate, len(res.CacheSize))
+			require.Len(t, o.BlockOverReadBytes, len(res.CacheSize))
+		}
+	}
+}
+
+func TestSimulateManifestConcurrent(t *testing.T) {
+	addImportedTrace(t)
+	// Requests which load the sstable metadata can run concurrently.
+	var wg sync.WaitGroup
+	responses := make([]SimulateTraceResponse, 2)
+	for i := range responses {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			responses[i] = Simulate(SimulateTraceRequest{Trace: "imported", Deletion: "manifest"})
+		}(i)
+	}
+	wg.Wait()
+	for _, res := range responses {
+		for _, o := range findPolicy(t, res, lib.LRU).Results {
+			require.NotEmpty(t, o.WastedCapacity)
+		}
+	}
+}
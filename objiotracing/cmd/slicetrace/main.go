@@ -0,0 +1,171 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/RaduBerinde/pebble_analysis/objiotracing/lib"
+)
+
+var (
+	tracesDirs = flag.String("traces", "traces", "comma-separated list of trace library directories; new traces are added to the first one")
+
+	start = flag.String("start", "", "start of the time range, either as an offset from the trace start (e.g. 10m) or as an RFC3339 time")
+	end   = flag.String("end", "", "end of the time range, either as an offset from the trace start (e.g. 1h) or as an RFC3339 time")
+
+	ops        = flag.String("ops", "", "comma-separated list of ops (read, write, cache-hit, max-readahead)")
+	reasons    = flag.String("reasons", "", "comma-separated list of reasons (unknown, flush, compaction, ingestion)")
+	blockTypes = flag.String("block-types", "", "comma-separated list of block types (unknown, data, value, filter, metadata)")
+	levels     = flag.String("levels", "", "comma-separated list of LSM levels (e.g. L5,L6)")
+	files      = flag.String("files", "", "comma-separated list of file numbers")
+	sources    = flag.String("sources", "", "comma-separated list of sources (nodes/stores)")
+
+	export = flag.String("export", "", "export the events in the given format (csv or json) instead of creating a new trace")
+	output = flag.String("o", "", "output file for -export (default stdout)")
+)
+
+const usage = `usage: slicetrace [flags] <trace> <new-trace-name>
+       slicetrace [flags] -export csv|json [-o <file>] <trace>
+
+Creates a new trace in the library with the subset of events of <trace> that
+pass the filters, or exports these events for use in other tools.
+
+Flags:`
+
+func main() {
+	flag.Usage = func() {
+		fmt.Fprintln(flag.CommandLine.Output(), usage)
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if (*export == "" && flag.NArg() != 2) || (*export != "" && flag.NArg() != 1) {
+		flag.Usage()
+		os.Exit(1)
+	}
+	store := lib.NewDirStore(strings.Split(*tracesDirs, ",")...)
+	trace := flag.Arg(0)
+
+	md, it, err := store.Open(trace)
+	checkErr(err)
+	filter, err := makeFilter(&md)
+	checkErr(err)
+	it = lib.FilterIterator(it, filter)
+	defer it.Close()
+
+	if *export != "" {
+		var w io.Writer = os.Stdout
+		if *output != "" {
+			f, err := os.Create(*output)
+			checkErr(err)
+			defer f.Close()
+			w = f
+		}
+		switch *export {
+		case "csv":
+			checkErr(lib.ExportCSV(w, &md, it))
+		case "json":
+			checkErr(lib.ExportJSON(w, &md, it))
+		default:
+			checkErr(fmt.Errorf("invalid export format %q", *export))
+		}
+		return
+	}
+
+	md.Name = flag.Arg(1)
+	fmt.Printf("Writing trace %q..\n", md.Name)
+	checkErr(store.Add(md, it))
+	md, err = store.Stat(md.Name)
+	checkErr(err)
+	fmt.Printf("Wrote %d events (%ds starting at %s)\n", md.NumEvents, md.DurationSecs, md.StartTime)
+	if ap := md.AccessPattern; ap != nil {
+		fmt.Printf("%.1f%% of the bytes were read sequentially (mean run length: %.1f reads)\n",
+			ap.SequentialFraction*100, ap.MeanSequentialRunLength)
+	}
+}
+
+// makeFilter creates an EventFilter from the flags.
+func makeFilter(md *lib.TraceMetadata) (lib.EventFilter, error) {
+	var f lib.EventFilter
+	traceStart, err := time.Parse(time.RFC3339, md.StartTime)
+	if err != nil {
+		return f, err
+	}
+	if f.StartUnixNano, err = parseTime(*start, traceStart); err != nil {
+		return f, err
+	}
+	if f.EndUnixNano, err = parseTime(*end, traceStart); err != nil {
+		return f, err
+	}
+	if f.Ops, err = parseList(*ops, lib.ParseOp); err != nil {
+		return f, err
+	}
+	if f.Reasons, err = parseList(*reasons, lib.ParseReason); err != nil {
+		return f, err
+	}
+	if f.BlockTypes, err = parseList(*blockTypes, lib.ParseBlockType); err != nil {
+		return f, err
+	}
+	if f.LevelsPlusOne, err = parseList(*levels, lib.ParseLevel); err != nil {
+		return f, err
+	}
+	if f.FileNums, err = parseList(*files, func(s string) (uint64, error) {
+		return strconv.ParseUint(s, 10, 64)
+	}); err != nil {
+		return f, err
+	}
+	if f.Sources, err = parseList(*sources, func(s string) (uint32, error) {
+		for i, name := range md.Sources {
+			if name == s {
+				return uint32(i + 1), nil
+			}
+		}
+		return 0, fmt.Errorf("trace has no source %q", s)
+	}); err != nil {
+		return f, err
+	}
+	return f, nil
+}
+
+// parseTime parses a time which is either an offset from the start of the
+// trace or an absolute RFC3339 time. Returns 0 if the string is empty.
+func parseTime(s string, traceStart time.Time) (int64, error) {
+	if s == "" {
+		return 0, nil
+	}
+	if d, err := time.ParseDuration(s); err == nil {
+		return traceStart.Add(d).UnixNano(), nil
+	}
+	t, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		return 0, errors.New("time must be a duration (e.g. 10m) or an RFC3339 time")
+	}
+	return t.UnixNano(), nil
+}
+
+func parseList[T any](s string, parse func(string) (T, error)) ([]T, error) {
+	if s == "" {
+		return nil, nil
+	}
+	var res []T
+	for _, part := range strings.Split(s, ",") {
+		v, err := parse(strings.TrimSpace(part))
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, v)
+	}
+	return res, nil
+}
+
+func checkErr(err error) {
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/RaduBerinde/pebble_analysis/objiotracing/lib"
+)
+
+var (
+	tracesDirs = flag.String("traces", "traces", "comma-separated list of trace library directories")
+
+	start = flag.String("start", "", "start of the time range, either as an offset from the trace start (e.g. 10m) or as an RFC3339 time")
+	end   = flag.String("end", "", "end of the time range, either as an offset from the trace start (e.g. 1h) or as an RFC3339 time")
+
+	k         = flag.Int("k", 100, "number of files and blocks to report")
+	byBytes   = flag.Bool("by-bytes", false, "rank by bytes read instead of number of reads")
+	blockSize = flag.Int64("block-size", 0, "block granularity in bytes (default: by offset)")
+	cacheHits = flag.Bool("cache-hits", false, "also count reads that hit Pebble's block cache")
+	jsonOut   = flag.Bool("json", false, "print the report as JSON")
+)
+
+const usage = `usage: topk [flags] <trace>
+
+Prints the sstables and blocks which account for the most reads in a trace (or
+in a time range of it), with their level, reason mix, first and last access and
+byte totals. Uses a space-saving sketch, so the counts of files and blocks
+which were not tracked from their first read can be overestimated by up to the
+reported error.
+
+Flags:`
+
+func main() {
+	flag.Usage = func() {
+		fmt.Fprintln(flag.CommandLine.Output(), usage)
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() != 1 {
+		flag.Usage()
+		os.Exit(1)
+	}
+	if *k <= 0 {
+		checkErr(errors.New("-k must be positive"))
+	}
+	store := lib.NewDirStore(strings.Split(*tracesDirs, ",")...)
+
+	md, it, err := store.Open(flag.Arg(0))
+	checkErr(err)
+	traceStart, err := time.Parse(time.RFC3339, md.StartTime)
+	checkErr(err)
+	var filter lib.EventFilter
+	filter.StartUnixNano, err = parseTime(*start, traceStart)
+	checkErr(err)
+	filter.EndUnixNano, err = parseTime(*end, traceStart)
+	checkErr(err)
+	it = lib.FilterIterator(it, filter)
+	defer it.Close()
+
+	r, err := lib.TopK(it, lib.TopKConfig{
+		K:                *k,
+		ByBytes:          *byBytes,
+		BlockSize:        *blockSize,
+		IncludeCacheHits: *cacheHits,
+	})
+	checkErr(err)
+
+	if *jsonOut {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		checkErr(enc.Encode(r))
+		return
+	}
+	fmt.Printf("Top files:\n")
+	printHitters(r.Files, traceStart)
+	fmt.Printf("\nTop blocks:\n")
+	printHitters(r.Blocks, traceStart)
+}
+
+func printHitters(hitters []lib.HeavyHitter, traceStart time.Time) {
+	w := tabwriter.NewWriter(os.Stdout, 2, 1, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintf(w, "file\toffset\tlevel\treads\tMB\terror\tfirst\tlast\treasons\t\n")
+	for _, h := range hitters {
+		offset := "-"
+		if h.Offset >= 0 {
+			offset = fmt.Sprint(h.Offset)
+		}
+		reasons := make([]string, 0, len(h.Reasons))
+		for r, n := range h.Reasons {
+			reasons = append(reasons, fmt.Sprintf("%s:%d", r, n))
+		}
+		sort.Strings(reasons)
+		fmt.Fprintf(w, "%s\t%s\t%s\t%d\t%.2f\t%d\t%s\t%s\t%s\t\n",
+			h.File, offset, h.Level, h.Reads, float64(h.Bytes)/(1024*1024), h.MaxError,
+			offsetString(h.FirstAccessUnixNano, traceStart), offsetString(h.LastAccessUnixNano, traceStart),
+			strings.Join(reasons, " "),
+		)
+	}
+	checkErr(w.Flush())
+}
+
+// offsetString formats a time as an offset from the start of the trace.
+func offsetString(unixNano int64, traceStart time.Time) string {
+	return time.Unix(0, unixNano).Sub(traceStart).Round(time.Millisecond).String()
+}
+
+// parseTime parses a time which is either an offset from the start of the
+// trace or an absolute RFC3339 time. Returns 0 if the string is empty.
+func parseTime(s string, traceStart time.Time) (int64, error) {
+	if s == "" {
+		return 0, nil
+	}
+	if d, err := time.ParseDuration(s); err == nil {
+		return traceStart.Add(d).UnixNano(), nil
+	}
+	t, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		return 0, errors.New("time must be a duration (e.g. 10m) or an RFC3339 time")
+	}
+	return t.UnixNano(), nil
+}
+
+func checkErr(err error) {
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,3 @@
+#!/bin/sh
+
+go run ./cmd/importtrace $*
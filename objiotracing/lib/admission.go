@@ -0,0 +1,285 @@
+package lib
+
+import (
+	"fmt"
+	"math/rand"
+	"time"
+)
+
+// AdmissionPolicy decides whether a block that is missing from the cache
+// (or is written, with Config.WriteThru) gets inserted. It is independent of
+// the ReplacementPolicy, which decides what to evict.
+type AdmissionPolicy int
+
+const (
+	// AdmitAlways inserts all blocks.
+	AdmitAlways AdmissionPolicy = iota
+	// AdmitSecondHitBloom inserts a block only if it was accessed before
+	// recently, as tracked by a pair of rotating bloom filters.
+	AdmitSecondHitBloom
+	// AdmitSecondHitGhost is like AdmitSecondHitBloom, but tracks recent
+	// accesses exactly, using an LRU list of keys.
+	AdmitSecondHitGhost
+	// AdmitTinyLFU inserts a block only if its estimated recent access
+	// frequency (using a doorkeeper bloom filter and a count-min sketch) is at
+	// least Config.AdmissionMinFrequency.
+	AdmitTinyLFU
+	// AdmitSizeThreshold inserts a block only if its size is at most
+	// Config.AdmissionMaxSize.
+	AdmitSizeThreshold
+	// AdmitPredicate inserts a block only if its level, reason and block type
+	// are allowed by Config.AdmissionLevels, AdmissionReasons and
+	// AdmissionBlockTypes.
+	AdmitPredicate
+	// AdmitProbabilistic inserts a block with probability
+	// Config.AdmissionProbability.
+	AdmitProbabilistic
+	// AdmitFileLifetime inserts a block only if its file is not deleted within
+	// Config.AdmissionMinFileLifetimeSecs of the access, according to the
+	// sstable metadata recovered from the MANIFEST (see Simulate). Blocks of
+	// files without metadata are inserted.
+	AdmitFileLifetime
+)
+
+var admissionPolicyNames = []string{
+	AdmitAlways:         "always",
+	AdmitSecondHitBloom: "second-hit-bloom",
+	AdmitSecondHitGhost: "second-hit-ghost",
+	AdmitTinyLFU:        "tinylfu",
+	AdmitSizeThreshold:  "size-threshold",
+	AdmitPredicate:      "predicate",
+	AdmitProbabilistic:  "probabilistic",
+	AdmitFileLifetime:   "file-lifetime",
+}
+
+func (p AdmissionPolicy) String() string {
+	return enumName(admissionPolicyNames, uint8(p))
+}
+
+// ParseAdmissionPolicy parses an admission policy name (e.g. "second-hit-bloom").
+func ParseAdmissionPolicy(s string) (AdmissionPolicy, error) {
+	v, err := parseEnum("admission policy", admissionPolicyNames, s)
+	return AdmissionPolicy(v), err
+}
+
+// admitter implements an admission policy. Admitters see all accesses (hits
+// included), so their decisions only depend on the sequence of accesses and not
+// on the state of the cache.
+type admitter interface {
+	// admit records an access and returns true if the block should be inserted
+	// into the cache, were it missing.
+	admit(a *access) bool
+}
+
+func newAdmitter(config *Config, files *FileTable) admitter {
+	// Estimate the number of entries in a full cache; the filters remember
+	// about as many recent keys.
+	entries := config.CacheSize
+	if config.ByteWeighted {
+		entries = config.CacheSize / typicalEntrySize
+	}
+	if entries < 16 {
+		entries = 16
+	}
+	switch config.Admission {
+	case AdmitAlways:
+		return admitAlways{}
+	case AdmitSecondHitBloom:
+		return newSecondHitBloom(entries)
+	case AdmitSecondHitGhost:
+		return &secondHitGhost{ghost: newLRUCache(int64(entries))}
+	case AdmitTinyLFU:
+		minFreq := config.AdmissionMinFrequency
+		if minFreq == 0 {
+			minFreq = 2
+		}
+		return &tinyLFUAdmitter{
+			doorkeeper: newBloomFilter(entries),
+			sketch:     newFreqSketch(entries),
+			minFreq:    minFreq,
+		}
+	case AdmitSizeThreshold:
+		return sizeThresholdAdmitter{maxSize: config.AdmissionMaxSize}
+	case AdmitPredicate:
+		return predicateAdmitter{
+			levels:     config.AdmissionLevels,
+			reasons:    config.AdmissionReasons,
+			blockTypes: config.AdmissionBlockTypes,
+		}
+	case AdmitProbabilistic:
+		return &probabilisticAdmitter{
+			// Use a fixed seed, so that results are reproducible.
+			rng:         rand.New(rand.NewSource(1)),
+			probability: config.AdmissionProbability,
+		}
+	case AdmitFileLifetime:
+		return fileLifetimeAdmitter{
+			files:       files,
+			minLifetime: int64(config.AdmissionMinFileLifetimeSecs) * int64(time.Second),
+		}
+	default:
+		panic(fmt.Sprintf("admission policy %d not implemented", config.Admission))
+	}
+}
+
+type admitAlways struct{}
+
+func (admitAlways) admit(*access) bool { return true }
+
+// secondHitBloom remembers recently accessed keys in two bloom filters: keys
+// are added to the current filter and looked up in both. When the current
+// filter is full, it becomes the previous filter and a new one is started.
+type secondHitBloom struct {
+	entries       int
+	current, prev *bloomFilter
+	added         int
+}
+
+func newSecondHitBloom(entries int) *secondHitBloom {
+	return &secondHitBloom{
+		entries: entries,
+		current: newBloomFilter(entries),
+		prev:    newBloomFilter(entries),
+	}
+}
+
+func (s *secondHitBloom) admit(a *access) bool {
+	h := a.id.hash()
+	if s.current.contains(h) {
+		return true
+	}
+	seen := s.prev.contains(h)
+	s.current.add(h)
+	s.added++
+	if s.added >= s.entries {
+		s.prev, s.current = s.current, s.prev
+		s.current.reset()
+		s.added = 0
+	}
+	return seen
+}
+
+type secondHitGhost struct {
+	ghost *lruCache
+}
+
+func (s *secondHitGhost) admit(a *access) bool {
+	k := a.id.key()
+	if s.ghost.Get(k) != nil {
+		return true
+	}
+	s.ghost.Set(k, true)
+	return false
+}
+
+// tinyLFUAdmitter uses the TinyLFU frequency estimation: the first access to a
+// key only sets its bit in the doorkeeper, and subsequent accesses are counted
+// in the sketch. The doorkeeper is cleared whenever the sketch is aged.
+type tinyLFUAdmitter struct {
+	doorkeeper *bloomFilter
+	sketch     *freqSketch
+	minFreq    int
+}
+
+func (t *tinyLFUAdmitter) admit(a *access) bool {
+	h := a.id.hash()
+	k := a.id.key()
+	if !t.doorkeeper.contains(h) {
+		t.doorkeeper.add(h)
+	} else if t.sketch.increment(k) {
+		t.doorkeeper.reset()
+	}
+	freq := int(t.sketch.estimate(k))
+	if t.doorkeeper.contains(h) {
+		freq++
+	}
+	return freq >= t.minFreq
+}
+
+type sizeThresholdAdmitter struct {
+	maxSize int64
+}
+
+func (s sizeThresholdAdmitter) admit(a *access) bool {
+	return a.size <= s.maxSize
+}
+
+type predicateAdmitter struct {
+	levels, reasons, blockTypes uint8
+}
+
+func (p predicateAdmitter) admit(a *access) bool {
+	match := func(mask uint8, v uint8) bool {
+		return mask == 0 || mask&(1<<v) != 0
+	}
+	return match(p.levels, a.event.LevelPlusOne) &&
+		match(p.reasons, uint8(a.event.Reason)) &&
+		match(p.blockTypes, uint8(a.event.BlockType))
+}
+
+type probabilisticAdmitter struct {
+	rng         *rand.Rand
+	probability float64
+}
+
+func (p *probabilisticAdmitter) admit(*access) bool {
+	return p.rng.Float64() < p.probability
+}
+
+type fileLifetimeAdmitter struct {
+	files       *FileTable
+	minLifetime int64
+}
+
+func (f fileLifetimeAdmitter) admit(a *access) bool {
+	m := f.files.Lookup(a.id.source, a.id.fileNum)
+	if m == nil || !m.Deleted || m.DeletionTime == 0 {
+		return true
+	}
+	return m.DeletionTime*int64(time.Second)-a.event.StartUnixNano >= f.minLifetime
+}
+
+// bloomFilter is a simple bloom filter with 4 hash functions and about 8 bits
+// per expected entry.
+type bloomFilter struct {
+	bits []uint64
+	mask uint64
+}
+
+func newBloomFilter(entries int) *bloomFilter {
+	numBits := 64
+	for numBits < 8*entries {
+		numBits *= 2
+	}
+	return &bloomFilter{
+		bits: make([]uint64, numBits/64),
+		mask: uint64(numBits - 1),
+	}
+}
+
+func (b *bloomFilter) bit(h uint64, i int) uint64 {
+	return (h + uint64(i)*((h>>32)|1)) & b.mask
+}
+
+func (b *bloomFilter) add(h uint64) {
+	for i := 0; i < 4; i++ {
+		bit := b.bit(h, i)
+		b.bits[bit/64] |= 1 << (bit % 64)
+	}
+}
+
+func (b *bloomFilter) contains(h uint64) bool {
+	for i := 0; i < 4; i++ {
+		bit := b.bit(h, i)
+		if b.bits[bit/64]&(1<<(bit%64)) == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+func (b *bloomFilter) reset() {
+	for i := range b.bits {
+		b.bits[i] = 0
+	}
+}
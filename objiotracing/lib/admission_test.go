@@ -0,0 +1,61 @@
+package lib
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/cockroachdb/pebble/objstorage/objstorageprovider/objiotracing"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseAdmissionPolicy(t *testing.T) {
+	for p := AdmitAlways; p <= AdmitFileLifetime; p++ {
+		parsed, err := ParseAdmissionPolicy(p.String())
+		require.NoError(t, err)
+		require.Equal(t, p, parsed)
+	}
+	_, err := ParseAdmissionPolicy("foo")
+	require.Error(t, err)
+}
+
+func TestAdmission(t *testing.T) {
+	read := func(fileNum uint64, levelPlusOne uint8, size int64) objiotracing.Event {
+		e := objiotracing.Event{Op: objiotracing.ReadOp, LevelPlusOne: levelPlusOne, Size: size}
+		setFileNum(&e.FileNum, fileNum)
+		return e
+	}
+	// File 1 is read three times, file 2 (which is large and in L6) twice.
+	trace := []objiotracing.Event{
+		read(1, 1, 100), read(2, 7, 1000), read(1, 1, 100), read(2, 7, 1000), read(1, 1, 100),
+	}
+
+	testCases := []struct {
+		config Config
+		hits   int
+	}{
+		{config: Config{Admission: AdmitAlways}, hits: 3},
+		// Blocks are only inserted on the second miss.
+		{config: Config{Admission: AdmitSecondHitBloom}, hits: 1},
+		{config: Config{Admission: AdmitSecondHitGhost}, hits: 1},
+		{config: Config{Admission: AdmitTinyLFU}, hits: 1},
+		{config: Config{Admission: AdmitTinyLFU, AdmissionMinFrequency: 3}, hits: 0},
+		{config: Config{Admission: AdmitSizeThreshold, AdmissionMaxSize: 500}, hits: 2},
+		{config: Config{Admission: AdmitPredicate, AdmissionLevels: 1 << 7}, hits: 1},
+		{config: Config{Admission: AdmitPredicate, AdmissionBlockTypes: 1 << objiotracing.FilterBlock}, hits: 0},
+		{config: Config{Admission: AdmitProbabilistic, AdmissionProbability: 0}, hits: 0},
+		{config: Config{Admission: AdmitProbabilistic, AdmissionProbability: 1}, hits: 3},
+	}
+	for _, policy := range []ReplacementPolicy{LRU, S3FIFO, OPT} {
+		for i, tc := range testCases {
+			config := tc.config
+			config.Policy = policy
+			config.CacheSize = 16
+			t.Run(fmt.Sprintf("%s/%s/%d", policy, config.Admission, i), func(t *testing.T) {
+				results, err := Simulate(t.Name(), &wrappedTrace{inner: trace}, config, nil /* files */)
+				require.NoError(t, err)
+				require.Equal(t, tc.hits, results.Hits)
+				require.Equal(t, len(trace)-tc.hits, results.Misses)
+			})
+		}
+	}
+}
@@ -0,0 +1,162 @@
+package lib
+
+// arcCache implements the Adaptive Replacement Cache (Megiddo & Modha, FAST
+// '03). Resident entries are split between t1 (seen once recently) and t2 (seen
+// at least twice); b1 and b2 are ghost lists of entries recently evicted from
+// t1 and t2. Hits in the ghost lists adapt the target size of t1.
+//
+// With byte-weighted capacity, the list sizes and the target are in bytes.
+type arcCache struct {
+	capacity int64
+	// p is the target size for t1.
+	p       int64
+	entries map[string]*cacheEntry
+	lists   [4]entryList
+}
+
+var _ sizedCache = (*arcCache)(nil)
+var _ invalidatingCache = (*arcCache)(nil)
+
+// Values for cacheEntry.list.
+const (
+	arcT1 = iota
+	arcT2
+	arcB1
+	arcB2
+)
+
+func newARCCache(capacity int64) *arcCache {
+	return &arcCache{
+		capacity: capacity,
+		entries:  make(map[string]*cacheEntry),
+	}
+}
+
+func (c *arcCache) Get(key string) interface{} {
+	e, ok := c.entries[key]
+	if !ok || e.list >= arcB1 {
+		return nil
+	}
+	c.move(e, arcT2)
+	return e.value
+}
+
+func (c *arcCache) Set(key string, value interface{}) {
+	c.SetSized(key, value, 1)
+}
+
+func (c *arcCache) SetSized(key string, value interface{}, size int64) {
+	t1, t2, b1, b2 := &c.lists[arcT1], &c.lists[arcT2], &c.lists[arcB1], &c.lists[arcB2]
+	e, ok := c.entries[key]
+	if ok && e.list < arcB1 {
+		e.value = value
+		c.lists[e.list].resize(e, size)
+		c.move(e, arcT2)
+		c.replace(0, false)
+		return
+	}
+	if size > c.capacity {
+		if ok {
+			c.lists[e.list].remove(e)
+			delete(c.entries, key)
+		}
+		return
+	}
+	if ok {
+		// Ghost hit: adapt the target and bring the entry back into t2.
+		inB2 := e.list == arcB2
+		if !inB2 {
+			c.p = min64(c.capacity, c.p+size*max64(1, ratio(b2.size, b1.size)))
+		} else {
+			c.p = max64(0, c.p-size*max64(1, ratio(b1.size, b2.size)))
+		}
+		c.lists[e.list].remove(e)
+		e.value = value
+		e.size = size
+		c.replace(size, inB2)
+		e.list = arcT2
+		t2.pushFront(e)
+		return
+	}
+
+	// New entry. Keep t1+b1 within the capacity, and all lists within twice the
+	// capacity.
+	for t1.size+b1.size+size > c.capacity && b1.len > 0 {
+		c.removeGhost(b1.back())
+	}
+	for t1.size+t2.size+b1.size+b2.size+size > 2*c.capacity && b2.len > 0 {
+		c.removeGhost(b2.back())
+	}
+	c.replace(size, false)
+	e = &cacheEntry{key: key, value: value, size: size, list: arcT1}
+	c.entries[key] = e
+	t1.pushFront(e)
+}
+
+// replace evicts entries from t1 or t2 (into the corresponding ghost list)
+// until an entry of the given size fits.
+func (c *arcCache) replace(size int64, inB2 bool) {
+	t1, t2 := &c.lists[arcT1], &c.lists[arcT2]
+	for t1.size+t2.size+size > c.capacity {
+		var e *cacheEntry
+		if t1.len > 0 && (t1.size > c.p || (inB2 && t1.size == c.p) || t2.len == 0) {
+			e = t1.back()
+			c.move(e, arcB1)
+		} else {
+			e = t2.back()
+			c.move(e, arcB2)
+		}
+		e.value = nil
+	}
+}
+
+func (c *arcCache) move(e *cacheEntry, list uint8) {
+	c.lists[e.list].remove(e)
+	e.list = list
+	c.lists[list].pushFront(e)
+}
+
+func (c *arcCache) removeGhost(e *cacheEntry) {
+	c.lists[e.list].remove(e)
+	delete(c.entries, e.key)
+}
+
+func (c *arcCache) Remove(key string) {
+	if e, ok := c.entries[key]; ok && e.list < arcB1 {
+		c.lists[e.list].remove(e)
+		delete(c.entries, key)
+	}
+}
+
+func (c *arcCache) peek(key string) (size int64, ok bool) {
+	if e, ok := c.entries[key]; ok && e.list < arcB1 {
+		return e.size, true
+	}
+	return 0, false
+}
+
+// residentSize returns the total size of the resident entries.
+func (c *arcCache) residentSize() int64 {
+	return c.lists[arcT1].size + c.lists[arcT2].size
+}
+
+func ratio(a, b int64) int64 {
+	if b == 0 {
+		return 1
+	}
+	return a / b
+}
+
+func min64(a, b int64) int64 {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+func max64(a, b int64) int64 {
+	if a > b {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,137 @@
+package lib
+
+import (
+	"fmt"
+	"math/rand"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+var nativePolicies = []ReplacementPolicy{LRU, ARC, LIRS, TwoQ, WTinyLFU, S3FIFO, SIEVE}
+
+type testCache interface {
+	sizedCache
+	invalidatingCache
+	residentSize() int64
+}
+
+func newTestCache(policy ReplacementPolicy, capacity int64, byteWeighted bool) testCache {
+	return newCache(Config{
+		Policy:       policy,
+		CacheSize:    int(capacity),
+		ByteWeighted: byteWeighted,
+	}).(testCache)
+}
+
+// TestCacheRandomized runs random operations against each policy and checks
+// that the capacity is respected, that Get returns the last value set, and that
+// removed entries are gone.
+func TestCacheRandomized(t *testing.T) {
+	for _, policy := range nativePolicies {
+		for _, byteWeighted := range []bool{false, true} {
+			t.Run(fmt.Sprintf("%s/bytes=%t", policy, byteWeighted), func(t *testing.T) {
+				rng := rand.New(rand.NewSource(1))
+				const capacity = 64
+				c := newTestCache(policy, capacity, byteWeighted)
+				zipf := rand.NewZipf(rng, 1.1, 1, 500)
+				values := make(map[string]int)
+				for i := 0; i < 100000; i++ {
+					key := fmt.Sprint(zipf.Uint64())
+					if rng.Intn(10) == 0 {
+						c.Remove(key)
+						_, ok := c.peek(key)
+						require.False(t, ok)
+						require.Nil(t, c.Get(key))
+						continue
+					}
+					if v := c.Get(key); v != nil {
+						require.Equal(t, values[key], v.(int))
+						continue
+					}
+					values[key] = i
+					if byteWeighted {
+						c.SetSized(key, i, 1+rng.Int63n(8))
+					} else {
+						c.Set(key, i)
+					}
+					require.LessOrEqual(t, c.residentSize(), int64(capacity))
+				}
+			})
+		}
+	}
+}
+
+// TestCacheScanResistance checks that policies keep a hot working set when it
+// is interleaved with one-time scans. 2Q is not included: it only promotes
+// entries that are accessed again after leaving a1in, which doesn't happen here
+// because the scans are longer than its ghost list.
+func TestCacheScanResistance(t *testing.T) {
+	hitRate := func(policy ReplacementPolicy) float64 {
+		c := newTestCache(policy, 100, false)
+		var hits, misses int
+		scanKey := 0
+		for round := 0; round < 205; round++ {
+			for i := 0; i < 50; i++ {
+				key := fmt.Sprintf("hot%d", i)
+				if c.Get(key) != nil {
+					hits++
+				} else {
+					misses++
+					c.Set(key, true)
+				}
+			}
+			// The first few rounds don't have scans, to establish the working set.
+			for i := 0; round >= 5 && i < 200; i++ {
+				key := fmt.Sprintf("scan%d", scanKey)
+				scanKey++
+				if c.Get(key) == nil {
+					c.Set(key, true)
+				}
+			}
+		}
+		return float64(hits) / float64(hits+misses)
+	}
+	lru := hitRate(LRU)
+	require.Less(t, lru, 0.05)
+	for _, policy := range []ReplacementPolicy{ARC, LIRS, WTinyLFU, S3FIFO, SIEVE} {
+		require.Greater(t, hitRate(policy), 0.9, "%s", policy)
+	}
+}
+
+// TestWTinyLFUAdmission checks that W-TinyLFU doesn't evict anything when it
+// rejects a candidate.
+func TestWTinyLFUAdmission(t *testing.T) {
+	c := newWTinyLFUCache(1000, 16)
+	c.setWindowCapacity(10)
+	add := func(key string, size int64, freq int) *cacheEntry {
+		for i := 0; i < freq; i++ {
+			c.sketch.increment(key)
+		}
+		e := &cacheEntry{key: key, value: true, size: size}
+		c.entries[key] = e
+		return e
+	}
+	for _, e := range []*cacheEntry{add("cold", 300, 0), add("hot", 600, 5)} {
+		e.list = wTinyLFUProbation
+		c.lists[wTinyLFUProbation].pushFront(e)
+	}
+
+	// Admitting the candidate would require evicting both entries, but it is
+	// less frequent than the second one.
+	c.admit(add("big", 500, 2))
+	resident := func(key string) bool {
+		_, ok := c.peek(key)
+		return ok
+	}
+	require.False(t, resident("big"))
+	require.True(t, resident("cold"))
+	require.Equal(t, int64(900), c.mainSize())
+
+	// A candidate which is more frequent than both is admitted.
+	c.admit(add("frequent", 500, 10))
+	require.True(t, resident("frequent"))
+	require.False(t, resident("cold"))
+	require.False(t, resident("hot"))
+	require.Equal(t, int64(500), c.mainSize())
+}
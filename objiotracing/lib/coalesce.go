@@ -0,0 +1,96 @@
+package lib
+
+import "sort"
+
+// coalescer models a remote storage layer which merges the object store reads
+// for misses on the same file into a single ranged GET, if they are issued
+// within Config.CoalesceWindow of the first read and the byte ranges are at
+// most Config.CoalesceMaxGap apart. Bytes in the gaps are read unnecessarily.
+type coalescer struct {
+	results *Results
+	window  int64
+	maxGap  int64
+	// pending contains the open requests of each file.
+	pending map[fileID][]*coalescedRequest
+	// queue contains the open requests, in the order they were opened.
+	queue []*coalescedRequest
+}
+
+type coalescedRequest struct {
+	file  fileID
+	start int64
+	// lo and hi are the bounds of the byte range of the request.
+	lo, hi int64
+	// ranges contains the byte ranges that were requested by misses.
+	ranges [][2]int64
+}
+
+func newCoalescer(config *Config, results *Results) *coalescer {
+	return &coalescer{
+		results: results,
+		window:  int64(config.CoalesceWindow),
+		maxGap:  config.CoalesceMaxGap,
+		pending: make(map[fileID][]*coalescedRequest),
+	}
+}
+
+// add adds a read of the given byte range, issued at the given time.
+func (c *coalescer) add(file fileID, now int64, lo, hi int64) {
+	// Send the requests whose window has passed.
+	for len(c.queue) > 0 && c.queue[0].start+c.window < now {
+		c.send(c.queue[0])
+		c.queue = c.queue[1:]
+	}
+	for _, r := range c.pending[file] {
+		if lo <= r.hi+c.maxGap && hi+c.maxGap >= r.lo {
+			r.lo = min64(r.lo, lo)
+			r.hi = max64(r.hi, hi)
+			r.ranges = append(r.ranges, [2]int64{lo, hi})
+			return
+		}
+	}
+	r := &coalescedRequest{file: file, start: now, lo: lo, hi: hi, ranges: [][2]int64{{lo, hi}}}
+	c.pending[file] = append(c.pending[file], r)
+	c.queue = append(c.queue, r)
+}
+
+// send records a request.
+func (c *coalescer) send(r *coalescedRequest) {
+	size := r.hi - r.lo
+	c.results.ObjectStoreRequests++
+	c.results.ObjectStoreBytes += size
+	c.results.RequestSizes.Add(size)
+
+	// Calculate the size of the union of the requested ranges.
+	sort.Slice(r.ranges, func(i, j int) bool { return r.ranges[i][0] < r.ranges[j][0] })
+	var requested int64
+	end := r.lo
+	for _, rg := range r.ranges {
+		if rg[1] > end {
+			requested += rg[1] - max64(rg[0], end)
+			end = rg[1]
+		}
+	}
+	c.results.OverReadBytes += size - requested
+
+	pending := c.pending[r.file]
+	for i := range pending {
+		if pending[i] == r {
+			pending = append(pending[:i], pending[i+1:]...)
+			break
+		}
+	}
+	if len(pending) == 0 {
+		delete(c.pending, r.file)
+	} else {
+		c.pending[r.file] = pending
+	}
+}
+
+// finish is called at the end of the simulation, to send the open requests.
+func (c *coalescer) finish() {
+	for _, r := range c.queue {
+		c.send(r)
+	}
+	c.queue = nil
+}
@@ -0,0 +1,55 @@
+package lib
+
+import (
+	"testing"
+	"time"
+
+	"github.com/cockroachdb/pebble/objstorage/objstorageprovider/objiotracing"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCoalesce(t *testing.T) {
+	const blockSize = 4096
+	read := func(nanos int64, fileNum uint64, block int64) objiotracing.Event {
+		e := objiotracing.Event{StartUnixNano: nanos, Op: objiotracing.ReadOp, Offset: block * blockSize, Size: blockSize}
+		setFileNum(&e.FileNum, fileNum)
+		return e
+	}
+	trace := []objiotracing.Event{
+		read(0, 1, 0),
+		read(1000, 1, 1),
+		// Block 2 is read unnecessarily.
+		read(2000, 1, 3),
+		read(3000, 2, 0),
+		// Outside the window of the first request.
+		read(20000, 1, 8),
+	}
+	config := Config{Policy: LRU, BlockSize: blockSize, CacheSize: 100}
+	results, err := Simulate(t.Name(), &wrappedTrace{inner: trace}, config, nil /* files */)
+	require.NoError(t, err)
+	require.Equal(t, 5, results.ObjectStoreRequests)
+	require.Equal(t, int64(5), results.RequestSizes.Count())
+
+	config.CoalesceWindow = 10 * time.Microsecond
+	config.CoalesceMaxGap = blockSize
+	results, err = Simulate(t.Name(), &wrappedTrace{inner: trace}, config, nil /* files */)
+	require.NoError(t, err)
+	require.Equal(t, 5, results.Misses)
+	require.Equal(t, 3, results.ObjectStoreRequests)
+	require.Equal(t, int64(6*blockSize), results.ObjectStoreBytes)
+	require.Equal(t, int64(blockSize), results.OverReadBytes)
+	require.Equal(t, []HistogramBucket{
+		{Lo: 4096, Hi: 8192, Count: 2},
+		{Lo: 8192, Hi: 16384, Count: 0},
+		{Lo: 16384, Hi: 32768, Count: 1},
+	}, results.RequestSizes.Buckets())
+	require.Equal(t, int64(8192), results.RequestSizes.Quantile(0.5))
+	require.Equal(t, int64(32768), results.RequestSizes.Quantile(0.99))
+
+	// Without a gap, only the first two reads are merged.
+	config.CoalesceMaxGap = 0
+	results, err = Simulate(t.Name(), &wrappedTrace{inner: trace}, config, nil /* files */)
+	require.NoError(t, err)
+	require.Equal(t, 4, results.ObjectStoreRequests)
+	require.Equal(t, int64(0), results.OverReadBytes)
+}
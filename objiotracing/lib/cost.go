@@ -0,0 +1,160 @@
+package lib
+
+import (
+	"fmt"
+	"math"
+
+	"github.com/cockroachdb/pebble/objstorage/objstorageprovider/objiotracing"
+)
+
+// CostModel turns simulation results into object store costs and read
+// latencies. Each miss is a GET request (see Results.ObjectStoreRequests) and
+// each file written in the trace is a PUT request.
+type CostModel struct {
+	// GetCost and PutCost are the prices (in dollars) of a request.
+	GetCost float64 `json:"get_cost"`
+	PutCost float64 `json:"put_cost"`
+	// GetCostPerGB and PutCostPerGB are the prices (in dollars) of transferring
+	// 1GB from and to the object store.
+	GetCostPerGB float64 `json:"get_cost_per_gb"`
+	PutCostPerGB float64 `json:"put_cost_per_gb"`
+	// HitLatency and MissLatency are the latency distributions of reads that hit
+	// the cache and of reads that go to the object store.
+	HitLatency  LatencyDistribution `json:"hit_latency"`
+	MissLatency LatencyDistribution `json:"miss_latency"`
+}
+
+// DefaultCostModel uses S3 Standard request pricing (with free transfers
+// within a region), local SSD latencies for hits and typical S3 first-byte
+// latencies for misses.
+var DefaultCostModel = CostModel{
+	GetCost:     0.0004 / 1000,
+	PutCost:     0.005 / 1000,
+	HitLatency:  LatencyDistribution{MedianMs: 0.1, P99Ms: 1},
+	MissLatency: LatencyDistribution{MedianMs: 20, P99Ms: 100},
+}
+
+// Validate returns an error if the model has negative prices or invalid
+// latency distributions.
+func (m *CostModel) Validate() error {
+	if m.GetCost < 0 || m.PutCost < 0 || m.GetCostPerGB < 0 || m.PutCostPerGB < 0 {
+		return fmt.Errorf("negative price in cost model")
+	}
+	if err := m.HitLatency.validate(); err != nil {
+		return fmt.Errorf("hit latency: %w", err)
+	}
+	if err := m.MissLatency.validate(); err != nil {
+		return fmt.Errorf("miss latency: %w", err)
+	}
+	return nil
+}
+
+// LatencyDistribution is a log-normal distribution, defined by its median and
+// 99th percentile (in milliseconds). If P99Ms is not above MedianMs, or the
+// median is not positive, all latencies are equal to the median.
+type LatencyDistribution struct {
+	MedianMs float64 `json:"median_ms"`
+	P99Ms    float64 `json:"p99_ms"`
+}
+
+func (d LatencyDistribution) validate() error {
+	if d.MedianMs <= 0 {
+		return fmt.Errorf("median must be positive, got %g", d.MedianMs)
+	}
+	if d.P99Ms < 0 {
+		return fmt.Errorf("p99 must not be negative, got %g", d.P99Ms)
+	}
+	return nil
+}
+
+// z99 is the 99th percentile of the standard normal distribution.
+const z99 = 2.326348
+
+// cdf returns the fraction of latencies which are at most x.
+func (d LatencyDistribution) cdf(x float64) float64 {
+	if d.MedianMs <= 0 || d.P99Ms <= d.MedianMs {
+		if x >= d.MedianMs {
+			return 1
+		}
+		return 0
+	}
+	mu := math.Log(d.MedianMs)
+	sigma := (math.Log(d.P99Ms) - mu) / z99
+	return 0.5 * math.Erfc(-(math.Log(x)-mu)/(sigma*math.Sqrt2))
+}
+
+// Cost is the result of applying a CostModel to simulation results.
+type Cost struct {
+	Gets     int     `json:"gets"`
+	Puts     int     `json:"puts"`
+	GetBytes int64   `json:"get_bytes"`
+	PutBytes int64   `json:"put_bytes"`
+	Dollars  float64 `json:"dollars"`
+	// Read latency percentiles, in milliseconds.
+	LatencyP50Ms  float64 `json:"latency_p50_ms"`
+	LatencyP99Ms  float64 `json:"latency_p99_ms"`
+	LatencyP999Ms float64 `json:"latency_p999_ms"`
+}
+
+// Cost calculates the object store costs and read latencies for the given
+// simulation results.
+func (m *CostModel) Cost(r *Results) Cost {
+	const gb = 1 << 30
+	c := Cost{
+		Gets:     r.ObjectStoreRequests,
+		Puts:     r.WrittenFiles,
+		GetBytes: r.ObjectStoreBytes,
+		PutBytes: r.WrittenBytes,
+	}
+	c.Dollars = float64(c.Gets)*m.GetCost + float64(c.Puts)*m.PutCost +
+		float64(c.GetBytes)/gb*m.GetCostPerGB + float64(c.PutBytes)/gb*m.PutCostPerGB
+	if r.ReadHits+r.ReadMisses > 0 {
+		// Latencies are per read, so they are mixed according to the read hit
+		// rate (which differs from the block hit rate with Config.AllBlocks).
+		hitRate := r.ReadHitRate()
+		cdf := func(x float64) float64 {
+			return hitRate*m.HitLatency.cdf(x) + (1-hitRate)*m.MissLatency.cdf(x)
+		}
+		c.LatencyP50Ms = quantile(cdf, 0.5)
+		c.LatencyP99Ms = quantile(cdf, 0.99)
+		c.LatencyP999Ms = quantile(cdf, 0.999)
+	}
+	return c
+}
+
+// quantile returns the smallest x for which cdf(x) >= q, using a binary search
+// (on a log scale) between 1ns and 1000s.
+func quantile(cdf func(x float64) float64, q float64) float64 {
+	lo, hi := math.Log(1e-6), math.Log(1e6)
+	for i := 0; i < 100; i++ {
+		mid := (lo + hi) / 2
+		if cdf(math.Exp(mid)) >= q {
+			hi = mid
+		} else {
+			lo = mid
+		}
+	}
+	return math.Exp(hi)
+}
+
+// writeCounter wraps an iterator and counts the files and bytes written to the
+// object store (see Results.WrittenFiles).
+type writeCounter struct {
+	iterator
+	results *Results
+	files   map[fileID]struct{}
+}
+
+func (w *writeCounter) NextBatch() ([]objiotracing.Event, error) {
+	batch, err := w.iterator.NextBatch()
+	for i := range batch {
+		if e := &batch[i]; e.Op == objiotracing.WriteOp {
+			w.results.WrittenBytes += e.Size
+			if _, ok := w.files[eventFile(e)]; !ok {
+				w.files[eventFile(e)] = struct{}{}
+				w.results.WrittenFiles++
+			}
+		}
+	}
+	return batch, err
+}
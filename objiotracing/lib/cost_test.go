@@ -0,0 +1,81 @@
+package lib
+
+import (
+	"math"
+	"testing"
+
+	"github.com/cockroachdb/pebble/objstorage/objstorageprovider/objiotracing"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCostModel(t *testing.T) {
+	write := func(fileNum uint64, offset int64) objiotracing.Event {
+		e := objiotracing.Event{Op: objiotracing.WriteOp, Offset: offset, Size: 1000}
+		setFileNum(&e.FileNum, fileNum)
+		return e
+	}
+	read := func(fileNum uint64) objiotracing.Event {
+		e := objiotracing.Event{Op: objiotracing.ReadOp, Size: 100}
+		setFileNum(&e.FileNum, fileNum)
+		return e
+	}
+	trace := []objiotracing.Event{write(1, 0), write(1, 1000), write(2, 0), read(1), read(1), read(2)}
+	results, err := Simulate(t.Name(), &wrappedTrace{inner: trace}, Config{Policy: LRU, CacheSize: 10}, nil /* files */)
+	require.NoError(t, err)
+	require.Equal(t, 2, results.WrittenFiles)
+	require.Equal(t, int64(3000), results.WrittenBytes)
+
+	m := CostModel{
+		GetCost:      1,
+		PutCost:      10,
+		GetCostPerGB: 1 << 30,
+		PutCostPerGB: 1 << 20,
+		HitLatency:   LatencyDistribution{MedianMs: 1},
+		MissLatency:  LatencyDistribution{MedianMs: 50},
+	}
+	c := m.Cost(results)
+	require.Equal(t, 2, c.Gets)
+	require.Equal(t, 2, c.Puts)
+	require.Equal(t, int64(200), c.GetBytes)
+	require.InDelta(t, 2+20+200+3000.0/1024, c.Dollars, 1e-9)
+	// One in three reads hits.
+	require.InDelta(t, 50, c.LatencyP50Ms, 1e-6)
+	require.InDelta(t, 50, c.LatencyP999Ms, 1e-6)
+
+	// With 99.5% hits, the 99th percentile is a hit but the 99.9th is a miss.
+	r := Results{ReadHits: 995, ReadMisses: 5}
+	c = m.Cost(&r)
+	require.InDelta(t, 1, c.LatencyP50Ms, 1e-6)
+	require.InDelta(t, 1, c.LatencyP99Ms, 1e-6)
+	require.InDelta(t, 50, c.LatencyP999Ms, 1e-6)
+
+	// Latencies follow the read hit rate, not the block hit rate.
+	r = Results{Hits: 990, Misses: 10, ReadHits: 1, ReadMisses: 9}
+	c = m.Cost(&r)
+	require.InDelta(t, 50, c.LatencyP50Ms, 1e-6)
+
+	// For a log-normal distribution, the percentiles match the parameters.
+	r = Results{ReadHits: 1}
+	c = DefaultCostModel.Cost(&r)
+	require.InDelta(t, DefaultCostModel.HitLatency.MedianMs, c.LatencyP50Ms, 1e-6)
+	require.InDelta(t, DefaultCostModel.HitLatency.P99Ms, c.LatencyP99Ms, 1e-4)
+	require.Greater(t, c.LatencyP999Ms, c.LatencyP99Ms)
+}
+
+func TestCostModelValidate(t *testing.T) {
+	require.NoError(t, DefaultCostModel.Validate())
+	m := DefaultCostModel
+	m.HitLatency = LatencyDistribution{MedianMs: 0, P99Ms: 1}
+	require.Error(t, m.Validate())
+	// A zero median is a point mass rather than NaN.
+	c := m.Cost(&Results{ReadHits: 1})
+	require.False(t, math.IsNaN(c.LatencyP99Ms))
+	require.Less(t, c.LatencyP99Ms, 1e-3)
+
+	m = DefaultCostModel
+	m.MissLatency.P99Ms = -1
+	require.Error(t, m.Validate())
+	m = DefaultCostModel
+	m.GetCost = -1
+	require.Error(t, m.Validate())
+}
@@ -0,0 +1,401 @@
+package lib
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/cockroachdb/pebble/objstorage/objstorageprovider/objiotracing"
+)
+
+// DeletionMode determines how the simulator infers when sstables are deleted
+// (see Config.Deletion).
+type DeletionMode int
+
+const (
+	// DeleteNone doesn't model deletions.
+	DeleteNone DeletionMode = iota
+	// DeleteAtLastAccess considers a file deleted right after its last access in
+	// the trace. This requires knowing the future (the trace is buffered before
+	// the simulation), and is an upper bound for what invalidation can achieve.
+	DeleteAtLastAccess
+	// DeleteAfterCompactionRead considers a file deleted once it was read by a
+	// compaction and no compaction read it for Config.DeletionGraceSecs; the
+	// compaction outputs supersede it.
+	DeleteAfterCompactionRead
+	// DeleteExternal uses a list of deletions (see LoadDeletions).
+	DeleteExternal
+	// DeleteManifest uses the deletions recorded in the MANIFEST files attached
+	// to the trace (see Simulate).
+	DeleteManifest
+)
+
+var deletionModeNames = []string{
+	DeleteNone:                "none",
+	DeleteAtLastAccess:        "last-access",
+	DeleteAfterCompactionRead: "after-compaction-read",
+	DeleteExternal:            "external",
+	DeleteManifest:            "manifest",
+}
+
+func (m DeletionMode) String() string {
+	return enumName(deletionModeNames, uint8(m))
+}
+
+// ParseDeletionMode parses a deletion mode name (e.g. "last-access").
+func ParseDeletionMode(s string) (DeletionMode, error) {
+	v, err := parseEnum("deletion mode", deletionModeNames, s)
+	return DeletionMode(v), err
+}
+
+// Deletion is an sstable deletion.
+type Deletion struct {
+	UnixNano int64
+	// Source is the source ID of the file (see EventSource); 0 if the trace has
+	// a single source.
+	Source  uint32
+	FileNum uint64
+}
+
+// LoadDeletions reads a list of deletions from a file, with one deletion per
+// line in the format "<unix-nanos>,<file-num>[,<source-id>]". Empty lines and
+// lines starting with # are ignored. The result is sorted by time.
+func LoadDeletions(filename string) ([]Deletion, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var res []Deletion
+	scanner := bufio.NewScanner(f)
+	for lineNum := 1; scanner.Scan(); lineNum++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Split(line, ",")
+		if len(fields) < 2 || len(fields) > 3 {
+			return nil, fmt.Errorf("%s:%d: invalid deletion %q", filename, lineNum, line)
+		}
+		var d Deletion
+		var err error
+		if d.UnixNano, err = strconv.ParseInt(strings.TrimSpace(fields[0]), 10, 64); err != nil {
+			return nil, fmt.Errorf("%s:%d: %v", filename, lineNum, err)
+		}
+		if d.FileNum, err = strconv.ParseUint(strings.TrimSpace(fields[1]), 10, 64); err != nil {
+			return nil, fmt.Errorf("%s:%d: %v", filename, lineNum, err)
+		}
+		if len(fields) == 3 {
+			source, err := strconv.ParseUint(strings.TrimSpace(fields[2]), 10, 32)
+			if err != nil {
+				return nil, fmt.Errorf("%s:%d: %v", filename, lineNum, err)
+			}
+			d.Source = uint32(source)
+		}
+		res = append(res, d)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	sort.SliceStable(res, func(i, j int) bool {
+		return res[i].UnixNano < res[j].UnixNano
+	})
+	return res, nil
+}
+
+// fileID identifies an sstable; file numbers are only unique within a source.
+type fileID struct {
+	source  uint32
+	fileNum uint64
+}
+
+func eventFile(e *objiotracing.Event) fileID {
+	return fileID{source: EventSource(e), fileNum: uint64(e.FileNum)}
+}
+
+// pendingDeletion is a deletion that happens once the trace reaches a point,
+// which is an event index (DeleteAtLastAccess) or a time (the other modes).
+type pendingDeletion struct {
+	at   int64
+	file fileID
+}
+
+// deletionSampleInterval is the interval (in trace time) at which we sample the
+// capacity occupied by blocks of deleted files.
+const deletionSampleInterval = 10 * time.Second
+
+// deletionModel infers file deletions (according to Config.Deletion) as the
+// simulation goes through the trace, and applies them to the caches.
+type deletionModel struct {
+	config  *Config
+	results *Results
+	caches  *sourceCaches
+	// events is set for DeleteAtLastAccess; it contains the buffered trace.
+	events *spillSlice[objiotracing.Event]
+
+	// pending contains the upcoming deletions, in order.
+	pending  []pendingDeletion
+	eventIdx int64
+	// lastCompactionRead contains the time of the last compaction read of each
+	// file (for DeleteAfterCompactionRead); pending contains all compaction
+	// reads, some of which are superseded by later reads of the same file.
+	lastCompactionRead map[fileID]int64
+
+	deleted map[fileID]struct{}
+	// blocks contains the blocks of each live file that were inserted in the
+	// cache (some of which may have been evicted since).
+	blocks map[fileID]map[int64]struct{}
+	// dead contains the blocks of deleted files which may still be resident.
+	dead map[fileID]map[int64]struct{}
+
+	nextSample int64
+	wastedSum  float64
+	numSamples int
+}
+
+// newDeletionModel creates a deletionModel; it returns the iterator to use for
+// the simulation instead of the given one.
+func newDeletionModel(
+	it iterator, config *Config, files *FileTable, results *Results, caches *sourceCaches,
+) (*deletionModel, iterator, error) {
+	m := &deletionModel{
+		config:  config,
+		results: results,
+		caches:  caches,
+		deleted: make(map[fileID]struct{}),
+		blocks:  make(map[fileID]map[int64]struct{}),
+		dead:    make(map[fileID]map[int64]struct{}),
+	}
+	switch config.Deletion {
+	case DeleteAtLastAccess:
+		// Buffer the trace and find the last access of each file.
+		m.events = newSpillSlice[objiotracing.Event](optMaxInMemoryOps)
+		lastAccess := make(map[fileID]int64)
+		for {
+			batch, err := it.NextBatch()
+			if err != nil {
+				m.close()
+				return nil, nil, err
+			}
+			if batch == nil {
+				break
+			}
+			for i := range batch {
+				lastAccess[eventFile(&batch[i])] = m.events.len()
+				if err := m.events.append(batch[i]); err != nil {
+					m.close()
+					return nil, nil, err
+				}
+			}
+		}
+		if err := m.events.finish(); err != nil {
+			m.close()
+			return nil, nil, err
+		}
+		for f, idx := range lastAccess {
+			m.pending = append(m.pending, pendingDeletion{at: idx, file: f})
+		}
+		sort.Slice(m.pending, func(i, j int) bool {
+			return m.pending[i].at < m.pending[j].at
+		})
+		it = &spillIterator{s: m.events}
+
+	case DeleteAfterCompactionRead:
+		m.lastCompactionRead = make(map[fileID]int64)
+
+	case DeleteExternal, DeleteManifest:
+		var deletions []Deletion
+		if config.Deletion == DeleteExternal {
+			var err error
+			if deletions, err = LoadDeletions(config.DeletionsFile); err != nil {
+				return nil, nil, err
+			}
+		} else {
+			deletions = files.Deletions()
+		}
+		for _, d := range deletions {
+			m.pending = append(m.pending, pendingDeletion{
+				at:   d.UnixNano,
+				file: fileID{source: d.Source, fileNum: d.FileNum},
+			})
+		}
+
+	default:
+		panic(fmt.Sprintf("deletion mode %d not implemented", config.Deletion))
+	}
+	return m, it, nil
+}
+
+// observe is called for each event, before the event's accesses.
+func (m *deletionModel) observe(e *objiotracing.Event) {
+	now := e.StartUnixNano
+	if now >= m.nextSample {
+		if m.nextSample != 0 {
+			m.sample()
+		}
+		m.nextSample = now + int64(deletionSampleInterval)
+	}
+
+	switch m.config.Deletion {
+	case DeleteAtLastAccess:
+		// Files are deleted after the event with their last access.
+		for len(m.pending) > 0 && m.pending[0].at < m.eventIdx {
+			m.deleteFile(m.pending[0].file)
+			m.pending = m.pending[1:]
+		}
+		m.eventIdx++
+
+	case DeleteAfterCompactionRead:
+		grace := int64(m.config.DeletionGraceSecs) * int64(time.Second)
+		if grace == 0 {
+			grace = int64(10 * time.Second)
+		}
+		for len(m.pending) > 0 && m.pending[0].at+grace < now {
+			d := m.pending[0]
+			m.pending = m.pending[1:]
+			if m.lastCompactionRead[d.file] == d.at {
+				delete(m.lastCompactionRead, d.file)
+				m.deleteFile(d.file)
+			}
+		}
+
+	case DeleteExternal, DeleteManifest:
+		for len(m.pending) > 0 && m.pending[0].at <= now {
+			m.deleteFile(m.pending[0].file)
+			m.pending = m.pending[1:]
+		}
+	}
+
+	f := eventFile(e)
+	isRead := e.Op == objiotracing.ReadOp || e.Op == objiotracing.RecordCacheHitOp
+	if _, ok := m.deleted[f]; ok && isRead {
+		m.results.DeletedFileReads++
+		if m.config.Deletion == DeleteAfterCompactionRead {
+			// We were wrong; the file is still live.
+			delete(m.deleted, f)
+			for b := range m.dead[f] {
+				addFileBlock(m.blocks, f, b)
+			}
+			delete(m.dead, f)
+		}
+	}
+	if m.config.Deletion == DeleteAfterCompactionRead && isRead && e.Reason == objiotracing.ForCompaction {
+		m.lastCompactionRead[f] = now
+		m.pending = append(m.pending, pendingDeletion{at: now, file: f})
+	}
+}
+
+// inserted is called when a block is inserted in the cache.
+func (m *deletionModel) inserted(a *access) {
+	f := fileID{source: a.id.source, fileNum: a.id.fileNum}
+	if _, ok := m.deleted[f]; ok {
+		addFileBlock(m.dead, f, a.id.block)
+		return
+	}
+	addFileBlock(m.blocks, f, a.id.block)
+}
+
+// addFileBlock adds a block to a per-file set of blocks.
+func addFileBlock(sets map[fileID]map[int64]struct{}, f fileID, block int64) {
+	blocks, ok := sets[f]
+	if !ok {
+		blocks = make(map[int64]struct{})
+		sets[f] = blocks
+	}
+	blocks[block] = struct{}{}
+}
+
+func (m *deletionModel) deleteFile(f fileID) {
+	m.results.DeletedFiles++
+	m.deleted[f] = struct{}{}
+	blocks := m.blocks[f]
+	delete(m.blocks, f)
+	if !m.config.Policy.SupportsInvalidation() {
+		return
+	}
+	c := m.caches.get(f.source).cache.(invalidatingCache)
+	for b := range blocks {
+		if !m.config.InvalidateOnDelete {
+			addFileBlock(m.dead, f, b)
+			continue
+		}
+		id := blockID{source: f.source, fileNum: f.fileNum, block: b}
+		if _, ok := c.peek(id.key()); ok {
+			c.Remove(id.key())
+			m.results.InvalidatedBlocks++
+		}
+	}
+}
+
+// sample measures the fraction of the capacity occupied by blocks of deleted
+// files.
+func (m *deletionModel) sample() {
+	if !m.config.Policy.SupportsInvalidation() || len(m.caches.caches) == 0 {
+		return
+	}
+	var wasted int64
+	for f, blocks := range m.dead {
+		c := m.caches.get(f.source).cache.(invalidatingCache)
+		for b := range blocks {
+			id := blockID{source: f.source, fileNum: f.fileNum, block: b}
+			if size, ok := c.peek(id.key()); ok {
+				wasted += size
+			} else {
+				// The block was evicted.
+				delete(blocks, b)
+			}
+		}
+		if len(blocks) == 0 {
+			delete(m.dead, f)
+		}
+	}
+	capacity := int64(m.config.CacheSize) * int64(len(m.caches.caches))
+	m.wastedSum += float64(wasted) / float64(capacity)
+	m.numSamples++
+}
+
+// finish is called at the end of the simulation.
+func (m *deletionModel) finish() {
+	m.sample()
+	if m.numSamples > 0 {
+		m.results.WastedCapacity = m.wastedSum / float64(m.numSamples)
+	}
+}
+
+func (m *deletionModel) close() {
+	if m.events != nil {
+		m.events.close()
+		m.events = nil
+	}
+}
+
+// spillIterator iterates over the events in a spillSlice.
+type spillIterator struct {
+	s   *spillSlice[objiotracing.Event]
+	pos int64
+	buf []objiotracing.Event
+}
+
+func (it *spillIterator) NextBatch() ([]objiotracing.Event, error) {
+	const batchSize = 1024
+	n := it.s.len() - it.pos
+	if n == 0 {
+		return nil, nil
+	}
+	if n > batchSize {
+		n = batchSize
+	}
+	if it.buf == nil {
+		it.buf = make([]objiotracing.Event, batchSize)
+	}
+	batch := it.buf[:n]
+	if err := it.s.readAt(it.pos, batch); err != nil {
+		return nil, err
+	}
+	it.pos += n
+	return batch, nil
+}
@@ -0,0 +1,117 @@
+package lib
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/cockroachdb/pebble/objstorage/objstorageprovider/objiotracing"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoadDeletions(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "deletions")
+	require.NoError(t, os.WriteFile(filename, []byte("# time,file,source\n20,5\n\n10, 6, 2\n"), 0666))
+	deletions, err := LoadDeletions(filename)
+	require.NoError(t, err)
+	require.Equal(t, []Deletion{
+		{UnixNano: 10, FileNum: 6, Source: 2},
+		{UnixNano: 20, FileNum: 5},
+	}, deletions)
+
+	require.NoError(t, os.WriteFile(filename, []byte("10\n"), 0666))
+	_, err = LoadDeletions(filename)
+	require.Error(t, err)
+}
+
+func TestDeletion(t *testing.T) {
+	event := func(secs int, reason objiotracing.Reason, fileNum uint64, offset int64) objiotracing.Event {
+		e := objiotracing.Event{
+			StartUnixNano: int64(secs) * int64(time.Second),
+			Op:            objiotracing.ReadOp,
+			Reason:        reason,
+			Offset:        offset,
+			Size:          100,
+		}
+		setFileNum(&e.FileNum, fileNum)
+		return e
+	}
+	read := func(secs int, fileNum uint64, offset int64) objiotracing.Event {
+		return event(secs, objiotracing.UnknownReason, fileNum, offset)
+	}
+
+	t.Run("last-access", func(t *testing.T) {
+		// File 1 is not accessed after the third event.
+		trace := []objiotracing.Event{
+			read(1, 2, 0), read(2, 1, 0), read(3, 1, 100), read(4, 2, 100), read(5, 2, 0),
+		}
+		config := Config{Policy: LRU, CacheSize: 3, Deletion: DeleteAtLastAccess}
+		results, err := Simulate(t.Name(), &wrappedTrace{inner: trace}, config, nil /* files */)
+		require.NoError(t, err)
+		require.Equal(t, 0, results.Hits)
+		require.Equal(t, 1, results.DeletedFiles)
+		// At the end, one block of file 1 is still resident.
+		require.InDelta(t, 1.0/3, results.WastedCapacity, 1e-9)
+
+		config.InvalidateOnDelete = true
+		results, err = Simulate(t.Name(), &wrappedTrace{inner: trace}, config, nil /* files */)
+		require.NoError(t, err)
+		// Invalidating file 1 leaves room for both blocks of file 2.
+		require.Equal(t, 1, results.Hits)
+		require.Equal(t, 2, results.InvalidatedBlocks)
+		require.Equal(t, 0.0, results.WastedCapacity)
+	})
+
+	t.Run("after-compaction-read", func(t *testing.T) {
+		trace := []objiotracing.Event{
+			event(0, objiotracing.ForCompaction, 1, 0),
+			event(1, objiotracing.ForCompaction, 1, 100),
+			read(5, 2, 0),
+			// File 1 is deemed deleted 10s after the last compaction read.
+			read(20, 2, 0),
+			read(21, 1, 0),
+		}
+		config := Config{Policy: S3FIFO, CacheSize: 16, Deletion: DeleteAfterCompactionRead}
+		results, err := Simulate(t.Name(), &wrappedTrace{inner: trace}, config, nil /* files */)
+		require.NoError(t, err)
+		require.Equal(t, 1, results.DeletedFiles)
+		require.Equal(t, 1, results.DeletedFileReads)
+	})
+
+	t.Run("read-after-grace-period", func(t *testing.T) {
+		trace := []objiotracing.Event{
+			event(0, objiotracing.ForCompaction, 1, 0),
+			event(1, objiotracing.ForCompaction, 1, 100),
+			// File 1 is deemed deleted, then read again; its blocks are live.
+			read(20, 2, 0),
+			read(21, 1, 0),
+		}
+		config := Config{Policy: LRU, CacheSize: 16, Deletion: DeleteAfterCompactionRead}
+		results, err := Simulate(t.Name(), &wrappedTrace{inner: trace}, config, nil /* files */)
+		require.NoError(t, err)
+		require.Equal(t, 1, results.DeletedFileReads)
+		require.Equal(t, 0.0, results.WastedCapacity)
+	})
+
+	t.Run("external", func(t *testing.T) {
+		filename := filepath.Join(t.TempDir(), "deletions")
+		require.NoError(t, os.WriteFile(filename, []byte("2500000000,1\n"), 0666))
+		trace := []objiotracing.Event{
+			read(1, 1, 0), read(2, 1, 0), read(3, 1, 0),
+		}
+		config := Config{
+			Policy:             SIEVE,
+			CacheSize:          16,
+			Deletion:           DeleteExternal,
+			DeletionsFile:      filename,
+			InvalidateOnDelete: true,
+		}
+		results, err := Simulate(t.Name(), &wrappedTrace{inner: trace}, config, nil /* files */)
+		require.NoError(t, err)
+		require.Equal(t, 1, results.Hits)
+		require.Equal(t, 1, results.DeletedFiles)
+		require.Equal(t, 1, results.DeletedFileReads)
+		require.Equal(t, 1, results.InvalidatedBlocks)
+	})
+}
@@ -0,0 +1,338 @@
+package lib
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"time"
+
+	"github.com/cockroachdb/pebble/objstorage/objstorageprovider/objiotracing"
+)
+
+// Episode is a flush or compaction, reconstructed from the trace: a cluster of
+// events with the same reason, source and output level with no gaps longer
+// than the detection gap (see DetectEpisodes). Concurrent compactions on the
+// same source into the same level are merged into a single episode.
+type Episode struct {
+	// Reason is "flush" or "compaction" (see ReasonName).
+	Reason        string `json:"reason"`
+	Source        uint32 `json:"source"`
+	StartUnixNano int64  `json:"start_unix_nano"`
+	EndUnixNano   int64  `json:"end_unix_nano"`
+	// InputFiles and InputBytes describe the reads of the episode, and
+	// InputLevels the levels of the files read.
+	InputFiles  int      `json:"input_files"`
+	InputBytes  int64    `json:"input_bytes"`
+	InputLevels []string `json:"input_levels"`
+	// OutputFiles and OutputBytes describe the writes of the episode, and
+	// OutputLevels the levels of the files written.
+	OutputFiles  int      `json:"output_files"`
+	OutputBytes  int64    `json:"output_bytes"`
+	OutputLevels []string `json:"output_levels"`
+
+	// The fields below are only set if a cache is simulated.
+
+	// InsertedBlocks and InsertedBytes are the blocks inserted into the cache by
+	// the episode (on misses, or on writes with Config.WriteThru); each of them
+	// displaces other blocks once the cache is full.
+	InsertedBlocks int   `json:"inserted_blocks"`
+	InsertedBytes  int64 `json:"inserted_bytes"`
+	// UsefulBlocks is the number of inserted blocks that were later hit by a
+	// read outside of any episode (i.e. a user read).
+	UsefulBlocks int `json:"useful_blocks"`
+}
+
+// Duration returns the time between the first and the last event of the
+// episode.
+func (e *Episode) Duration() time.Duration {
+	return time.Duration(e.EndUnixNano - e.StartUnixNano)
+}
+
+// ChurnTotals summarizes the cache insertions of a class of reads (flushes,
+// compactions or user reads).
+type ChurnTotals struct {
+	InsertedBlocks int   `json:"inserted_blocks"`
+	InsertedBytes  int64 `json:"inserted_bytes"`
+	UsefulBlocks   int   `json:"useful_blocks"`
+}
+
+// EpisodeAnalysis contains the episodes of a trace and, if a cache is
+// simulated, the cache churn caused by each class of reads.
+type EpisodeAnalysis struct {
+	Episodes []Episode `json:"episodes"`
+	// Churn is keyed by "flush", "compaction" and "user".
+	Churn map[string]*ChurnTotals `json:"churn"`
+}
+
+// DefaultEpisodeGap is the default maximum gap between the events of an
+// episode.
+const DefaultEpisodeGap = time.Second
+
+// episodeState tracks an episode that is still open.
+type episodeState struct {
+	index        int
+	lastUnixNano int64
+	inputFiles   map[fileID]struct{}
+	outputFiles  map[fileID]struct{}
+	inputLevels  map[uint8]struct{}
+	outputLevels map[uint8]struct{}
+}
+
+type episodeKey struct {
+	reason objiotracing.Reason
+	source uint32
+	// outputLevelPlusOne is the level written by the episode (as in
+	// Event.LevelPlusOne), or unknownOutputLevel if it hasn't written anything
+	// yet.
+	outputLevelPlusOne uint8
+}
+
+const unknownOutputLevel = math.MaxUint8
+
+// canCompactInto returns true if a flush or compaction which writes to the
+// output level can read files of the input level (both as in
+// Event.LevelPlusOne): compactions read files of the output level and of the
+// level above it, and L0 files can be compacted into any level.
+func canCompactInto(inputLevelPlusOne, outputLevelPlusOne uint8) bool {
+	return inputLevelPlusOne <= 1 || outputLevelPlusOne == 0 ||
+		inputLevelPlusOne == outputLevelPlusOne || inputLevelPlusOne+1 == outputLevelPlusOne
+}
+
+// DetectEpisodes reconstructs the flush and compaction episodes of a trace:
+// events with the ForFlush or ForCompaction reason (from the same source) that
+// are at most gap apart and write to the same level belong to the same
+// episode. Reads don't record the level being written, so each read is
+// attributed to the most recently active episode which can read its level (see
+// canCompactInto); reads which precede the first write of an episode are
+// attributed to it if their levels can be compacted into the level it writes.
+//
+// If cacheConfig is not nil, it also simulates the cache and attributes each
+// block insertion to the episode (or user read) which caused it, to measure
+// how much compaction traffic pollutes the cache. OPT and configurations which
+// need sstable metadata are not supported.
+func DetectEpisodes(it iterator, gap time.Duration, cacheConfig *Config) (*EpisodeAnalysis, error) {
+	r := &EpisodeAnalysis{Churn: make(map[string]*ChurnTotals)}
+	open := make(map[episodeKey]*episodeState)
+	closeEpisode := func(k episodeKey) {
+		s := open[k]
+		e := &r.Episodes[s.index]
+		e.InputFiles = len(s.inputFiles)
+		e.OutputFiles = len(s.outputFiles)
+		e.InputLevels = levelNames(s.inputLevels)
+		e.OutputLevels = levelNames(s.outputLevels)
+		delete(open, k)
+	}
+	// find returns the open episode an event belongs to, if any.
+	find := func(e *objiotracing.Event, reason objiotracing.Reason, source uint32) (episodeKey, *episodeState) {
+		pending := episodeKey{reason: reason, source: source, outputLevelPlusOne: unknownOutputLevel}
+		if e.Op == objiotracing.WriteOp {
+			k := episodeKey{reason: reason, source: source, outputLevelPlusOne: e.LevelPlusOne}
+			if s, ok := open[k]; ok {
+				return k, s
+			}
+			// An episode which hasn't written anything yet gets its output level
+			// from its first write, if it read levels that can be compacted into it.
+			s, ok := open[pending]
+			if !ok {
+				return k, nil
+			}
+			for l := range s.inputLevels {
+				if !canCompactInto(l, e.LevelPlusOne) {
+					return k, nil
+				}
+			}
+			delete(open, pending)
+			open[k] = s
+			return k, s
+		}
+		// Attribute reads to the most recently active episode which can read
+		// the level.
+		var bestKey episodeKey
+		var best *episodeState
+		for k, s := range open {
+			if k.reason != reason || k.source != source ||
+				(k != pending && !canCompactInto(e.LevelPlusOne, k.outputLevelPlusOne)) {
+				continue
+			}
+			if best == nil || s.lastUnixNano > best.lastUnixNano ||
+				(s.lastUnixNano == best.lastUnixNano && s.index > best.index) {
+				bestKey, best = k, s
+			}
+		}
+		if best == nil {
+			return pending, nil
+		}
+		return bestKey, best
+	}
+	// current is the index of the episode of the current event, or -1.
+	current := -1
+	onEvent := func(e *objiotracing.Event) {
+		current = -1
+		if e.Reason != objiotracing.ForFlush && e.Reason != objiotracing.ForCompaction {
+			return
+		}
+		source := EventSource(e)
+		for k, s := range open {
+			if k.reason == e.Reason && k.source == source && e.StartUnixNano-s.lastUnixNano > int64(gap) {
+				closeEpisode(k)
+			}
+		}
+		k, s := find(e, e.Reason, source)
+		if s == nil {
+			s = &episodeState{
+				index:        len(r.Episodes),
+				inputFiles:   make(map[fileID]struct{}),
+				outputFiles:  make(map[fileID]struct{}),
+				inputLevels:  make(map[uint8]struct{}),
+				outputLevels: make(map[uint8]struct{}),
+			}
+			open[k] = s
+			r.Episodes = append(r.Episodes, Episode{
+				Reason:        ReasonName(e.Reason),
+				Source:        k.source,
+				StartUnixNano: e.StartUnixNano,
+			})
+		}
+		s.lastUnixNano = e.StartUnixNano
+		ep := &r.Episodes[s.index]
+		ep.EndUnixNano = e.StartUnixNano
+		switch e.Op {
+		case objiotracing.ReadOp, objiotracing.RecordCacheHitOp:
+			s.inputFiles[eventFile(e)] = struct{}{}
+			s.inputLevels[e.LevelPlusOne] = struct{}{}
+			ep.InputBytes += e.Size
+		case objiotracing.WriteOp:
+			s.outputFiles[eventFile(e)] = struct{}{}
+			s.outputLevels[e.LevelPlusOne] = struct{}{}
+			ep.OutputBytes += e.Size
+		}
+		current = s.index
+	}
+
+	var err error
+	if cacheConfig == nil {
+		err = forEachEvent(it, onEvent)
+	} else {
+		err = simulateChurn(it, cacheConfig, &churnObserver{
+			r:        r,
+			onEvent:  onEvent,
+			current:  func() int { return current },
+			inserter: make(map[blockID]int),
+		})
+	}
+	if err != nil {
+		return nil, err
+	}
+	keys := make([]episodeKey, 0, len(open))
+	for k := range open {
+		keys = append(keys, k)
+	}
+	for _, k := range keys {
+		closeEpisode(k)
+	}
+	return r, nil
+}
+
+// churnObserver attributes the cache insertions of a simulation to episodes
+// (see DetectEpisodes).
+type churnObserver struct {
+	r       *EpisodeAnalysis
+	onEvent func(e *objiotracing.Event)
+	// current returns the episode of the event being processed, or -1.
+	current func() int
+	// inserter contains the episode which inserted each resident block, or -1
+	// for blocks inserted by user reads. Entries are removed on the first user
+	// hit.
+	inserter map[blockID]int
+}
+
+var _ accessObserver = (*churnObserver)(nil)
+
+func (o *churnObserver) observe(e *objiotracing.Event) {
+	o.onEvent(e)
+}
+
+func (o *churnObserver) totals(episode int) *ChurnTotals {
+	name := "user"
+	if episode >= 0 {
+		name = o.r.Episodes[episode].Reason
+	}
+	t, ok := o.r.Churn[name]
+	if !ok {
+		t = &ChurnTotals{}
+		o.r.Churn[name] = t
+	}
+	return t
+}
+
+func (o *churnObserver) inserted(a *access) {
+	episode := o.current()
+	o.inserter[a.id] = episode
+	t := o.totals(episode)
+	t.InsertedBlocks++
+	t.InsertedBytes += a.size
+	if episode >= 0 {
+		o.r.Episodes[episode].InsertedBlocks++
+		o.r.Episodes[episode].InsertedBytes += a.size
+	}
+}
+
+func (o *churnObserver) hit(a *access) {
+	if o.current() >= 0 {
+		return
+	}
+	if episode, ok := o.inserter[a.id]; ok {
+		delete(o.inserter, a.id)
+		o.totals(episode).UsefulBlocks++
+		if episode >= 0 {
+			o.r.Episodes[episode].UsefulBlocks++
+		}
+	}
+}
+
+// simulateChurn simulates the cache, reporting the insertions and hits to the
+// observer.
+func simulateChurn(it iterator, config *Config, observer *churnObserver) error {
+	if config.Policy == OPT {
+		return fmt.Errorf("%s is not supported", config.Policy)
+	}
+	if config.usesFileTable() {
+		return fmt.Errorf("configurations which need sstable metadata are not supported")
+	}
+	c := *config
+	if c.Policy == S4LRU {
+		c.CacheSize = c.CacheSize / 4 * 4
+	}
+	var results Results
+	return simulateOnline(it, &c, nil /* files */, &results, observer)
+}
+
+// forEachEvent calls fn for each event.
+func forEachEvent(it iterator, fn func(e *objiotracing.Event)) error {
+	for {
+		batch, err := it.NextBatch()
+		if err != nil {
+			return err
+		}
+		if batch == nil {
+			return nil
+		}
+		for i := range batch {
+			fn(&batch[i])
+		}
+	}
+}
+
+// levelNames returns the names of the given levels (see LevelName), in order.
+func levelNames(levels map[uint8]struct{}) []string {
+	sorted := make([]uint8, 0, len(levels))
+	for l := range levels {
+		sorted = append(sorted, l)
+	}
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	res := make([]string, len(sorted))
+	for i, l := range sorted {
+		res[i] = LevelName(l)
+	}
+	return res
+}
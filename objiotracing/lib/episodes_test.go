@@ -0,0 +1,117 @@
+package lib
+
+import (
+	"testing"
+	"time"
+
+	"github.com/cockroachdb/pebble/objstorage/objstorageprovider/objiotracing"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDetectEpisodes(t *testing.T) {
+	event := func(
+		millis int64, op objiotracing.OpType, reason objiotracing.Reason, levelPlusOne uint8, fileNum uint64, offset int64,
+	) objiotracing.Event {
+		e := objiotracing.Event{
+			StartUnixNano: millis * 1e6,
+			Op:            op,
+			Reason:        reason,
+			LevelPlusOne:  levelPlusOne,
+			Offset:        offset,
+			Size:          100,
+		}
+		setFileNum(&e.FileNum, fileNum)
+		return e
+	}
+	const (
+		read    = objiotracing.ReadOp
+		write   = objiotracing.WriteOp
+		user    = objiotracing.UnknownReason
+		compact = objiotracing.ForCompaction
+		flush   = objiotracing.ForFlush
+	)
+	trace := []objiotracing.Event{
+		// A flush writes file 1 to L0.
+		event(0, write, flush, 1, 1, 0),
+		event(100, write, flush, 1, 1, 100),
+		// User read of file 1.
+		event(200, read, user, 1, 1, 0),
+		// A compaction reads files 1 and 2 and writes file 3 to L6.
+		event(1000, read, compact, 1, 1, 0),
+		event(1100, read, compact, 1, 1, 100),
+		event(1200, read, compact, 6, 2, 0),
+		event(1300, write, compact, 7, 3, 0),
+		// The compaction inserted block 100 of file 1 (which is then hit by a
+		// user read) and block 0 of file 2.
+		event(1400, read, user, 1, 1, 100),
+		event(1500, read, user, 1, 1, 100),
+		// A second compaction, after a gap.
+		event(5000, read, compact, 7, 3, 0),
+		event(5100, write, compact, 7, 4, 0),
+	}
+	config := Config{Policy: LRU, CacheSize: 100}
+	r, err := DetectEpisodes(&wrappedTrace{inner: trace}, time.Second, &config)
+	require.NoError(t, err)
+	require.Len(t, r.Episodes, 3)
+
+	f := r.Episodes[0]
+	require.Equal(t, "flush", f.Reason)
+	require.Equal(t, 100*time.Millisecond, f.Duration())
+	require.Equal(t, 0, f.InputFiles)
+	require.Equal(t, 1, f.OutputFiles)
+	require.Equal(t, int64(200), f.OutputBytes)
+	require.Equal(t, []string{"L0"}, f.OutputLevels)
+	require.Equal(t, 0, f.InsertedBlocks)
+
+	c := r.Episodes[1]
+	require.Equal(t, "compaction", c.Reason)
+	require.Equal(t, int64(1000*1e6), c.StartUnixNano)
+	require.Equal(t, 300*time.Millisecond, c.Duration())
+	require.Equal(t, 2, c.InputFiles)
+	require.Equal(t, int64(300), c.InputBytes)
+	require.Equal(t, []string{"L0", "L5"}, c.InputLevels)
+	require.Equal(t, 1, c.OutputFiles)
+	require.Equal(t, []string{"L6"}, c.OutputLevels)
+	require.Equal(t, 2, c.InsertedBlocks)
+	require.Equal(t, int64(200), c.InsertedBytes)
+	require.Equal(t, 1, c.UsefulBlocks)
+
+	require.Equal(t, int64(5000*1e6), r.Episodes[2].StartUnixNano)
+	require.Equal(t, 1, r.Episodes[2].InsertedBlocks)
+
+	require.Equal(t, map[string]*ChurnTotals{
+		"user":       {InsertedBlocks: 1, InsertedBytes: 100},
+		"compaction": {InsertedBlocks: 3, InsertedBytes: 300, UsefulBlocks: 1},
+	}, r.Churn)
+
+	// Without a cache, only the episodes are detected.
+	r, err = DetectEpisodes(&wrappedTrace{inner: trace}, time.Hour, nil)
+	require.NoError(t, err)
+	require.Len(t, r.Episodes, 2)
+	require.Equal(t, 3, r.Episodes[1].InputFiles)
+	require.Equal(t, 2, r.Episodes[1].OutputFiles)
+	require.Len(t, r.Churn, 0)
+
+	// Concurrent compactions into different levels are separate episodes.
+	trace = []objiotracing.Event{
+		event(0, read, compact, 3, 10, 0),
+		event(10, write, compact, 4, 11, 0),
+		event(20, read, compact, 6, 20, 0),
+		event(30, write, compact, 7, 21, 0),
+		event(40, read, compact, 3, 10, 100),
+		event(50, read, compact, 7, 22, 0),
+		event(60, write, compact, 4, 11, 100),
+		event(70, write, compact, 7, 21, 100),
+	}
+	r, err = DetectEpisodes(&wrappedTrace{inner: trace}, time.Second, &config)
+	require.NoError(t, err)
+	require.Len(t, r.Episodes, 2)
+	require.Equal(t, []string{"L2"}, r.Episodes[0].InputLevels)
+	require.Equal(t, []string{"L3"}, r.Episodes[0].OutputLevels)
+	require.Equal(t, int64(200), r.Episodes[0].InputBytes)
+	require.Equal(t, int64(200), r.Episodes[0].OutputBytes)
+	require.Equal(t, []string{"L5", "L6"}, r.Episodes[1].InputLevels)
+	require.Equal(t, []string{"L6"}, r.Episodes[1].OutputLevels)
+	require.Equal(t, 2, r.Episodes[1].InputFiles)
+	require.Equal(t, 1, r.Episodes[1].OutputFiles)
+}
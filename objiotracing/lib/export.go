@@ -0,0 +1,105 @@
+package lib
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"io"
+	"strconv"
+
+	"github.com/cockroachdb/pebble/objstorage/objstorageprovider/objiotracing"
+)
+
+// ExportedEvent is the representation of an event used when exporting a trace
+// to CSV or JSON.
+type ExportedEvent struct {
+	StartUnixNano int64  `json:"start_unix_nano"`
+	Op            string `json:"op"`
+	Reason        string `json:"reason"`
+	BlockType     string `json:"block_type"`
+	Level         string `json:"level"`
+	Source        string `json:"source,omitempty"`
+	FileNum       uint64 `json:"file_num"`
+	HandleID      uint64 `json:"handle_id"`
+	Offset        int64  `json:"offset"`
+	Size          int64  `json:"size"`
+}
+
+var exportedEventCSVHeader = []string{
+	"start_unix_nano", "op", "reason", "block_type", "level", "source",
+	"file_num", "handle_id", "offset", "size",
+}
+
+func makeExportedEvent(md *TraceMetadata, e *objiotracing.Event) ExportedEvent {
+	return ExportedEvent{
+		StartUnixNano: e.StartUnixNano,
+		Op:            OpName(e.Op),
+		Reason:        ReasonName(e.Reason),
+		BlockType:     BlockTypeName(e.BlockType),
+		Level:         LevelName(e.LevelPlusOne),
+		Source:        md.SourceName(EventSource(e)),
+		FileNum:       uint64(e.FileNum),
+		HandleID:      e.HandleID,
+		Offset:        e.Offset,
+		Size:          e.Size,
+	}
+}
+
+// ExportCSV writes all events produced by the iterator as CSV (with a header
+// row). It does not close the iterator.
+func ExportCSV(w io.Writer, md *TraceMetadata, it Iterator) error {
+	cw := csv.NewWriter(w)
+	if err := cw.Write(exportedEventCSVHeader); err != nil {
+		return err
+	}
+	record := make([]string, len(exportedEventCSVHeader))
+	for {
+		events, err := it.NextBatch()
+		if err != nil {
+			return err
+		}
+		if events == nil {
+			break
+		}
+		for i := range events {
+			e := makeExportedEvent(md, &events[i])
+			record[0] = strconv.FormatInt(e.StartUnixNano, 10)
+			record[1] = e.Op
+			record[2] = e.Reason
+			record[3] = e.BlockType
+			record[4] = e.Level
+			record[5] = e.Source
+			record[6] = strconv.FormatUint(e.FileNum, 10)
+			record[7] = strconv.FormatUint(e.HandleID, 10)
+			record[8] = strconv.FormatInt(e.Offset, 10)
+			record[9] = strconv.FormatInt(e.Size, 10)
+			if err := cw.Write(record); err != nil {
+				return err
+			}
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// ExportJSON writes all events produced by the iterator as newline-delimited
+// JSON objects. It does not close the iterator.
+func ExportJSON(w io.Writer, md *TraceMetadata, it Iterator) error {
+	bw := bufio.NewWriter(w)
+	enc := json.NewEncoder(bw)
+	for {
+		events, err := it.NextBatch()
+		if err != nil {
+			return err
+		}
+		if events == nil {
+			break
+		}
+		for i := range events {
+			if err := enc.Encode(makeExportedEvent(md, &events[i])); err != nil {
+				return err
+			}
+		}
+	}
+	return bw.Flush()
+}
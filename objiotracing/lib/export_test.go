@@ -0,0 +1,124 @@
+package lib
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"strconv"
+	"testing"
+
+	"github.com/cockroachdb/pebble/objstorage/objstorageprovider/objiotracing"
+	"github.com/stretchr/testify/require"
+)
+
+func exportTestEvents() (*TraceMetadata, []objiotracing.Event) {
+	md := &TraceMetadata{Sources: []string{"n1", "n2"}}
+	var events []objiotracing.Event
+	for i := 0; i < 100; i++ {
+		e := objiotracing.Event{
+			StartUnixNano: int64(1000 + i),
+			Op:            objiotracing.OpType(i % 3),
+			Reason:        objiotracing.Reason(i % 4),
+			BlockType:     objiotracing.BlockType(i % 4),
+			LevelPlusOne:  uint8(i % 8),
+			HandleID:      uint64(i * 7),
+			Offset:        int64(i * 4096),
+			Size:          int64(1 + i*13),
+		}
+		setFileNum(&e.FileNum, uint64(i%10))
+		SetEventSource(&e, uint32(i%3))
+		events = append(events, e)
+	}
+	return md, events
+}
+
+// parseExportedEvent converts an exported event back to an event.
+func parseExportedEvent(t *testing.T, md *TraceMetadata, x ExportedEvent) objiotracing.Event {
+	e := objiotracing.Event{
+		StartUnixNano: x.StartUnixNano,
+		HandleID:      x.HandleID,
+		Offset:        x.Offset,
+		Size:          x.Size,
+	}
+	var err error
+	e.Op, err = ParseOp(x.Op)
+	require.NoError(t, err)
+	e.Reason, err = ParseReason(x.Reason)
+	require.NoError(t, err)
+	e.BlockType, err = ParseBlockType(x.BlockType)
+	require.NoError(t, err)
+	e.LevelPlusOne, err = ParseLevel(x.Level)
+	require.NoError(t, err)
+	setFileNum(&e.FileNum, x.FileNum)
+	for i, name := range md.Sources {
+		if name == x.Source {
+			SetEventSource(&e, uint32(i+1))
+		}
+	}
+	return e
+}
+
+func TestExportCSV(t *testing.T) {
+	md, events := exportTestEvents()
+	var buf bytes.Buffer
+	require.NoError(t, ExportCSV(&buf, md, SliceIterator(events)))
+
+	records, err := csv.NewReader(&buf).ReadAll()
+	require.NoError(t, err)
+	require.Equal(t, exportedEventCSVHeader, records[0])
+	require.Len(t, records, len(events)+1)
+	parseInt := func(s string) int64 {
+		v, err := strconv.ParseInt(s, 10, 64)
+		require.NoError(t, err)
+		return v
+	}
+	parseUint := func(s string) uint64 {
+		v, err := strconv.ParseUint(s, 10, 64)
+		require.NoError(t, err)
+		return v
+	}
+	for i, r := range records[1:] {
+		x := ExportedEvent{
+			StartUnixNano: parseInt(r[0]),
+			Op:            r[1],
+			Reason:        r[2],
+			BlockType:     r[3],
+			Level:         r[4],
+			Source:        r[5],
+			FileNum:       parseUint(r[6]),
+			HandleID:      parseUint(r[7]),
+			Offset:        parseInt(r[8]),
+			Size:          parseInt(r[9]),
+		}
+		require.Equal(t, events[i], parseExportedEvent(t, md, x))
+	}
+}
+
+func TestExportJSON(t *testing.T) {
+	md, events := exportTestEvents()
+	var buf bytes.Buffer
+	require.NoError(t, ExportJSON(&buf, md, SliceIterator(events)))
+
+	var parsed []objiotracing.Event
+	scanner := bufio.NewScanner(&buf)
+	for scanner.Scan() {
+		var x ExportedEvent
+		require.NoError(t, json.Unmarshal(scanner.Bytes(), &x))
+		parsed = append(parsed, parseExportedEvent(t, md, x))
+	}
+	require.NoError(t, scanner.Err())
+	require.Equal(t, events, parsed)
+}
+
+func TestParseLevel(t *testing.T) {
+	for l := uint8(0); l <= 7; l++ {
+		parsed, err := ParseLevel(LevelName(l))
+		require.NoError(t, err)
+		require.Equal(t, l, parsed)
+	}
+	for _, s := range []string{"", "L", "5x", "L7", "L-1", "L 5", "L256"} {
+		_, err := ParseLevel(s)
+		require.Error(t, err, s)
+	}
+}
@@ -0,0 +1,130 @@
+package lib
+
+import (
+	"github.com/cockroachdb/pebble/objstorage/objstorageprovider/objiotracing"
+)
+
+// FileAgeStats describes how soon (and how much) the files written in a trace
+// are read after they are written.
+type FileAgeStats struct {
+	FilesWritten int64
+	BytesWritten int64
+	// FilesRead is the number of written files which were read at least once.
+	FilesRead int64
+	// BytesRead is the number of distinct bytes of the written files which were
+	// read at least once (at the granularity of blocks, clamped to the written
+	// extent of the file).
+	BytesRead int64
+	// TimeToFirstReadMicros is the distribution of the time between the end of
+	// the writing of a file and its first read, for files that were read.
+	TimeToFirstReadMicros Log2Histogram
+	// ReadBytesByAgeSecs contains the bytes read (including repeated reads) by
+	// the age of the file at the time of the read, in seconds.
+	ReadBytesByAgeSecs Log2Histogram
+}
+
+// ReadFraction returns the fraction of the written bytes which were read.
+func (s *FileAgeStats) ReadFraction() float64 {
+	if s.BytesWritten == 0 {
+		return 0
+	}
+	return float64(s.BytesRead) / float64(s.BytesWritten)
+}
+
+// FileAgeAnalysis contains file age statistics for all files written in a
+// trace and split by the level of the written file (the keys are the names
+// returned by LevelName).
+type FileAgeAnalysis struct {
+	All     FileAgeStats
+	ByLevel map[string]*FileAgeStats
+	// UnknownAgeReadBytes is the number of bytes read from files which were not
+	// written in the trace.
+	UnknownAgeReadBytes int64
+}
+
+// writtenFile tracks the reads of a file written in the trace.
+type writtenFile struct {
+	level string
+	// writeEnd is the time of the latest write to the file.
+	writeEnd int64
+	// size is the end offset of the written data.
+	size int64
+	read bool
+	// blocks contains the blocks which were read.
+	blocks map[int64]struct{}
+}
+
+// AnalyzeFileAge joins the writes of each file with the later reads of the
+// same file (including reads that hit Pebble's block cache), to help decide
+// whether newly written files are worth caching on write (see
+// Config.WriteThru). Distinct bytes are tracked at the granularity of blocks
+// of the given size (or by offset, if blockSize is 0); see Config.BlockSize.
+func AnalyzeFileAge(it iterator, blockSize int64) (*FileAgeAnalysis, error) {
+	r := &FileAgeAnalysis{ByLevel: make(map[string]*FileAgeStats)}
+	get := func(level string) *FileAgeStats {
+		s, ok := r.ByLevel[level]
+		if !ok {
+			s = &FileAgeStats{}
+			r.ByLevel[level] = s
+		}
+		return s
+	}
+	config := Config{BlockSize: blockSize}
+	files := make(map[fileID]*writtenFile)
+	for {
+		batch, err := it.NextBatch()
+		if err != nil {
+			return nil, err
+		}
+		if batch == nil {
+			return r, nil
+		}
+		for i := range batch {
+			e := &batch[i]
+			f := files[eventFile(e)]
+			switch e.Op {
+			case objiotracing.WriteOp:
+				if f == nil {
+					f = &writtenFile{level: LevelName(e.LevelPlusOne), blocks: make(map[int64]struct{})}
+					files[eventFile(e)] = f
+					r.All.FilesWritten++
+					get(f.level).FilesWritten++
+				}
+				f.writeEnd = e.StartUnixNano
+				f.size = max64(f.size, e.Offset+e.Size)
+				r.All.BytesWritten += e.Size
+				get(f.level).BytesWritten += e.Size
+
+			case objiotracing.ReadOp, objiotracing.RecordCacheHitOp:
+				if f == nil {
+					r.UnknownAgeReadBytes += e.Size
+					continue
+				}
+				age := e.StartUnixNano - f.writeEnd
+				stats := []*FileAgeStats{&r.All, get(f.level)}
+				for _, s := range stats {
+					s.ReadBytesByAgeSecs.AddN(age/1e9, e.Size)
+				}
+				if !f.read {
+					f.read = true
+					for _, s := range stats {
+						s.FilesRead++
+						s.TimeToFirstReadMicros.Add(age / 1000)
+					}
+				}
+				block := config.block(e.Offset)
+				if _, ok := f.blocks[block]; !ok {
+					f.blocks[block] = struct{}{}
+					// Blocks can extend past the end of the file (and reads past
+					// the written data), which would count more bytes than were
+					// written.
+					start := config.blockOffset(block)
+					size := max64(0, min64(start+config.entrySize(e), f.size)-start)
+					for _, s := range stats {
+						s.BytesRead += size
+					}
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,61 @@
+package lib
+
+import (
+	"testing"
+
+	"github.com/cockroachdb/pebble/objstorage/objstorageprovider/objiotracing"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAnalyzeFileAge(t *testing.T) {
+	event := func(secs int64, op objiotracing.OpType, levelPlusOne uint8, fileNum uint64, offset int64) objiotracing.Event {
+		e := objiotracing.Event{
+			StartUnixNano: secs * 1e9,
+			Op:            op,
+			LevelPlusOne:  levelPlusOne,
+			Offset:        offset,
+			Size:          100,
+		}
+		setFileNum(&e.FileNum, fileNum)
+		return e
+	}
+	trace := []objiotracing.Event{
+		// File 1 (L6) is written and read twice.
+		event(0, objiotracing.WriteOp, 7, 1, 0),
+		event(1, objiotracing.WriteOp, 7, 1, 100),
+		event(3, objiotracing.ReadOp, 7, 1, 0),
+		event(9, objiotracing.RecordCacheHitOp, 7, 1, 0),
+		// File 2 (L0) is never read.
+		event(10, objiotracing.WriteOp, 1, 2, 0),
+		// File 3 was not written in the trace.
+		event(11, objiotracing.ReadOp, 7, 3, 0),
+	}
+	r, err := AnalyzeFileAge(&wrappedTrace{inner: trace}, 0)
+	require.NoError(t, err)
+	require.Equal(t, int64(2), r.All.FilesWritten)
+	require.Equal(t, int64(300), r.All.BytesWritten)
+	require.Equal(t, int64(1), r.All.FilesRead)
+	require.Equal(t, int64(100), r.All.BytesRead)
+	require.InDelta(t, 1.0/3, r.All.ReadFraction(), 1e-9)
+	// The first read was 2s after the last write.
+	require.Equal(t, []HistogramBucket{
+		{Lo: 1 << 20, Hi: 1 << 21, Count: 1},
+	}, r.All.TimeToFirstReadMicros.Buckets())
+	require.Equal(t, []HistogramBucket{
+		{Lo: 2, Hi: 4, Count: 100},
+		{Lo: 4, Hi: 8, Count: 0},
+		{Lo: 8, Hi: 16, Count: 100},
+	}, r.All.ReadBytesByAgeSecs.Buckets())
+	require.Equal(t, int64(100), r.UnknownAgeReadBytes)
+
+	require.Equal(t, 0.5, r.ByLevel["L6"].ReadFraction())
+	require.Equal(t, int64(1), r.ByLevel["L0"].FilesWritten)
+	require.Equal(t, int64(0), r.ByLevel["L0"].FilesRead)
+
+	// With blocks larger than the files, the bytes read are clamped to the
+	// written data.
+	r, err = AnalyzeFileAge(&wrappedTrace{inner: trace}, 4096)
+	require.NoError(t, err)
+	require.Equal(t, int64(200), r.All.BytesRead)
+	require.Equal(t, 1.0, r.ByLevel["L6"].ReadFraction())
+}
@@ -0,0 +1,89 @@
+package lib
+
+import (
+	"github.com/cockroachdb/pebble/objstorage/objstorageprovider/objiotracing"
+)
+
+// EventFilter selects a subset of the events of a trace. Empty fields match
+// all events.
+type EventFilter struct {
+	// StartUnixNano and EndUnixNano define the time range [start, end); zero
+	// values mean the range is unbounded on that side.
+	StartUnixNano int64
+	EndUnixNano   int64
+
+	Ops        []objiotracing.OpType
+	Reasons    []objiotracing.Reason
+	BlockTypes []objiotracing.BlockType
+	// LevelsPlusOne contains LevelPlusOne values (see ParseLevel).
+	LevelsPlusOne []uint8
+	FileNums      []uint64
+	// Sources contains source IDs (see EventSource).
+	Sources []uint32
+}
+
+func contains[T comparable](values []T, v T) bool {
+	for i := range values {
+		if values[i] == v {
+			return true
+		}
+	}
+	return false
+}
+
+// Matches returns true if the event passes the filter.
+func (f *EventFilter) Matches(e *objiotracing.Event) bool {
+	if e.StartUnixNano < f.StartUnixNano || (f.EndUnixNano != 0 && e.StartUnixNano >= f.EndUnixNano) {
+		return false
+	}
+	return (len(f.Ops) == 0 || contains(f.Ops, e.Op)) &&
+		(len(f.Reasons) == 0 || contains(f.Reasons, e.Reason)) &&
+		(len(f.BlockTypes) == 0 || contains(f.BlockTypes, e.BlockType)) &&
+		(len(f.LevelsPlusOne) == 0 || contains(f.LevelsPlusOne, e.LevelPlusOne)) &&
+		(len(f.FileNums) == 0 || contains(f.FileNums, uint64(e.FileNum))) &&
+		(len(f.Sources) == 0 || contains(f.Sources, EventSource(e)))
+}
+
+// FilterIterator returns an iterator which produces the events of it that pass
+// the filter. It assumes that it produces events in time order, and stops
+// early once it reaches the end of the filter's time range.
+func FilterIterator(it Iterator, f EventFilter) Iterator {
+	return &filterIterator{it: it, f: f}
+}
+
+type filterIterator struct {
+	it    Iterator
+	f     EventFilter
+	batch []objiotracing.Event
+	done  bool
+}
+
+// NextBatch is part of the Iterator interface.
+func (fi *filterIterator) NextBatch() ([]objiotracing.Event, error) {
+	for !fi.done {
+		events, err := fi.it.NextBatch()
+		if err != nil || events == nil {
+			return nil, err
+		}
+		fi.batch = fi.batch[:0]
+		for i := range events {
+			e := &events[i]
+			if fi.f.EndUnixNano != 0 && e.StartUnixNano >= fi.f.EndUnixNano {
+				fi.done = true
+				break
+			}
+			if fi.f.Matches(e) {
+				fi.batch = append(fi.batch, *e)
+			}
+		}
+		if len(fi.batch) > 0 {
+			return fi.batch, nil
+		}
+	}
+	return nil, nil
+}
+
+// Close is part of the Iterator interface.
+func (fi *filterIterator) Close() {
+	fi.it.Close()
+}
@@ -0,0 +1,50 @@
+package lib
+
+import (
+	"testing"
+
+	"github.com/cockroachdb/pebble/objstorage/objstorageprovider/objiotracing"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFilterIterator(t *testing.T) {
+	var events []objiotracing.Event
+	for i := 0; i < 5000; i++ {
+		e := objiotracing.Event{
+			StartUnixNano: int64(i),
+			Op:            objiotracing.OpType(i % 3),
+			LevelPlusOne:  uint8(i % 7),
+		}
+		SetEventSource(&e, uint32(i%2))
+		events = append(events, e)
+	}
+	f := EventFilter{
+		StartUnixNano: 100,
+		EndUnixNano:   4000,
+		Ops:           []objiotracing.OpType{objiotracing.ReadOp},
+		LevelsPlusOne: []uint8{6, 7},
+		Sources:       []uint32{1},
+	}
+	it := FilterIterator(SliceIterator(events), f)
+	defer it.Close()
+	var times []int64
+	for {
+		batch, err := it.NextBatch()
+		require.NoError(t, err)
+		if batch == nil {
+			break
+		}
+		for i := range batch {
+			require.True(t, f.Matches(&batch[i]))
+			times = append(times, batch[i].StartUnixNano)
+		}
+	}
+	// Events must be 0 mod 3, 6 mod 7 (LevelPlusOne 7 never occurs), and 1 mod 2.
+	var expected []int64
+	for i := int64(100); i < 4000; i++ {
+		if i%3 == 0 && i%7 == 6 && i%2 == 1 {
+			expected = append(expected, i)
+		}
+	}
+	require.Equal(t, expected, times)
+}
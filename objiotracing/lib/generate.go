@@ -0,0 +1,567 @@
+package lib
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"sort"
+	"time"
+
+	"github.com/cockroachdb/pebble/objstorage/objstorageprovider/objiotracing"
+)
+
+// WorkloadConfig configures the synthetic LSM workload generator (see
+// GenerateWorkload).
+type WorkloadConfig struct {
+	Seed      int64
+	StartTime time.Time
+	Duration  time.Duration
+
+	// NumKeys is the size of the key space; all keys have ValueSize bytes.
+	NumKeys   int64
+	ValueSize int64
+	// ZipfS is the skew of the key popularity (must be > 1); larger values mean
+	// a more skewed workload. Popular keys are scattered across the key space.
+	ZipfS float64
+
+	// ReadsPerSec is the rate of user reads; ScanFraction of them are scans,
+	// which read ScanBlocks consecutive data blocks in each level.
+	ReadsPerSec  float64
+	ScanFraction float64
+	ScanBlocks   int
+
+	// WritesPerSec is the rate of user writes (of ValueSize bytes each); they
+	// are accumulated in a memtable and flushed to L0 every MemtableSize bytes.
+	WritesPerSec float64
+	MemtableSize int64
+
+	// BlockSize is the sstable data block size; TargetFileSize is the size of
+	// compaction output files.
+	BlockSize      int64
+	TargetFileSize int64
+	// LevelMultiplier is the ratio between the target sizes of adjacent levels;
+	// BaseLevelSize is the minimum target size of the level that L0 is
+	// compacted into.
+	LevelMultiplier       int
+	BaseLevelSize         int64
+	L0CompactionThreshold int
+	// CompactionMBPerSec is the IO throughput of flushes and compactions (which
+	// run one at a time).
+	CompactionMBPerSec float64
+}
+
+// DefaultWorkloadConfig returns a WorkloadConfig for a 10 minute trace of a
+// moderately skewed, read-heavy workload on a ~2.5GB store.
+func DefaultWorkloadConfig() WorkloadConfig {
+	return WorkloadConfig{
+		Seed:                  1,
+		StartTime:             time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC),
+		Duration:              10 * time.Minute,
+		NumKeys:               10_000_000,
+		ValueSize:             256,
+		ZipfS:                 1.1,
+		ReadsPerSec:           1000,
+		ScanFraction:          0.1,
+		ScanBlocks:            16,
+		WritesPerSec:          2000,
+		MemtableSize:          64 << 20,
+		BlockSize:             32 << 10,
+		TargetFileSize:        64 << 20,
+		LevelMultiplier:       10,
+		BaseLevelSize:         64 << 20,
+		L0CompactionThreshold: 4,
+		CompactionMBPerSec:    64,
+	}
+}
+
+func (c *WorkloadConfig) validate() error {
+	switch {
+	case c.Duration <= 0:
+		return fmt.Errorf("duration must be positive")
+	case c.NumKeys <= 0 || c.ValueSize <= 0:
+		return fmt.Errorf("number of keys and value size must be positive")
+	case c.ZipfS <= 1:
+		return fmt.Errorf("zipf skew must be > 1")
+	case c.ScanFraction < 0 || c.ScanFraction > 1:
+		return fmt.Errorf("scan fraction must be between 0 and 1")
+	case c.BlockSize <= 0 || c.TargetFileSize < c.BlockSize || c.MemtableSize < c.BlockSize:
+		return fmt.Errorf("invalid block, file or memtable size")
+	case c.TargetFileSize < c.ValueSize || c.MemtableSize < c.ValueSize:
+		// Files and memtables must hold at least one key.
+		return fmt.Errorf("file and memtable sizes must be at least the value size")
+	case c.LevelMultiplier < 2 || c.L0CompactionThreshold < 1 || c.CompactionMBPerSec <= 0:
+		return fmt.Errorf("invalid compaction settings")
+	}
+	return nil
+}
+
+// GenerateWorkload produces a synthetic trace from a parametric model of an LSM:
+//
+//   - levels Lbase to L6 start out covering the whole key space, with sizes
+//     decreasing by LevelMultiplier from L6 (which holds all the data);
+//   - user reads pick keys with Zipfian popularity; point reads go through the
+//     levels from L0 down, reading the filter block of each candidate file,
+//     and the index and data blocks of the file that contains the key (which
+//     happens with a probability equal to the file's key density); scans read
+//     consecutive data blocks in every level;
+//   - user writes fill the memtable, which is periodically flushed to a new L0
+//     file; leveled compactions move data down, reading all input files and
+//     writing new output files, which replace the inputs when the compaction
+//     finishes.
+//
+// The returned events are sorted by time. Pebble's own block cache is not
+// modeled: all reads are ReadOps.
+func GenerateWorkload(cfg WorkloadConfig) ([]objiotracing.Event, error) {
+	if err := cfg.validate(); err != nil {
+		return nil, err
+	}
+	g := &generator{
+		cfg: cfg,
+		rng: rand.New(rand.NewSource(cfg.Seed)),
+	}
+	g.zipf = rand.NewZipf(g.rng, cfg.ZipfS, 1, uint64(cfg.NumKeys-1))
+	g.init()
+	g.run()
+	sort.SliceStable(g.events, func(i, j int) bool {
+		return g.events[i].StartUnixNano < g.events[j].StartUnixNano
+	})
+	return g.events, nil
+}
+
+const numLevels = 7
+
+type genFile struct {
+	fileNum uint64
+	// The file contains keys in [lo, hi), with the given density (fraction of
+	// keys in the range that are in the file).
+	lo, hi  int64
+	density float64
+	// Layout: data blocks, followed by the filter block and the index block.
+	numBlocks  int64
+	filterSize int64
+	indexSize  int64
+}
+
+type generator struct {
+	cfg    WorkloadConfig
+	rng    *rand.Rand
+	zipf   *rand.Zipf
+	events []objiotracing.Event
+	// keyMultiplier is used to scramble the Zipfian ranks in randomKey.
+	keyMultiplier uint64
+
+	nextFileNum  uint64
+	nextHandleID uint64
+	// levels[0] is ordered from newest to oldest; other levels are ordered by
+	// key range.
+	levels       [numLevels][]*genFile
+	baseLevel    int
+	targetSizes  [numLevels]int64
+	levelCursors [numLevels]int64
+
+	// bgFreeAt is the time when the current background job finishes; its
+	// effect on the LSM is applied at that time (by install).
+	bgFreeAt int64
+	install  func()
+}
+
+func (g *generator) init() {
+	totalSize := g.cfg.NumKeys * g.cfg.ValueSize
+	g.baseLevel = numLevels - 1
+	size := totalSize
+	for level := numLevels - 1; level >= 1; level-- {
+		g.targetSizes[level] = size
+		if size >= g.cfg.BaseLevelSize {
+			g.baseLevel = level
+		}
+		size /= int64(g.cfg.LevelMultiplier)
+	}
+	for level := g.baseLevel; level < numLevels; level++ {
+		density := float64(g.targetSizes[level]) / float64(totalSize)
+		g.levels[level] = g.makeFiles(0, g.cfg.NumKeys, density)
+	}
+	g.bgFreeAt = g.cfg.StartTime.UnixNano()
+
+	// Find a multiplier coprime with NumKeys, for randomKey.
+	const prime = 2654435761
+	n := g.cfg.NumKeys
+	m := prime % n
+	for m > 1 && gcd(m, n) != 1 {
+		m--
+	}
+	if m == 0 {
+		m = 1
+	}
+	g.keyMultiplier = uint64(m)
+}
+
+// makeFiles creates files covering the range [lo, hi) with the given key
+// density, each of at most TargetFileSize.
+func (g *generator) makeFiles(lo, hi int64, density float64) []*genFile {
+	keysPerFile := int64(float64(g.cfg.TargetFileSize/g.cfg.ValueSize) / density)
+	if keysPerFile < 1 {
+		keysPerFile = 1
+	}
+	var files []*genFile
+	for start := lo; start < hi; start += keysPerFile {
+		end := start + keysPerFile
+		if end > hi {
+			end = hi
+		}
+		files = append(files, g.makeFile(start, end, density))
+	}
+	return files
+}
+
+func (g *generator) makeFile(lo, hi int64, density float64) *genFile {
+	g.nextFileNum++
+	dataSize := int64(float64(hi-lo) * density * float64(g.cfg.ValueSize))
+	numBlocks := (dataSize + g.cfg.BlockSize - 1) / g.cfg.BlockSize
+	if numBlocks < 1 {
+		numBlocks = 1
+	}
+	return &genFile{
+		fileNum:    g.nextFileNum,
+		lo:         lo,
+		hi:         hi,
+		density:    density,
+		numBlocks:  numBlocks,
+		filterSize: 4096 + numBlocks*g.cfg.BlockSize/100,
+		indexSize:  4096 + numBlocks*64,
+	}
+}
+
+func (f *genFile) size(blockSize int64) int64 {
+	return f.numBlocks*blockSize + f.filterSize + f.indexSize
+}
+
+// block returns the data block that would contain the key.
+func (f *genFile) block(key int64) int64 {
+	return (key - f.lo) * f.numBlocks / (f.hi - f.lo)
+}
+
+func (g *generator) emit(
+	t int64,
+	op objiotracing.OpType,
+	reason objiotracing.Reason,
+	blockType objiotracing.BlockType,
+	level int,
+	f *genFile,
+	handleID uint64,
+	offset, size int64,
+) {
+	e := objiotracing.Event{
+		StartUnixNano: t,
+		Op:            op,
+		Reason:        reason,
+		BlockType:     blockType,
+		LevelPlusOne:  uint8(level + 1),
+		HandleID:      handleID,
+		Offset:        offset,
+		Size:          size,
+	}
+	setFileNum(&e.FileNum, f.fileNum)
+	g.events = append(g.events, e)
+}
+
+func (g *generator) newHandleID() uint64 {
+	g.nextHandleID++
+	return g.nextHandleID
+}
+
+// randomKey returns a key with Zipfian popularity. The popularity rank is
+// scrambled (using a multiplicative bijection) so that hot keys are spread
+// across files.
+func (g *generator) randomKey() int64 {
+	rank := g.zipf.Uint64()
+	return int64((rank * g.keyMultiplier) % uint64(g.cfg.NumKeys))
+}
+
+func gcd(a, b int64) int64 {
+	for b != 0 {
+		a, b = b, a%b
+	}
+	return a
+}
+
+// expInterval returns a random exponentially distributed interval for the
+// given rate (per second), in nanoseconds.
+func (g *generator) expInterval(ratePerSec float64) int64 {
+	return int64(g.rng.ExpFloat64() / ratePerSec * 1e9)
+}
+
+func (g *generator) run() {
+	start := g.cfg.StartTime.UnixNano()
+	end := start + int64(g.cfg.Duration)
+	const never = math.MaxInt64
+
+	nextRead := int64(never)
+	if g.cfg.ReadsPerSec > 0 {
+		nextRead = start + g.expInterval(g.cfg.ReadsPerSec)
+	}
+	nextFlush := int64(never)
+	writesPerMemtable := g.cfg.MemtableSize / g.cfg.ValueSize
+	if g.cfg.WritesPerSec > 0 {
+		nextFlush = start + int64(float64(writesPerMemtable)/g.cfg.WritesPerSec*1e9)
+	}
+	for {
+		t := nextRead
+		if nextFlush < t {
+			t = nextFlush
+		}
+		if g.install != nil && g.bgFreeAt <= t {
+			t = g.bgFreeAt
+		}
+		if t >= end {
+			break
+		}
+		switch {
+		case g.install != nil && g.bgFreeAt == t:
+			g.install()
+			g.install = nil
+			g.maybeCompact(t)
+		case t == nextFlush:
+			g.flush(t, writesPerMemtable)
+			nextFlush = t + int64(float64(writesPerMemtable)/g.cfg.WritesPerSec*1e9)
+		default:
+			if g.rng.Float64() < g.cfg.ScanFraction {
+				g.scan(t, g.randomKey())
+			} else {
+				g.pointRead(t, g.randomKey())
+			}
+			nextRead = t + g.expInterval(g.cfg.ReadsPerSec)
+		}
+	}
+}
+
+// findFile returns the file in a level (other than L0) which contains the key,
+// or nil.
+func (g *generator) findFile(level int, key int64) *genFile {
+	files := g.levels[level]
+	i := sort.Search(len(files), func(i int) bool { return files[i].hi > key })
+	if i < len(files) && files[i].lo <= key {
+		return files[i]
+	}
+	return nil
+}
+
+// candidateFiles returns the files (from newest to oldest) that can contain
+// the key, along with their levels.
+func (g *generator) candidateFiles(key int64, fn func(level int, f *genFile) bool) {
+	for _, f := range g.levels[0] {
+		if f.lo <= key && key < f.hi && !fn(0, f) {
+			return
+		}
+	}
+	for level := 1; level < numLevels; level++ {
+		if f := g.findFile(level, key); f != nil && !fn(level, f) {
+			return
+		}
+	}
+}
+
+func (g *generator) pointRead(t int64, key int64) {
+	bs := g.cfg.BlockSize
+	g.candidateFiles(key, func(level int, f *genFile) bool {
+		g.emit(t, objiotracing.ReadOp, objiotracing.UnknownReason, objiotracing.FilterBlock, level, f, 0, f.numBlocks*bs, f.filterSize)
+		if g.rng.Float64() >= f.density {
+			// Filtered out; continue with the next file.
+			return true
+		}
+		g.emit(t, objiotracing.ReadOp, objiotracing.UnknownReason, objiotracing.MetadataBlock, level, f, 0, f.numBlocks*bs+f.filterSize, f.indexSize)
+		g.emit(t, objiotracing.ReadOp, objiotracing.UnknownReason, objiotracing.DataBlock, level, f, 0, f.block(key)*bs, bs)
+		return false
+	})
+}
+
+func (g *generator) scan(t int64, key int64) {
+	bs := g.cfg.BlockSize
+	g.candidateFiles(key, func(level int, f *genFile) bool {
+		handleID := g.newHandleID()
+		g.emit(t, objiotracing.ReadOp, objiotracing.UnknownReason, objiotracing.MetadataBlock, level, f, handleID, f.numBlocks*bs+f.filterSize, f.indexSize)
+		// The scan reads a number of blocks proportional to the density of the
+		// file, spaced out by 10us.
+		n := int64(math.Ceil(float64(g.cfg.ScanBlocks) * f.density))
+		for b := f.block(key); b < f.numBlocks && n > 0; b, n = b+1, n-1 {
+			t += 10 * int64(time.Microsecond)
+			g.emit(t, objiotracing.ReadOp, objiotracing.UnknownReason, objiotracing.DataBlock, level, f, handleID, b*bs, bs)
+		}
+		return true
+	})
+}
+
+// writeFile emits the writes for a new file, starting at time t; returns the
+// time when the writes are done.
+func (g *generator) writeFile(t int64, reason objiotracing.Reason, level int, f *genFile) int64 {
+	bs := g.cfg.BlockSize
+	perBlock := g.ioDuration(bs)
+	for b := int64(0); b < f.numBlocks; b++ {
+		g.emit(t, objiotracing.WriteOp, reason, objiotracing.DataBlock, level, f, 0, b*bs, bs)
+		t += perBlock
+	}
+	g.emit(t, objiotracing.WriteOp, reason, objiotracing.FilterBlock, level, f, 0, f.numBlocks*bs, f.filterSize)
+	t += g.ioDuration(f.filterSize)
+	g.emit(t, objiotracing.WriteOp, reason, objiotracing.MetadataBlock, level, f, 0, f.numBlocks*bs+f.filterSize, f.indexSize)
+	t += g.ioDuration(f.indexSize)
+	return t
+}
+
+// readFile emits the reads of a compaction input file, spread out evenly over
+// the interval [t, t+duration).
+func (g *generator) readFile(t int64, duration int64, level int, f *genFile) {
+	bs := g.cfg.BlockSize
+	handleID := g.newHandleID()
+	g.emit(t, objiotracing.ReadOp, objiotracing.ForCompaction, objiotracing.MetadataBlock, level, f, handleID, f.numBlocks*bs+f.filterSize, f.indexSize)
+	for b := int64(0); b < f.numBlocks; b++ {
+		g.emit(t+duration*b/f.numBlocks, objiotracing.ReadOp, objiotracing.ForCompaction, objiotracing.DataBlock, level, f, handleID, b*bs, bs)
+	}
+}
+
+func (g *generator) ioDuration(bytes int64) int64 {
+	return int64(float64(bytes) / (g.cfg.CompactionMBPerSec * (1 << 20)) * 1e9)
+}
+
+// flush writes a new L0 file with the keys written since the last flush. The
+// flush runs independently of compactions.
+func (g *generator) flush(t int64, numWrites int64) {
+	distinct := make(map[int64]struct{})
+	lo, hi := int64(math.MaxInt64), int64(0)
+	for i := int64(0); i < numWrites; i++ {
+		k := g.randomKey()
+		distinct[k] = struct{}{}
+		if k < lo {
+			lo = k
+		}
+		if k >= hi {
+			hi = k + 1
+		}
+	}
+	f := g.makeFile(lo, hi, float64(len(distinct))/float64(hi-lo))
+	g.writeFile(t, objiotracing.ForFlush, 0, f)
+	// For simplicity, the file becomes visible immediately.
+	g.levels[0] = append([]*genFile{f}, g.levels[0]...)
+	g.maybeCompact(t)
+}
+
+func (g *generator) levelSize(level int) int64 {
+	var size int64
+	for _, f := range g.levels[level] {
+		size += f.size(g.cfg.BlockSize)
+	}
+	return size
+}
+
+// maybeCompact starts a compaction if no background job is running and a level
+// needs compaction.
+func (g *generator) maybeCompact(t int64) {
+	if g.install != nil {
+		return
+	}
+	if t < g.bgFreeAt {
+		t = g.bgFreeAt
+	}
+	if len(g.levels[0]) >= g.cfg.L0CompactionThreshold {
+		inputs := append([]*genFile(nil), g.levels[0]...)
+		g.compact(t, 0, inputs, g.baseLevel)
+		return
+	}
+	bestLevel, bestScore := 0, 1.0
+	for level := g.baseLevel; level < numLevels-1; level++ {
+		if score := float64(g.levelSize(level)) / float64(g.targetSizes[level]); score > bestScore {
+			bestLevel, bestScore = level, score
+		}
+	}
+	if bestLevel == 0 {
+		return
+	}
+	// Pick the next file after the cursor, round-robin through the key space.
+	files := g.levels[bestLevel]
+	i := sort.Search(len(files), func(i int) bool { return files[i].lo >= g.levelCursors[bestLevel] })
+	if i == len(files) {
+		i = 0
+	}
+	g.levelCursors[bestLevel] = files[i].hi
+	g.compact(t, bestLevel, []*genFile{files[i]}, bestLevel+1)
+}
+
+// compact runs a compaction of the given input files from level into
+// outputLevel, starting at time t. The new files replace the inputs (and any
+// overlapping files in outputLevel) when the compaction finishes.
+func (g *generator) compact(t int64, level int, inputs []*genFile, outputLevel int) {
+	lo, hi := inputs[0].lo, inputs[0].hi
+	var upperKeys float64
+	for _, f := range inputs {
+		if f.lo < lo {
+			lo = f.lo
+		}
+		if f.hi > hi {
+			hi = f.hi
+		}
+		upperKeys += float64(f.hi-f.lo) * f.density
+	}
+	var overlapping []*genFile
+	var lowerKeys float64
+	for _, f := range g.levels[outputLevel] {
+		if f.lo < hi && f.hi > lo {
+			overlapping = append(overlapping, f)
+			lowerKeys += float64(f.hi-f.lo) * f.density
+		}
+	}
+	if len(overlapping) > 0 {
+		if overlapping[0].lo < lo {
+			lo = overlapping[0].lo
+		}
+		if last := overlapping[len(overlapping)-1]; last.hi > hi {
+			hi = last.hi
+		}
+	}
+	// Keys in the upper level overwrite keys in the lower level with a
+	// probability equal to the lower level's density.
+	lowerDensity := lowerKeys / float64(hi-lo)
+	density := math.Min(1, (lowerKeys+upperKeys*(1-lowerDensity))/float64(hi-lo))
+	outputs := g.makeFiles(lo, hi, density)
+
+	// The inputs are read concurrently (by a merging iterator), sharing the
+	// IO throughput.
+	var inputSize int64
+	for _, f := range inputs {
+		inputSize += f.size(g.cfg.BlockSize)
+	}
+	for _, f := range overlapping {
+		inputSize += f.size(g.cfg.BlockSize)
+	}
+	readDuration := g.ioDuration(inputSize)
+	for _, f := range inputs {
+		g.readFile(t, readDuration, level, f)
+	}
+	for _, f := range overlapping {
+		g.readFile(t, readDuration, outputLevel, f)
+	}
+	readEnd := t + readDuration
+	// Outputs are written while inputs are read.
+	writeEnd := t
+	for _, f := range outputs {
+		writeEnd = g.writeFile(writeEnd, objiotracing.ForCompaction, outputLevel, f)
+	}
+	if writeEnd < readEnd {
+		writeEnd = readEnd
+	}
+	g.bgFreeAt = writeEnd
+	g.install = func() {
+		g.levels[level] = removeFiles(g.levels[level], inputs)
+		remaining := removeFiles(g.levels[outputLevel], overlapping)
+		remaining = append(remaining, outputs...)
+		sort.Slice(remaining, func(i, j int) bool { return remaining[i].lo < remaining[j].lo })
+		g.levels[outputLevel] = remaining
+	}
+}
+
+func removeFiles(files []*genFile, toRemove []*genFile) []*genFile {
+	res := files[:0:0]
+	for _, f := range files {
+		if !contains(toRemove, f) {
+			res = append(res, f)
+		}
+	}
+	return res
+}
@@ -0,0 +1,59 @@
+package lib
+
+import (
+	"testing"
+	"time"
+
+	"github.com/cockroachdb/pebble/objstorage/objstorageprovider/objiotracing"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGenerateWorkload(t *testing.T) {
+	cfg := DefaultWorkloadConfig()
+	cfg.Duration = time.Minute
+	cfg.NumKeys = 1_000_000
+	cfg.MemtableSize = 4 << 20
+	cfg.TargetFileSize = 8 << 20
+	cfg.BaseLevelSize = 8 << 20
+	events, err := GenerateWorkload(cfg)
+	require.NoError(t, err)
+	require.NotEmpty(t, events)
+
+	type key struct {
+		op     objiotracing.OpType
+		reason objiotracing.Reason
+	}
+	counts := make(map[key]int)
+	levels := make(map[uint8]bool)
+	for i := range events {
+		if i > 0 {
+			require.LessOrEqual(t, events[i-1].StartUnixNano, events[i].StartUnixNano)
+		}
+		counts[key{events[i].Op, events[i].Reason}]++
+		levels[events[i].LevelPlusOne] = true
+	}
+	require.NotZero(t, counts[key{objiotracing.ReadOp, objiotracing.UnknownReason}])
+	require.NotZero(t, counts[key{objiotracing.WriteOp, objiotracing.ForFlush}])
+	require.NotZero(t, counts[key{objiotracing.ReadOp, objiotracing.ForCompaction}])
+	require.NotZero(t, counts[key{objiotracing.WriteOp, objiotracing.ForCompaction}])
+	require.True(t, levels[1] && levels[7])
+
+	// The same seed generates the same trace.
+	again, err := GenerateWorkload(cfg)
+	require.NoError(t, err)
+	require.Equal(t, events, again)
+
+	for _, modify := range []func(c *WorkloadConfig){
+		func(c *WorkloadConfig) { c.ZipfS = 1 },
+		func(c *WorkloadConfig) { c.ValueSize = c.MemtableSize + 1 },
+		func(c *WorkloadConfig) {
+			c.ValueSize = c.TargetFileSize + 1
+			c.MemtableSize = 2 * c.ValueSize
+		},
+	} {
+		invalid := cfg
+		modify(&invalid)
+		_, err = GenerateWorkload(invalid)
+		require.Error(t, err)
+	}
+}
@@ -0,0 +1,226 @@
+package lib
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/cockroachdb/pebble/objstorage/objstorageprovider/objiotracing"
+)
+
+// HeatmapOrder determines the order of the files (rows) in a heatmap.
+type HeatmapOrder int
+
+const (
+	// HeatmapByFile orders the files by source and file number (i.e. roughly by
+	// creation time).
+	HeatmapByFile HeatmapOrder = iota
+	// HeatmapByLevel orders the files by level, then by source and file number.
+	HeatmapByLevel
+)
+
+var heatmapOrderNames = []string{
+	HeatmapByFile:  "file",
+	HeatmapByLevel: "level",
+}
+
+func (o HeatmapOrder) String() string {
+	return enumName(heatmapOrderNames, uint8(o))
+}
+
+// ParseHeatmapOrder parses a heatmap order name (e.g. "level").
+func ParseHeatmapOrder(s string) (HeatmapOrder, error) {
+	v, err := parseEnum("heatmap order", heatmapOrderNames, s)
+	return HeatmapOrder(v), err
+}
+
+// HeatmapConfig describes the heatmap produced by ComputeHeatmap.
+type HeatmapConfig struct {
+	// StartUnixNano and EndUnixNano define the time range of the heatmap;
+	// events outside the range are counted in the first or last column.
+	StartUnixNano int64
+	EndUnixNano   int64
+	// TimeBuckets and FileBuckets are the number of columns and (maximum)
+	// number of rows.
+	TimeBuckets int
+	FileBuckets int
+	Order       HeatmapOrder
+	// TopN is the number of hottest files listed in Heatmap.TopFiles.
+	TopN int
+	// IncludeCacheHits also counts reads that hit Pebble's block cache.
+	IncludeCacheHits bool
+}
+
+// Heatmap is a downsampled matrix of the bytes read from each file over time.
+type Heatmap struct {
+	TimeBuckets int `json:"time_buckets"`
+	FileBuckets int `json:"file_buckets"`
+	// BucketUnixSecs contains the start time of each column, in Unix seconds.
+	BucketUnixSecs []float64 `json:"bucket_unix_secs"`
+	// Rows describes the files in each row.
+	Rows []HeatmapRow `json:"rows"`
+	// Cells contains the bytes read in each cell, row by row (the cell for row r
+	// and column c is Cells[r*TimeBuckets+c]).
+	Cells []float64 `json:"cells"`
+	// MaxCell is the largest value in Cells.
+	MaxCell float64 `json:"max_cell"`
+	// TopFiles contains the files with the most bytes read, in decreasing order.
+	TopFiles []HeatmapFile `json:"top_files"`
+}
+
+// HeatmapRow describes the range of files aggregated in a row of a heatmap.
+type HeatmapRow struct {
+	FirstFile string `json:"first_file"`
+	LastFile  string `json:"last_file"`
+	NumFiles  int    `json:"num_files"`
+	// Level is the level of the files (see LevelName), or empty if the files
+	// are from multiple levels.
+	Level string `json:"level,omitempty"`
+}
+
+// HeatmapFile describes the reads from a file.
+type HeatmapFile struct {
+	File  string `json:"file"`
+	Level string `json:"level"`
+	Bytes int64  `json:"bytes"`
+	Reads int64  `json:"reads"`
+}
+
+// heatmapFile accumulates the reads from a file.
+type heatmapFile struct {
+	id           fileID
+	levelPlusOne uint8
+	bytes        int64
+	reads        int64
+	// cols contains the bytes read in columns [firstCol, firstCol+len(cols)),
+	// so short-lived files only use a few columns.
+	firstCol int
+	cols     []float64
+}
+
+// ComputeHeatmap calculates the heatmap of the reads in a trace.
+func ComputeHeatmap(it Iterator, config HeatmapConfig) (*Heatmap, error) {
+	if config.TimeBuckets <= 0 || config.FileBuckets <= 0 {
+		return nil, fmt.Errorf("invalid heatmap size %dx%d", config.FileBuckets, config.TimeBuckets)
+	}
+	if config.EndUnixNano <= config.StartUnixNano {
+		return nil, fmt.Errorf("invalid heatmap time range")
+	}
+	bucketNanos := (config.EndUnixNano - config.StartUnixNano + int64(config.TimeBuckets) - 1) / int64(config.TimeBuckets)
+	files := make(map[fileID]*heatmapFile)
+	for {
+		batch, err := it.NextBatch()
+		if err != nil {
+			return nil, err
+		}
+		if batch == nil {
+			break
+		}
+		for i := range batch {
+			e := &batch[i]
+			if e.Op != objiotracing.ReadOp && (e.Op != objiotracing.RecordCacheHitOp || !config.IncludeCacheHits) {
+				continue
+			}
+			col := 0
+			if e.StartUnixNano > config.StartUnixNano {
+				col = int((e.StartUnixNano - config.StartUnixNano) / bucketNanos)
+				if col >= config.TimeBuckets {
+					col = config.TimeBuckets - 1
+				}
+			}
+			f, ok := files[eventFile(e)]
+			if !ok {
+				f = &heatmapFile{id: eventFile(e), firstCol: col}
+				files[f.id] = f
+			}
+			f.add(col, e.Size)
+			// Files can be moved to another level; use the latest level.
+			f.levelPlusOne = e.LevelPlusOne
+		}
+	}
+
+	sorted := make([]*heatmapFile, 0, len(files))
+	for _, f := range files {
+		sorted = append(sorted, f)
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		a, b := sorted[i], sorted[j]
+		if config.Order == HeatmapByLevel && a.levelPlusOne != b.levelPlusOne {
+			return a.levelPlusOne < b.levelPlusOne
+		}
+		if a.id.source != b.id.source {
+			return a.id.source < b.id.source
+		}
+		return a.id.fileNum < b.id.fileNum
+	})
+
+	h := &Heatmap{
+		TimeBuckets:    config.TimeBuckets,
+		FileBuckets:    config.FileBuckets,
+		BucketUnixSecs: make([]float64, config.TimeBuckets),
+	}
+	for i := range h.BucketUnixSecs {
+		h.BucketUnixSecs[i] = float64(config.StartUnixNano+int64(i)*bucketNanos) / 1e9
+	}
+	if len(sorted) < h.FileBuckets {
+		h.FileBuckets = len(sorted)
+	}
+	h.Cells = make([]float64, h.FileBuckets*h.TimeBuckets)
+	for r := 0; r < h.FileBuckets; r++ {
+		// Distribute the files evenly among the rows.
+		rowFiles := sorted[r*len(sorted)/h.FileBuckets : (r+1)*len(sorted)/h.FileBuckets]
+		first, last := rowFiles[0], rowFiles[len(rowFiles)-1]
+		row := HeatmapRow{
+			FirstFile: fileName(first.id.source, first.id.fileNum),
+			LastFile:  fileName(last.id.source, last.id.fileNum),
+			NumFiles:  len(rowFiles),
+			Level:     LevelName(first.levelPlusOne),
+		}
+		cells := h.Cells[r*h.TimeBuckets : (r+1)*h.TimeBuckets]
+		for _, f := range rowFiles {
+			if f.levelPlusOne != first.levelPlusOne {
+				row.Level = ""
+			}
+			for i, v := range f.cols {
+				cells[f.firstCol+i] += v
+			}
+		}
+		for _, v := range cells {
+			if v > h.MaxCell {
+				h.MaxCell = v
+			}
+		}
+		h.Rows = append(h.Rows, row)
+	}
+
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i].bytes > sorted[j].bytes
+	})
+	for _, f := range sorted {
+		if len(h.TopFiles) >= config.TopN {
+			break
+		}
+		h.TopFiles = append(h.TopFiles, HeatmapFile{
+			File:  fileName(f.id.source, f.id.fileNum),
+			Level: LevelName(f.levelPlusOne),
+			Bytes: f.bytes,
+			Reads: f.reads,
+		})
+	}
+	return h, nil
+}
+
+func (f *heatmapFile) add(col int, bytes int64) {
+	f.bytes += bytes
+	f.reads++
+	if col < f.firstCol {
+		// Events can be slightly out of order.
+		cols := make([]float64, len(f.cols)+f.firstCol-col)
+		copy(cols[f.firstCol-col:], f.cols)
+		f.cols = cols
+		f.firstCol = col
+	}
+	for col-f.firstCol >= len(f.cols) {
+		f.cols = append(f.cols, 0)
+	}
+	f.cols[col-f.firstCol] += float64(bytes)
+}
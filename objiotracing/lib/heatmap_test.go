@@ -0,0 +1,72 @@
+package lib
+
+import (
+	"testing"
+	"time"
+
+	"github.com/cockroachdb/pebble/objstorage/objstorageprovider/objiotracing"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHeatmap(t *testing.T) {
+	event := func(secs int64, op objiotracing.OpType, levelPlusOne uint8, fileNum uint64, size int64) objiotracing.Event {
+		e := objiotracing.Event{
+			StartUnixNano: secs * int64(time.Second),
+			Op:            op,
+			LevelPlusOne:  levelPlusOne,
+			Size:          size,
+		}
+		setFileNum(&e.FileNum, fileNum)
+		return e
+	}
+	events := []objiotracing.Event{
+		event(0, objiotracing.ReadOp, 7, 1, 100),
+		event(1, objiotracing.ReadOp, 1, 5, 10),
+		event(2, objiotracing.WriteOp, 7, 3, 1000),
+		event(5, objiotracing.ReadOp, 7, 3, 50),
+		event(6, objiotracing.RecordCacheHitOp, 7, 1, 100),
+		event(9, objiotracing.ReadOp, 7, 1, 100),
+	}
+	config := HeatmapConfig{
+		StartUnixNano: 0,
+		EndUnixNano:   10 * int64(time.Second),
+		TimeBuckets:   2,
+		FileBuckets:   10,
+		TopN:          2,
+	}
+	h, err := ComputeHeatmap(SliceIterator(events), config)
+	require.NoError(t, err)
+	require.Equal(t, 2, h.TimeBuckets)
+	require.Equal(t, 3, h.FileBuckets)
+	require.Equal(t, []float64{0, 5}, h.BucketUnixSecs)
+	require.Equal(t, []HeatmapRow{
+		{FirstFile: "000001", LastFile: "000001", NumFiles: 1, Level: "L6"},
+		{FirstFile: "000003", LastFile: "000003", NumFiles: 1, Level: "L6"},
+		{FirstFile: "000005", LastFile: "000005", NumFiles: 1, Level: "L0"},
+	}, h.Rows)
+	require.Equal(t, []float64{
+		100, 100,
+		0, 50,
+		10, 0,
+	}, h.Cells)
+	require.Equal(t, 100.0, h.MaxCell)
+	require.Equal(t, []HeatmapFile{
+		{File: "000001", Level: "L6", Bytes: 200, Reads: 2},
+		{File: "000003", Level: "L6", Bytes: 50, Reads: 1},
+	}, h.TopFiles)
+
+	// Order by level, with cache hits, and multiple files per row.
+	config.Order = HeatmapByLevel
+	config.IncludeCacheHits = true
+	config.FileBuckets = 2
+	h, err = ComputeHeatmap(SliceIterator(events), config)
+	require.NoError(t, err)
+	require.Equal(t, []HeatmapRow{
+		{FirstFile: "000005", LastFile: "000005", NumFiles: 1, Level: "L0"},
+		{FirstFile: "000001", LastFile: "000003", NumFiles: 2, Level: "L6"},
+	}, h.Rows)
+	require.Equal(t, []float64{
+		10, 0,
+		100, 250,
+	}, h.Cells)
+}
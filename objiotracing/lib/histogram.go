@@ -0,0 +1,101 @@
+package lib
+
+import "math/bits"
+
+// Log2Histogram counts non-negative values in power-of-two buckets: bucket 0
+// contains the value 0, and bucket i > 0 contains values in [2^(i-1), 2^i).
+type Log2Histogram [64]int64
+
+// HistogramBucket is a bucket of a Log2Histogram, for values in [Lo, Hi).
+type HistogramBucket struct {
+	Lo    int64 `json:"lo"`
+	Hi    int64 `json:"hi"`
+	Count int64 `json:"count"`
+}
+
+// Add adds a value to the histogram; negative values are counted as 0.
+func (h *Log2Histogram) Add(v int64) {
+	if v < 0 {
+		v = 0
+	}
+	h[bits.Len64(uint64(v))]++
+}
+
+// AddN adds a value n times (or with weight n, e.g. a number of bytes).
+func (h *Log2Histogram) AddN(v int64, n int64) {
+	if v < 0 {
+		v = 0
+	}
+	h[bits.Len64(uint64(v))] += n
+}
+
+// Count returns the number of values in the histogram.
+func (h *Log2Histogram) Count() int64 {
+	var n int64
+	for _, c := range h {
+		n += c
+	}
+	return n
+}
+
+// Quantile returns an upper bound for the given quantile (e.g. 0.99), namely
+// the upper bound of the bucket which contains it.
+func (h *Log2Histogram) Quantile(q float64) int64 {
+	target := int64(q * float64(h.Count()))
+	var n int64
+	for i, c := range h {
+		n += c
+		if c > 0 && n > target {
+			return bucketBounds(i).Hi
+		}
+	}
+	return 0
+}
+
+// Buckets returns the buckets between the first and the last non-empty
+// buckets (inclusive).
+func (h *Log2Histogram) Buckets() []HistogramBucket {
+	first, last := h.NonEmptyRange()
+	if first == -1 {
+		return nil
+	}
+	res := make([]HistogramBucket, 0, last-first+1)
+	for i := first; i <= last; i++ {
+		res = append(res, h.Bucket(i))
+	}
+	return res
+}
+
+// NonEmptyRange returns the indexes of the first and the last non-empty
+// buckets, or -1, -1 if the histogram is empty.
+func (h *Log2Histogram) NonEmptyRange() (first, last int) {
+	first, last = -1, -1
+	for i, c := range h {
+		if c > 0 {
+			if first == -1 {
+				first = i
+			}
+			last = i
+		}
+	}
+	return first, last
+}
+
+// Bucket returns the bucket with the given index.
+func (h *Log2Histogram) Bucket(i int) HistogramBucket {
+	b := bucketBounds(i)
+	b.Count = h[i]
+	return b
+}
+
+func bucketBounds(i int) HistogramBucket {
+	if i == 0 {
+		return HistogramBucket{Lo: 0, Hi: 1}
+	}
+	hi := int64(1) << i
+	if i == 63 {
+		// Avoid overflow; the bucket contains values up to MaxInt64.
+		hi = 1<<63 - 1
+	}
+	return HistogramBucket{Lo: 1 << (i - 1), Hi: hi}
+}
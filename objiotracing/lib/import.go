@@ -0,0 +1,254 @@
+package lib
+
+import (
+	"bufio"
+	"encoding/csv"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/cockroachdb/pebble/objstorage/objstorageprovider/objiotracing"
+)
+
+// ImportFormat identifies a third-party trace format that can be imported into
+// the trace library, so that the simulator can be run on well-known traces.
+//
+// Imported events have no level (LevelPlusOne is 0), UnknownReason and
+// DataBlock; objects are mapped to FileNums as described for each format.
+type ImportFormat string
+
+const (
+	// ImportMSR is the MSR Cambridge block trace format (SNIA IOTTA):
+	//
+	//	Timestamp,Hostname,DiskNumber,Type,Offset,Size,ResponseTime
+	//
+	// where the timestamp is in Windows filetime units (100ns since 1601) and
+	// Type is Read or Write. Each host becomes a source and each disk becomes a
+	// file.
+	ImportMSR ImportFormat = "msr"
+	// ImportTwitter is the Twitter cache trace format (twitter/cache-trace):
+	//
+	//	timestamp,key,key size,value size,client id,operation,TTL
+	//
+	// where the timestamp is in seconds. Each key becomes a file (with FileNum
+	// derived from a hash of the key) which is accessed in its entirety. Get
+	// operations are reads, set-like operations are writes and other
+	// operations (e.g. delete, incr) are ignored.
+	ImportTwitter ImportFormat = "twitter"
+	// ImportCSV is a generic CSV format:
+	//
+	//	timestamp,object,offset,size,op
+	//
+	// where op is r/read or w/write, and the timestamp unit is configurable.
+	// Numeric object names are used as FileNums directly; other names are
+	// hashed.
+	ImportCSV ImportFormat = "csv"
+)
+
+// ImportOptions configures an import.
+type ImportOptions struct {
+	Format ImportFormat
+	// TimeUnit is the unit of the timestamps for ImportCSV; if 0, timestamps are
+	// in nanoseconds.
+	TimeUnit time.Duration
+	// SkipHeader skips the first line of each input.
+	SkipHeader bool
+}
+
+// Importer converts third-party trace files into events. Events from all inputs
+// are accumulated in memory, so that they can be sorted by time.
+type Importer struct {
+	opts    ImportOptions
+	events  []objiotracing.Event
+	sources []string
+	// skipped is the number of records that were ignored because they don't
+	// correspond to a read or write.
+	skipped int
+}
+
+// NewImporter creates a new Importer.
+func NewImporter(opts ImportOptions) (*Importer, error) {
+	switch opts.Format {
+	case ImportMSR, ImportTwitter, ImportCSV:
+	default:
+		return nil, fmt.Errorf("unknown import format %q", opts.Format)
+	}
+	if opts.TimeUnit == 0 {
+		opts.TimeUnit = time.Nanosecond
+	}
+	return &Importer{opts: opts}, nil
+}
+
+// Add imports all the records from an input.
+func (im *Importer) Add(r io.Reader) error {
+	cr := csv.NewReader(bufio.NewReaderSize(r, 1<<20))
+	cr.FieldsPerRecord = -1
+	cr.ReuseRecord = true
+	for line := 1; ; line++ {
+		record, err := cr.Read()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if line == 1 && im.opts.SkipHeader {
+			continue
+		}
+		var e objiotracing.Event
+		var ok bool
+		switch im.opts.Format {
+		case ImportMSR:
+			ok, err = im.parseMSR(record, &e)
+		case ImportTwitter:
+			ok, err = im.parseTwitter(record, &e)
+		case ImportCSV:
+			ok, err = im.parseCSV(record, &e)
+		}
+		if err != nil {
+			return fmt.Errorf("line %d: %v", line, err)
+		}
+		if !ok {
+			im.skipped++
+			continue
+		}
+		e.BlockType = objiotracing.DataBlock
+		im.events = append(im.events, e)
+	}
+}
+
+// Finish sorts the imported events by time and returns them, along with the
+// source names (see TraceMetadata.Sources) and the number of skipped records.
+func (im *Importer) Finish() (events []objiotracing.Event, sources []string, skipped int) {
+	sort.SliceStable(im.events, func(i, j int) bool {
+		return im.events[i].StartUnixNano < im.events[j].StartUnixNano
+	})
+	return im.events, im.sources, im.skipped
+}
+
+// sourceID returns the ID for the given source name, adding it if necessary.
+func (im *Importer) sourceID(name string) uint32 {
+	// There are typically few sources, so a linear search is fine.
+	for i, s := range im.sources {
+		if s == name {
+			return uint32(i + 1)
+		}
+	}
+	im.sources = append(im.sources, name)
+	return uint32(len(im.sources))
+}
+
+func checkFields(record []string, n int) error {
+	if len(record) < n {
+		return fmt.Errorf("expected %d fields, got %d", n, len(record))
+	}
+	return nil
+}
+
+// windowsEpochOffset is the number of 100ns intervals between 1601-01-01 (the
+// Windows filetime epoch) and 1970-01-01.
+const windowsEpochOffset = 116444736000000000
+
+func (im *Importer) parseMSR(record []string, e *objiotracing.Event) (bool, error) {
+	if err := checkFields(record, 6); err != nil {
+		return false, err
+	}
+	ts, err := strconv.ParseInt(record[0], 10, 64)
+	if err != nil {
+		return false, err
+	}
+	e.StartUnixNano = (ts - windowsEpochOffset) * 100
+	SetEventSource(e, im.sourceID(record[1]))
+	disk, err := strconv.ParseUint(record[2], 10, 64)
+	if err != nil {
+		return false, err
+	}
+	setFileNum(&e.FileNum, disk)
+	switch strings.ToLower(record[3]) {
+	case "read":
+		e.Op = objiotracing.ReadOp
+	case "write":
+		e.Op = objiotracing.WriteOp
+	default:
+		return false, nil
+	}
+	if e.Offset, err = strconv.ParseInt(record[4], 10, 64); err != nil {
+		return false, err
+	}
+	if e.Size, err = strconv.ParseInt(record[5], 10, 64); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+func (im *Importer) parseTwitter(record []string, e *objiotracing.Event) (bool, error) {
+	if err := checkFields(record, 6); err != nil {
+		return false, err
+	}
+	ts, err := strconv.ParseInt(record[0], 10, 64)
+	if err != nil {
+		return false, err
+	}
+	e.StartUnixNano = ts * int64(time.Second)
+	setFileNum(&e.FileNum, hashObject(record[1]))
+	keySize, err := strconv.ParseInt(record[2], 10, 64)
+	if err != nil {
+		return false, err
+	}
+	valueSize, err := strconv.ParseInt(record[3], 10, 64)
+	if err != nil {
+		return false, err
+	}
+	e.Size = keySize + valueSize
+	switch record[5] {
+	case "get", "gets":
+		e.Op = objiotracing.ReadOp
+	case "set", "add", "replace", "cas", "append", "prepend":
+		e.Op = objiotracing.WriteOp
+	default:
+		return false, nil
+	}
+	return true, nil
+}
+
+func (im *Importer) parseCSV(record []string, e *objiotracing.Event) (bool, error) {
+	if err := checkFields(record, 5); err != nil {
+		return false, err
+	}
+	ts, err := strconv.ParseFloat(record[0], 64)
+	if err != nil {
+		return false, err
+	}
+	e.StartUnixNano = int64(ts * float64(im.opts.TimeUnit))
+	if n, err := strconv.ParseUint(record[1], 10, 64); err == nil {
+		setFileNum(&e.FileNum, n)
+	} else {
+		setFileNum(&e.FileNum, hashObject(record[1]))
+	}
+	if e.Offset, err = strconv.ParseInt(record[2], 10, 64); err != nil {
+		return false, err
+	}
+	if e.Size, err = strconv.ParseInt(record[3], 10, 64); err != nil {
+		return false, err
+	}
+	switch strings.ToLower(record[4]) {
+	case "r", "read", "get":
+		e.Op = objiotracing.ReadOp
+	case "w", "write", "set":
+		e.Op = objiotracing.WriteOp
+	default:
+		return false, nil
+	}
+	return true, nil
+}
+
+// hashObject maps an object name to a file number.
+func hashObject(name string) uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(name))
+	return h.Sum64()
+}
@@ -0,0 +1,90 @@
+package lib
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cockroachdb/pebble/objstorage/objstorageprovider/objiotracing"
+	"github.com/stretchr/testify/require"
+)
+
+func TestImport(t *testing.T) {
+	im, err := NewImporter(ImportOptions{Format: ImportTwitter})
+	require.NoError(t, err)
+	require.NoError(t, im.Add(strings.NewReader(
+		"2,keyA,10,100,1,get,0\n"+
+			"1,keyA,10,100,1,set,0\n"+
+			"2,keyB,10,50,1,delete,0\n",
+	)))
+	events, sources, skipped := im.Finish()
+	require.Empty(t, sources)
+	require.Equal(t, 1, skipped)
+	require.Len(t, events, 2)
+	require.Equal(t, objiotracing.WriteOp, events[0].Op)
+	require.Equal(t, objiotracing.ReadOp, events[1].Op)
+	require.Equal(t, int64(2*time.Second), events[1].StartUnixNano)
+	require.Equal(t, int64(110), events[1].Size)
+	require.Equal(t, events[0].FileNum, events[1].FileNum)
+
+	im, err = NewImporter(ImportOptions{Format: ImportCSV, TimeUnit: time.Millisecond, SkipHeader: true})
+	require.NoError(t, err)
+	require.NoError(t, im.Add(strings.NewReader(
+		"timestamp,object,offset,size,op\n"+
+			"1.5,123,4096,4096,r\n"+
+			"0.5,obj,0,100,w\n",
+	)))
+	events, _, skipped = im.Finish()
+	require.Equal(t, 0, skipped)
+	require.Len(t, events, 2)
+	require.Equal(t, int64(500*time.Microsecond), events[0].StartUnixNano)
+	require.Equal(t, uint64(123), uint64(events[1].FileNum))
+	require.Equal(t, int64(4096), events[1].Offset)
+
+	_, err = NewImporter(ImportOptions{Format: "foo"})
+	require.Error(t, err)
+}
+
+func TestImportMSR(t *testing.T) {
+	im, err := NewImporter(ImportOptions{Format: ImportMSR})
+	require.NoError(t, err)
+	require.NoError(t, im.Add(strings.NewReader(
+		"128166372003061629,hm,1,Read,3221225472,4096,5000\n"+
+			"128166372002000000,hm,0,Write,512,8192,100\n"+
+			"128166372004000000,src1,2,read,8192,65536,100\n"+
+			"128166372005000000,hm,1,Flush,0,0,1\n",
+	)))
+	events, sources, skipped := im.Finish()
+	require.Equal(t, []string{"hm", "src1"}, sources)
+	require.Equal(t, 1, skipped)
+	require.Len(t, events, 3)
+
+	// Timestamps are in 100ns units since 1601.
+	start := time.Date(2007, 2, 22, 17, 0, 0, 200*int(time.Millisecond), time.UTC)
+	require.Equal(t, start.UnixNano(), events[0].StartUnixNano)
+	require.Equal(t, start.Add(106162900*time.Nanosecond).UnixNano(), events[1].StartUnixNano)
+
+	// Each host is a source and each disk is a file; offsets and sizes are
+	// bytes within the disk.
+	require.Equal(t, objiotracing.WriteOp, events[0].Op)
+	require.Equal(t, uint32(1), EventSource(&events[0]))
+	require.Equal(t, uint64(0), uint64(events[0].FileNum))
+	require.Equal(t, int64(512), events[0].Offset)
+	require.Equal(t, int64(8192), events[0].Size)
+
+	require.Equal(t, objiotracing.ReadOp, events[1].Op)
+	require.Equal(t, uint64(1), uint64(events[1].FileNum))
+	require.Equal(t, int64(3221225472), events[1].Offset)
+	require.Equal(t, int64(4096), events[1].Size)
+
+	require.Equal(t, objiotracing.ReadOp, events[2].Op)
+	require.Equal(t, uint32(2), EventSource(&events[2]))
+	require.Equal(t, uint64(2), uint64(events[2].FileNum))
+	require.Equal(t, int64(8192), events[2].Offset)
+
+	for i := range events {
+		require.Equal(t, uint8(0), events[i].LevelPlusOne)
+		require.Equal(t, objiotracing.UnknownReason, events[i].Reason)
+		require.Equal(t, objiotracing.DataBlock, events[i].BlockType)
+	}
+}
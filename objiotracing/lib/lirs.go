@@ -0,0 +1,254 @@
+package lib
+
+// lirsCache implements the Low Inter-reference Recency Set policy (Jiang &
+// Zhang, SIGMETRICS '02). Most of the capacity holds LIR (low inter-reference
+// recency) entries; a small part (1%) holds resident HIR entries, which are
+// candidates for eviction. The stack tracks the recency of LIR entries and of
+// recently seen HIR entries (including non-resident ones); a HIR entry that is
+// accessed again while in the stack becomes LIR.
+//
+// With byte-weighted capacity, the LIR and HIR parts are sized in bytes.
+type lirsCache struct {
+	capacity    int64
+	lirCapacity int64
+	lirSize     int64
+	entries     map[string]*lirsEntry
+	// stack contains nodes for LIR entries and recent HIR entries, most recent
+	// at the front. The back is always a LIR entry.
+	stack entryList
+	// queue contains nodes for resident HIR entries, most recent at the front.
+	queue entryList
+	// ghosts contains nodes for non-resident HIR entries (which are all in the
+	// stack), most recently evicted at the front. It is used to bound the
+	// number of non-resident entries.
+	ghosts entryList
+}
+
+var _ sizedCache = (*lirsCache)(nil)
+var _ invalidatingCache = (*lirsCache)(nil)
+
+type lirsEntry struct {
+	key      string
+	value    interface{}
+	size     int64
+	lir      bool
+	resident bool
+	// sNode is the node in the stack, or nil. qNode is the node in the queue
+	// (for resident HIR entries) or in the ghosts list (for non-resident
+	// entries), or nil. The nodes point back to the entry through their value.
+	sNode, qNode *cacheEntry
+}
+
+func newLIRSCache(capacity int64) *lirsCache {
+	hirCapacity := max64(capacity/100, 1)
+	return &lirsCache{
+		capacity:    capacity,
+		lirCapacity: max64(capacity-hirCapacity, 0),
+		entries:     make(map[string]*lirsEntry),
+	}
+}
+
+func (c *lirsCache) Get(key string) interface{} {
+	x, ok := c.entries[key]
+	if !ok || !x.resident {
+		return nil
+	}
+	c.access(x)
+	return x.value
+}
+
+// access updates the structures for an access to a resident entry.
+func (c *lirsCache) access(x *lirsEntry) {
+	switch {
+	case x.lir:
+		wasBottom := c.stack.back() == x.sNode
+		c.stack.moveToFront(x.sNode)
+		if wasBottom {
+			c.prune()
+		}
+
+	case x.sNode != nil:
+		// Resident HIR entry in the stack: it becomes LIR.
+		c.queue.remove(x.qNode)
+		x.qNode = nil
+		x.lir = true
+		c.lirSize += x.size
+		c.stack.moveToFront(x.sNode)
+		c.limitLIR()
+
+	default:
+		c.pushStack(x)
+		c.queue.moveToFront(x.qNode)
+	}
+}
+
+func (c *lirsCache) Set(key string, value interface{}) {
+	c.SetSized(key, value, 1)
+}
+
+func (c *lirsCache) SetSized(key string, value interface{}, size int64) {
+	if x, ok := c.entries[key]; ok && x.resident {
+		x.value = value
+		c.resize(x, size)
+		c.access(x)
+		c.limitLIR()
+		c.makeRoom(0)
+		return
+	}
+	if size > c.capacity {
+		return
+	}
+	c.makeRoom(size)
+
+	// Look up the entry after making room, as it could have been pruned.
+	x, ok := c.entries[key]
+	if ok {
+		// Non-resident HIR entry in the stack: it becomes a resident LIR entry.
+		c.ghosts.remove(x.qNode)
+		x.qNode = nil
+		x.resident = true
+		x.value = value
+		c.resize(x, size)
+		x.lir = true
+		c.lirSize += size
+		c.stack.moveToFront(x.sNode)
+		c.limitLIR()
+		return
+	}
+	x = &lirsEntry{key: key, value: value, size: size, resident: true}
+	c.entries[key] = x
+	c.pushStack(x)
+	if c.lirSize+size <= c.lirCapacity {
+		// While the LIR set is not full, all new entries are LIR.
+		x.lir = true
+		c.lirSize += size
+	} else {
+		x.qNode = &cacheEntry{key: key, value: x, size: size}
+		c.queue.pushFront(x.qNode)
+	}
+}
+
+// makeRoom evicts resident HIR entries until an entry of the given size fits.
+func (c *lirsCache) makeRoom(size int64) {
+	for c.lirSize+c.queue.size+size > c.capacity {
+		if c.queue.len == 0 {
+			c.demoteBottom()
+			continue
+		}
+		n := c.queue.back()
+		x := n.value.(*lirsEntry)
+		c.queue.remove(n)
+		x.resident = false
+		x.value = nil
+		if x.sNode == nil {
+			x.qNode = nil
+			delete(c.entries, x.key)
+		} else {
+			c.ghosts.pushFront(n)
+		}
+	}
+	for c.ghosts.size > c.capacity {
+		n := c.ghosts.back()
+		x := n.value.(*lirsEntry)
+		c.ghosts.remove(n)
+		c.stack.remove(x.sNode)
+		delete(c.entries, x.key)
+	}
+}
+
+// limitLIR demotes LIR entries until the LIR set fits in its capacity.
+func (c *lirsCache) limitLIR() {
+	for c.lirSize > c.lirCapacity {
+		c.demoteBottom()
+	}
+}
+
+// demoteBottom turns the LIR entry at the bottom of the stack into a resident
+// HIR entry.
+func (c *lirsCache) demoteBottom() {
+	n := c.stack.back()
+	x := n.value.(*lirsEntry)
+	c.stack.remove(n)
+	x.sNode = nil
+	x.lir = false
+	c.lirSize -= x.size
+	x.qNode = &cacheEntry{key: x.key, value: x, size: x.size}
+	c.queue.pushFront(x.qNode)
+	c.prune()
+}
+
+// prune removes HIR entries from the bottom of the stack, so that the bottom
+// is a LIR entry. Non-resident entries are forgotten.
+func (c *lirsCache) prune() {
+	for n := c.stack.back(); n != nil; n = c.stack.back() {
+		x := n.value.(*lirsEntry)
+		if x.lir {
+			return
+		}
+		c.stack.remove(n)
+		x.sNode = nil
+		if !x.resident {
+			c.ghosts.remove(x.qNode)
+			x.qNode = nil
+			delete(c.entries, x.key)
+		}
+	}
+}
+
+func (c *lirsCache) pushStack(x *lirsEntry) {
+	if x.sNode != nil {
+		c.stack.moveToFront(x.sNode)
+		return
+	}
+	x.sNode = &cacheEntry{key: x.key, value: x, size: x.size}
+	c.stack.pushFront(x.sNode)
+}
+
+// resize changes the size of an entry (and of its nodes).
+func (c *lirsCache) resize(x *lirsEntry, size int64) {
+	if x.sNode != nil {
+		c.stack.resize(x.sNode, size)
+	}
+	if x.qNode != nil {
+		if x.resident {
+			c.queue.resize(x.qNode, size)
+		} else {
+			c.ghosts.resize(x.qNode, size)
+		}
+	}
+	if x.lir {
+		c.lirSize += size - x.size
+	}
+	x.size = size
+}
+
+func (c *lirsCache) Remove(key string) {
+	x, ok := c.entries[key]
+	if !ok || !x.resident {
+		return
+	}
+	if x.sNode != nil {
+		c.stack.remove(x.sNode)
+		x.sNode = nil
+	}
+	if x.lir {
+		c.lirSize -= x.size
+	} else {
+		c.queue.remove(x.qNode)
+		x.qNode = nil
+	}
+	delete(c.entries, key)
+	c.prune()
+}
+
+func (c *lirsCache) peek(key string) (size int64, ok bool) {
+	if x, ok := c.entries[key]; ok && x.resident {
+		return x.size, true
+	}
+	return 0, false
+}
+
+// residentSize returns the total size of the resident entries.
+func (c *lirsCache) residentSize() int64 {
+	return c.lirSize + c.queue.size
+}
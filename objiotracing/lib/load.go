@@ -1,42 +1,66 @@
 package lib
 
 import (
-	"encoding/json"
-	"fmt"
 	"io"
-	"os"
 	"unsafe"
 
-	gzip "github.com/klauspost/pgzip"
-
 	"github.com/cockroachdb/pebble/objstorage/objstorageprovider/objiotracing"
 )
 
-// Iterator is used to stream Events from a compressed trace file.
-type Iterator struct {
-	file     *os.File
-	gzReader *gzip.Reader
-	buf      []byte
-	done     bool
+// Iterator is used to stream Events from a trace.
+type Iterator interface {
+	// NextBatch returns a batch of events. If there are no more events, returns
+	// nil. The batch is only valid until the next call.
+	NextBatch() ([]objiotracing.Event, error)
+	// Close releases any resources held by the iterator.
+	Close()
 }
 
 const eventSize = int(unsafe.Sizeof(objiotracing.Event{}))
 
-func newIterator(file *os.File, gzReader *gzip.Reader) *Iterator {
+// EventsAsBytes returns the on-disk representation of the given events (which
+// aliases the events slice).
+func EventsAsBytes(events []objiotracing.Event) []byte {
+	if len(events) == 0 {
+		return nil
+	}
+	return unsafe.Slice((*byte)(unsafe.Pointer(&events[0])), len(events)*eventSize)
+}
+
+// setFileNum sets an Event.FileNum field; the type of the field is internal to
+// Pebble, so we can't convert to it directly.
+func setFileNum[T ~uint64](fileNum *T, value uint64) {
+	*fileNum = T(value)
+}
+
+// readerIterator is used to stream Events from a reader which produces events
+// in the on-disk format (e.g. a decompressed trace file).
+type readerIterator struct {
+	r       io.Reader
+	closers []io.Closer
+	buf     []byte
+	done    bool
+}
+
+var _ Iterator = (*readerIterator)(nil)
+
+// newReaderIterator creates an iterator that reads events from r; the closers
+// are closed (in order) when the iterator is closed.
+func newReaderIterator(r io.Reader, closers ...io.Closer) *readerIterator {
 	const bufSize = 1024
-	return &Iterator{
-		file:     file,
-		gzReader: gzReader,
-		buf:      make([]byte, bufSize*eventSize),
+	return &readerIterator{
+		r:       r,
+		closers: closers,
+		buf:     make([]byte, bufSize*eventSize),
 	}
 }
 
-// NextBatch returns a batch of events. If there are no more events, returns nil.
-func (it *Iterator) NextBatch() ([]objiotracing.Event, error) {
+// NextBatch is part of the Iterator interface.
+func (it *readerIterator) NextBatch() ([]objiotracing.Event, error) {
 	if it.done {
 		return nil, nil
 	}
-	n, err := io.ReadFull(it.gzReader, it.buf)
+	n, err := io.ReadFull(it.r, it.buf)
 	if err != nil {
 		if err == io.EOF || err == io.ErrUnexpectedEOF {
 			it.done = true
@@ -52,30 +76,37 @@ func (it *Iterator) NextBatch() ([]objiotracing.Event, error) {
 	return unsafe.Slice((*objiotracing.Event)(p), n/eventSize), nil
 }
 
-func (it *Iterator) Close() {
-	it.gzReader.Close()
-	it.file.Close()
-	*it = Iterator{}
+// Close is part of the Iterator interface.
+func (it *readerIterator) Close() {
+	for _, c := range it.closers {
+		c.Close()
+	}
+	*it = readerIterator{}
 }
 
-// Load a trace; returns the metadata and a streaming iterator.
-func Load(trace string) (TraceMetadata, *Iterator, error) {
-	mdBuf, err := os.ReadFile(fmt.Sprintf("traces/%s.json", trace))
-	if err != nil {
-		return TraceMetadata{}, nil, err
-	}
-	var md TraceMetadata
-	if err := json.Unmarshal(mdBuf, &md); err != nil {
-		return TraceMetadata{}, nil, err
-	}
-	file, err := os.Open(fmt.Sprintf("traces/%s.gz", trace))
-	if err != nil {
-		return TraceMetadata{}, nil, err
+// SliceIterator returns an Iterator over an in-memory slice of events.
+func SliceIterator(events []objiotracing.Event) Iterator {
+	return &sliceIterator{events: events}
+}
+
+type sliceIterator struct {
+	events []objiotracing.Event
+}
+
+// NextBatch is part of the Iterator interface.
+func (it *sliceIterator) NextBatch() ([]objiotracing.Event, error) {
+	const batchSize = 1024
+	if len(it.events) == 0 {
+		return nil, nil
 	}
-	reader, err := gzip.NewReader(file)
-	if err != nil {
-		file.Close()
-		return TraceMetadata{}, nil, err
+	n := len(it.events)
+	if n > batchSize {
+		n = batchSize
 	}
-	return md, newIterator(file, reader), nil
+	batch := it.events[:n]
+	it.events = it.events[n:]
+	return batch, nil
 }
+
+// Close is part of the Iterator interface.
+func (it *sliceIterator) Close() {}
@@ -0,0 +1,157 @@
+package lib
+
+// cacheEntry is an entry in one of the lists of a cache. Ghost entries (which
+// only remember that a key was recently evicted) have a nil value.
+type cacheEntry struct {
+	key   string
+	value interface{}
+	// size is the capacity occupied by the entry (1 when the cache is not
+	// byte-weighted).
+	size int64
+	// list identifies the list that contains the entry; the meaning is specific
+	// to each policy.
+	list uint8
+	// freq is a small access counter or flag; the meaning is specific to each
+	// policy.
+	freq uint8
+
+	prev, next *cacheEntry
+}
+
+// entryList is a doubly-linked list of cache entries which keeps track of the
+// total size of its entries. New entries are generally added at the front.
+type entryList struct {
+	root cacheEntry
+	len  int
+	size int64
+}
+
+func (l *entryList) lazyInit() {
+	if l.root.next == nil {
+		l.root.next = &l.root
+		l.root.prev = &l.root
+	}
+}
+
+// front returns the first entry, or nil if the list is empty.
+func (l *entryList) front() *cacheEntry {
+	if l.len == 0 {
+		return nil
+	}
+	return l.root.next
+}
+
+// back returns the last entry, or nil if the list is empty.
+func (l *entryList) back() *cacheEntry {
+	if l.len == 0 {
+		return nil
+	}
+	return l.root.prev
+}
+
+// before returns the entry in front of e, or nil if e is the first entry.
+func (l *entryList) before(e *cacheEntry) *cacheEntry {
+	if e.prev == &l.root {
+		return nil
+	}
+	return e.prev
+}
+
+func (l *entryList) pushFront(e *cacheEntry) {
+	l.lazyInit()
+	e.prev = &l.root
+	e.next = l.root.next
+	e.prev.next = e
+	e.next.prev = e
+	l.len++
+	l.size += e.size
+}
+
+func (l *entryList) remove(e *cacheEntry) {
+	e.prev.next = e.next
+	e.next.prev = e.prev
+	e.prev = nil
+	e.next = nil
+	l.len--
+	l.size -= e.size
+}
+
+func (l *entryList) moveToFront(e *cacheEntry) {
+	l.remove(e)
+	l.pushFront(e)
+}
+
+// resize changes the size of an entry in the list.
+func (l *entryList) resize(e *cacheEntry, size int64) {
+	l.size += size - e.size
+	e.size = size
+}
+
+// lruCache is a least-recently-used cache.
+type lruCache struct {
+	capacity int64
+	entries  map[string]*cacheEntry
+	list     entryList
+}
+
+var _ sizedCache = (*lruCache)(nil)
+var _ invalidatingCache = (*lruCache)(nil)
+
+func newLRUCache(capacity int64) *lruCache {
+	return &lruCache{
+		capacity: capacity,
+		entries:  make(map[string]*cacheEntry),
+	}
+}
+
+func (c *lruCache) Get(key string) interface{} {
+	e, ok := c.entries[key]
+	if !ok {
+		return nil
+	}
+	c.list.moveToFront(e)
+	return e.value
+}
+
+func (c *lruCache) Set(key string, value interface{}) {
+	c.SetSized(key, value, 1)
+}
+
+func (c *lruCache) SetSized(key string, value interface{}, size int64) {
+	if e, ok := c.entries[key]; ok {
+		e.value = value
+		c.list.resize(e, size)
+		c.list.moveToFront(e)
+	} else {
+		if size > c.capacity {
+			return
+		}
+		e := &cacheEntry{key: key, value: value, size: size}
+		c.entries[key] = e
+		c.list.pushFront(e)
+	}
+	for c.list.size > c.capacity {
+		e := c.list.back()
+		c.list.remove(e)
+		delete(c.entries, e.key)
+	}
+}
+
+func (c *lruCache) Remove(key string) {
+	if e, ok := c.entries[key]; ok {
+		c.list.remove(e)
+		delete(c.entries, key)
+	}
+}
+
+func (c *lruCache) peek(key string) (size int64, ok bool) {
+	if e, ok := c.entries[key]; ok {
+		return e.size, true
+	}
+	return 0, false
+}
+
+// residentSize returns the total size of the resident entries.
+func (c *lruCache) residentSize() int64 {
+	return c.list.size
+}
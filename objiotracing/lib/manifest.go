@@ -0,0 +1,644 @@
+package lib
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/cockroachdb/pebble/record"
+)
+
+// FileMetadata describes an sstable, as recovered from a Pebble MANIFEST.
+type FileMetadata struct {
+	// Source is the source ID of the file (see EventSource).
+	Source  uint32 `json:"source"`
+	FileNum uint64 `json:"file_num"`
+	// Level is the last level of the file (files can be moved between levels).
+	Level int    `json:"level"`
+	Size  uint64 `json:"size"`
+	// SmallestKey and LargestKey are the user key bounds of the file.
+	SmallestKey    []byte `json:"smallest_key"`
+	LargestKey     []byte `json:"largest_key"`
+	SmallestSeqNum uint64 `json:"smallest_seq_num"`
+	LargestSeqNum  uint64 `json:"largest_seq_num"`
+	// CreationTime is the creation time of the file (unix seconds), as
+	// recorded in the MANIFEST; 0 if unknown.
+	CreationTime int64 `json:"creation_time"`
+	// DeletionTime is the estimated deletion time of the file (unix seconds);
+	// 0 if the file was not deleted or the time is unknown. The MANIFEST does
+	// not record times for deletions; we use the creation time of the files
+	// added by the same version edit (typically the compaction outputs), or by
+	// the closest preceding edit that added files.
+	DeletionTime int64 `json:"deletion_time"`
+	Deleted      bool  `json:"deleted"`
+	// CreatedBy describes the version edit that added the file.
+	CreatedBy FileCreationReason `json:"created_by"`
+	// Inputs contains the files removed by the version edit that added this
+	// file; for compaction outputs, these are the compaction inputs.
+	Inputs []uint64 `json:"inputs,omitempty"`
+}
+
+// Lifetime returns the time between the creation and the deletion of the file,
+// or 0 if it is unknown.
+func (m *FileMetadata) Lifetime() time.Duration {
+	if m.CreationTime == 0 || m.DeletionTime == 0 {
+		return 0
+	}
+	return time.Duration(m.DeletionTime-m.CreationTime) * time.Second
+}
+
+// FileCreationReason is the kind of operation that created a file, as inferred
+// from the version edit that added it.
+type FileCreationReason string
+
+const (
+	CreatedByUnknown FileCreationReason = "unknown"
+	// CreatedByFlush is set when the edit adds L0 files, removes no files and
+	// advances the WAL number.
+	CreatedByFlush FileCreationReason = "flush"
+	// CreatedByCompaction is set when the edit also removes files.
+	CreatedByCompaction FileCreationReason = "compaction"
+	// CreatedByIngestion is set when the edit adds files without removing any
+	// and without advancing the WAL number.
+	CreatedByIngestion FileCreationReason = "ingestion"
+)
+
+// Manifest is the result of replaying the version edits in one or more Pebble
+// MANIFEST files.
+type Manifest struct {
+	ComparerName string
+	NumEdits     int
+	// files contains all files that were ever added.
+	files map[uint64]*FileMetadata
+	// lastEditTime is the latest file creation time seen.
+	lastEditTime int64
+}
+
+// NewManifest creates an empty Manifest; use Replay to add MANIFEST files.
+func NewManifest() *Manifest {
+	return &Manifest{files: make(map[uint64]*FileMetadata)}
+}
+
+// ReadManifest replays a single MANIFEST file.
+func ReadManifest(r io.Reader) (*Manifest, error) {
+	m := NewManifest()
+	if err := m.Replay(r); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// Replay applies the version edits in a MANIFEST file. Multiple MANIFEST files
+// (e.g. after the MANIFEST was rotated) can be replayed in order; the initial
+// snapshot in a new MANIFEST re-adds existing files, which is a no-op.
+func (m *Manifest) Replay(r io.Reader) error {
+	rr := record.NewReader(r, 0 /* logNum */)
+	for {
+		rec, err := rr.Next()
+		if err == io.EOF || errors.Is(err, record.ErrZeroedChunk) || errors.Is(err, record.ErrInvalidChunk) {
+			// The tail of the MANIFEST can be incomplete if the process was
+			// killed while writing it.
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		var ve versionEdit
+		if err := ve.decode(bufio.NewReader(rec)); err != nil {
+			return fmt.Errorf("version edit %d: %w", m.NumEdits, err)
+		}
+		m.apply(&ve)
+		m.NumEdits++
+	}
+}
+
+func (m *Manifest) apply(ve *versionEdit) {
+	if ve.comparerName != "" {
+		m.ComparerName = ve.comparerName
+	}
+	// Estimate the time of the edit.
+	var editTime int64
+	for _, nf := range ve.newFiles {
+		if nf.meta.CreationTime > editTime {
+			editTime = nf.meta.CreationTime
+		}
+	}
+	if editTime == 0 {
+		editTime = m.lastEditTime
+	}
+	m.lastEditTime = editTime
+
+	added := make(map[uint64]struct{}, len(ve.newFiles))
+	for _, nf := range ve.newFiles {
+		added[nf.meta.FileNum] = struct{}{}
+	}
+	var inputs []uint64
+	for _, df := range ve.deletedFiles {
+		if _, ok := added[df.fileNum]; ok {
+			// The file was moved to a different level.
+			continue
+		}
+		inputs = append(inputs, df.fileNum)
+		if f, ok := m.files[df.fileNum]; ok && !f.Deleted {
+			f.Deleted = true
+			f.DeletionTime = editTime
+		}
+	}
+	sort.Slice(inputs, func(i, j int) bool { return inputs[i] < inputs[j] })
+
+	for _, nf := range ve.newFiles {
+		if f, ok := m.files[nf.meta.FileNum]; ok {
+			// Moved file, or a file re-added by the snapshot at the start of a
+			// rotated MANIFEST.
+			f.Level = nf.level
+			continue
+		}
+		f := nf.meta
+		f.Level = nf.level
+		switch {
+		case len(inputs) > 0:
+			f.CreatedBy = CreatedByCompaction
+			f.Inputs = inputs
+		case nf.level == 0 && ve.hasLogNum:
+			f.CreatedBy = CreatedByFlush
+		case !ve.hasLogNum && ve.comparerName == "":
+			f.CreatedBy = CreatedByIngestion
+		default:
+			f.CreatedBy = CreatedByUnknown
+		}
+		m.files[f.FileNum] = f
+	}
+}
+
+// Files returns the metadata of all files that were ever added, sorted by file
+// number.
+func (m *Manifest) Files() []*FileMetadata {
+	res := make([]*FileMetadata, 0, len(m.files))
+	for _, f := range m.files {
+		res = append(res, f)
+	}
+	sort.Slice(res, func(i, j int) bool { return res[i].FileNum < res[j].FileNum })
+	return res
+}
+
+// Lookup returns the metadata for a file, or nil.
+func (m *Manifest) Lookup(fileNum uint64) *FileMetadata {
+	return m.files[fileNum]
+}
+
+// FileTable contains sstable metadata for all the sources of a trace, along
+// with the Pebble options (if available).
+type FileTable struct {
+	files map[fileID]*FileMetadata
+	// Options contains the parsed OPTIONS file of each source, if available
+	// (see ParseOptions).
+	Options map[uint32]map[string]string
+}
+
+// NewFileTable creates an empty FileTable.
+func NewFileTable() *FileTable {
+	return &FileTable{
+		files:   make(map[fileID]*FileMetadata),
+		Options: make(map[uint32]map[string]string),
+	}
+}
+
+// AddManifest adds the files in a manifest, for the given source ID.
+func (t *FileTable) AddManifest(source uint32, m *Manifest) {
+	for _, f := range m.files {
+		f.Source = source
+		t.files[fileID{source: source, fileNum: f.FileNum}] = f
+	}
+}
+
+// Lookup returns the metadata for a file, or nil.
+func (t *FileTable) Lookup(source uint32, fileNum uint64) *FileMetadata {
+	return t.files[fileID{source: source, fileNum: fileNum}]
+}
+
+// Len returns the number of files in the table.
+func (t *FileTable) Len() int {
+	return len(t.files)
+}
+
+// Files returns the metadata of all files, sorted by source and file number.
+func (t *FileTable) Files() []*FileMetadata {
+	res := make([]*FileMetadata, 0, len(t.files))
+	for _, f := range t.files {
+		res = append(res, f)
+	}
+	sort.Slice(res, func(i, j int) bool {
+		if res[i].Source != res[j].Source {
+			return res[i].Source < res[j].Source
+		}
+		return res[i].FileNum < res[j].FileNum
+	})
+	return res
+}
+
+// Deletions returns the deletions of all the deleted files with a known
+// deletion time, sorted by time.
+func (t *FileTable) Deletions() []Deletion {
+	var res []Deletion
+	for _, f := range t.Files() {
+		if f.Deleted && f.DeletionTime != 0 {
+			res = append(res, Deletion{
+				UnixNano: f.DeletionTime * int64(time.Second),
+				Source:   f.Source,
+				FileNum:  f.FileNum,
+			})
+		}
+	}
+	sort.SliceStable(res, func(i, j int) bool { return res[i].UnixNano < res[j].UnixNano })
+	return res
+}
+
+const (
+	manifestFilePrefix = "MANIFEST-"
+	optionsFilePrefix  = "OPTIONS-"
+)
+
+// isManifestFile returns true if the file name is a Pebble MANIFEST or OPTIONS
+// file.
+func isManifestFile(name string) bool {
+	return strings.HasPrefix(name, manifestFilePrefix) || strings.HasPrefix(name, optionsFilePrefix)
+}
+
+// loadManifestDir adds the files in the MANIFEST-* files in a directory to the
+// table, for the given source; the MANIFEST files are replayed in order of
+// their file number. The options are read from the OPTIONS-* file with the
+// highest file number, if any. The directory can also be a Pebble store
+// directory.
+func (t *FileTable) loadManifestDir(source uint32, dir string) error {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	type numberedFile struct {
+		name string
+		num  uint64
+	}
+	var manifests, options []numberedFile
+	for _, e := range entries {
+		if e.IsDir() {
+			continue
+		}
+		for _, x := range []struct {
+			prefix string
+			list   *[]numberedFile
+		}{
+			{manifestFilePrefix, &manifests},
+			{optionsFilePrefix, &options},
+		} {
+			if strings.HasPrefix(e.Name(), x.prefix) {
+				numStr := strings.TrimPrefix(e.Name(), x.prefix)
+				if num, err := strconv.ParseUint(numStr, 10, 64); err == nil {
+					*x.list = append(*x.list, numberedFile{name: e.Name(), num: num})
+				}
+			}
+		}
+	}
+	sort.Slice(manifests, func(i, j int) bool { return manifests[i].num < manifests[j].num })
+	sort.Slice(options, func(i, j int) bool { return options[i].num < options[j].num })
+
+	if len(manifests) > 0 {
+		m := NewManifest()
+		for _, f := range manifests {
+			if err := replayFile(m, filepath.Join(dir, f.name)); err != nil {
+				return err
+			}
+		}
+		t.AddManifest(source, m)
+	}
+	if len(options) > 0 {
+		filename := filepath.Join(dir, options[len(options)-1].name)
+		f, err := os.Open(filename)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		opts, err := ParseOptions(f)
+		if err != nil {
+			return fmt.Errorf("%s: %w", filename, err)
+		}
+		t.Options[source] = opts
+	}
+	return nil
+}
+
+func replayFile(m *Manifest, filename string) error {
+	f, err := os.Open(filename)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if err := m.Replay(bufio.NewReader(f)); err != nil {
+		return fmt.Errorf("%s: %w", filename, err)
+	}
+	return nil
+}
+
+// ParseOptions parses a Pebble OPTIONS file into a map with "section.key"
+// keys, e.g. "Options.mem_table_size" or `Level "6".target_file_size`.
+func ParseOptions(r io.Reader) (map[string]string, error) {
+	res := make(map[string]string)
+	scanner := bufio.NewScanner(r)
+	section := ""
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		switch {
+		case line == "" || strings.HasPrefix(line, "#"):
+		case strings.HasPrefix(line, "[") && strings.HasSuffix(line, "]"):
+			section = line[1 : len(line)-1]
+		default:
+			k, v, ok := strings.Cut(line, "=")
+			if !ok {
+				return nil, fmt.Errorf("invalid OPTIONS line %q", line)
+			}
+			res[section+"."+strings.TrimSpace(k)] = strings.TrimSpace(v)
+		}
+	}
+	return res, scanner.Err()
+}
+
+// Tags for the version edit encoding. See pebble/internal/manifest, which we
+// can't import.
+const (
+	veTagComparator     = 1
+	veTagLogNumber      = 2
+	veTagNextFileNumber = 3
+	veTagLastSequence   = 4
+	veTagCompactPointer = 5
+	veTagDeletedFile    = 6
+	veTagNewFile        = 7
+	veTagPrevLogNumber  = 9
+	veTagNewFile2       = 100
+	veTagNewFile3       = 102
+	veTagNewFile4       = 103
+	veTagNewFile5       = 104
+
+	veCustomTagTerminate         = 1
+	veCustomTagCreationTime      = 6
+	veCustomTagNonSafeIgnoreMask = 1 << 6
+
+	veMaskContainsPointKeys = 1 << 0
+	veMaskSmallest          = 1 << 1
+	veMaskLargest           = 1 << 2
+)
+
+// versionEdit contains the parts of a Pebble version edit that we care about.
+type versionEdit struct {
+	comparerName string
+	hasLogNum    bool
+	deletedFiles []veDeletedFile
+	newFiles     []veNewFile
+}
+
+type veDeletedFile struct {
+	level   int
+	fileNum uint64
+}
+
+type veNewFile struct {
+	level int
+	meta  *FileMetadata
+}
+
+type veDecoder struct {
+	*bufio.Reader
+}
+
+func (d veDecoder) uvarint() (uint64, error) {
+	v, err := binary.ReadUvarint(d)
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	return v, err
+}
+
+func (d veDecoder) bytes() ([]byte, error) {
+	n, err := d.uvarint()
+	if err != nil {
+		return nil, err
+	}
+	buf := make([]byte, n)
+	if _, err := io.ReadFull(d, buf); err != nil {
+		return nil, err
+	}
+	return buf, nil
+}
+
+// userKey returns the user key part of an encoded internal key.
+func userKey(internalKey []byte) []byte {
+	if len(internalKey) < 8 {
+		return internalKey
+	}
+	return internalKey[:len(internalKey)-8]
+}
+
+func (ve *versionEdit) decode(r *bufio.Reader) error {
+	d := veDecoder{r}
+	for {
+		tag, err := binary.ReadUvarint(r)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		switch tag {
+		case veTagComparator:
+			name, err := d.bytes()
+			if err != nil {
+				return err
+			}
+			ve.comparerName = string(name)
+
+		case veTagLogNumber:
+			if _, err := d.uvarint(); err != nil {
+				return err
+			}
+			ve.hasLogNum = true
+
+		case veTagNextFileNumber, veTagLastSequence, veTagPrevLogNumber:
+			if _, err := d.uvarint(); err != nil {
+				return err
+			}
+
+		case veTagCompactPointer:
+			if _, err := d.uvarint(); err != nil {
+				return err
+			}
+			if _, err := d.bytes(); err != nil {
+				return err
+			}
+
+		case veTagDeletedFile:
+			level, err := d.uvarint()
+			if err != nil {
+				return err
+			}
+			fileNum, err := d.uvarint()
+			if err != nil {
+				return err
+			}
+			ve.deletedFiles = append(ve.deletedFiles, veDeletedFile{level: int(level), fileNum: fileNum})
+
+		case veTagNewFile, veTagNewFile2, veTagNewFile3, veTagNewFile4, veTagNewFile5:
+			nf, err := ve.decodeNewFile(d, tag)
+			if err != nil {
+				return err
+			}
+			ve.newFiles = append(ve.newFiles, nf)
+
+		default:
+			return fmt.Errorf("unsupported version edit tag %d", tag)
+		}
+	}
+}
+
+func (ve *versionEdit) decodeNewFile(d veDecoder, tag uint64) (veNewFile, error) {
+	level, err := d.uvarint()
+	if err != nil {
+		return veNewFile{}, err
+	}
+	m := &FileMetadata{}
+	if m.FileNum, err = d.uvarint(); err != nil {
+		return veNewFile{}, err
+	}
+	if tag == veTagNewFile3 {
+		// Path ID (unused).
+		if _, err := d.uvarint(); err != nil {
+			return veNewFile{}, err
+		}
+	}
+	if m.Size, err = d.uvarint(); err != nil {
+		return veNewFile{}, err
+	}
+
+	// Read the bounds. Files with range keys (tag 104) have a marker byte which
+	// indicates whether there are point key bounds and which bounds are the
+	// overall bounds.
+	var smallestPoint, largestPoint, smallestRange, largestRange []byte
+	marker := byte(veMaskContainsPointKeys | veMaskSmallest | veMaskLargest)
+	if tag == veTagNewFile5 {
+		if marker, err = d.ReadByte(); err != nil {
+			return veNewFile{}, err
+		}
+	}
+	if marker&veMaskContainsPointKeys != 0 {
+		if smallestPoint, err = d.bytes(); err != nil {
+			return veNewFile{}, err
+		}
+		if largestPoint, err = d.bytes(); err != nil {
+			return veNewFile{}, err
+		}
+	}
+	if tag == veTagNewFile5 {
+		if smallestRange, err = d.bytes(); err != nil {
+			return veNewFile{}, err
+		}
+		if largestRange, err = d.bytes(); err != nil {
+			return veNewFile{}, err
+		}
+	}
+	m.SmallestKey = userKey(smallestRange)
+	if marker&veMaskSmallest != 0 {
+		m.SmallestKey = userKey(smallestPoint)
+	}
+	m.LargestKey = userKey(largestRange)
+	if marker&veMaskLargest != 0 {
+		m.LargestKey = userKey(largestPoint)
+	}
+
+	if tag != veTagNewFile {
+		if m.SmallestSeqNum, err = d.uvarint(); err != nil {
+			return veNewFile{}, err
+		}
+		if m.LargestSeqNum, err = d.uvarint(); err != nil {
+			return veNewFile{}, err
+		}
+	}
+	if tag == veTagNewFile4 || tag == veTagNewFile5 {
+		for {
+			customTag, err := d.uvarint()
+			if err != nil {
+				return veNewFile{}, err
+			}
+			if customTag == veCustomTagTerminate {
+				break
+			}
+			field, err := d.bytes()
+			if err != nil {
+				return veNewFile{}, err
+			}
+			switch {
+			case customTag == veCustomTagCreationTime:
+				v, n := binary.Uvarint(field)
+				if n != len(field) {
+					return veNewFile{}, errors.New("invalid file creation time")
+				}
+				m.CreationTime = int64(v)
+			case customTag&veCustomTagNonSafeIgnoreMask != 0:
+				return veNewFile{}, fmt.Errorf("unsupported custom field %d", customTag)
+			}
+		}
+	}
+	return veNewFile{level: int(level), meta: m}, nil
+}
+
+// encodeVersionEdit is the inverse of versionEdit.decode, for the fields we
+// decode; it is used by tests.
+func encodeVersionEdit(ve *versionEdit) []byte {
+	var buf bytes.Buffer
+	putUvarint := func(v uint64) {
+		var tmp [binary.MaxVarintLen64]byte
+		buf.Write(tmp[:binary.PutUvarint(tmp[:], v)])
+	}
+	putBytes := func(b []byte) {
+		putUvarint(uint64(len(b)))
+		buf.Write(b)
+	}
+	internalKey := func(userKey []byte) []byte {
+		return append(append([]byte(nil), userKey...), make([]byte, 8)...)
+	}
+	if ve.comparerName != "" {
+		putUvarint(veTagComparator)
+		putBytes([]byte(ve.comparerName))
+	}
+	if ve.hasLogNum {
+		putUvarint(veTagLogNumber)
+		putUvarint(1)
+	}
+	for _, df := range ve.deletedFiles {
+		putUvarint(veTagDeletedFile)
+		putUvarint(uint64(df.level))
+		putUvarint(df.fileNum)
+	}
+	for _, nf := range ve.newFiles {
+		putUvarint(veTagNewFile4)
+		putUvarint(uint64(nf.level))
+		putUvarint(nf.meta.FileNum)
+		putUvarint(nf.meta.Size)
+		putBytes(internalKey(nf.meta.SmallestKey))
+		putBytes(internalKey(nf.meta.LargestKey))
+		putUvarint(nf.meta.SmallestSeqNum)
+		putUvarint(nf.meta.LargestSeqNum)
+		if nf.meta.CreationTime != 0 {
+			putUvarint(veCustomTagCreationTime)
+			var tmp [binary.MaxVarintLen64]byte
+			putBytes(tmp[:binary.PutUvarint(tmp[:], uint64(nf.meta.CreationTime))])
+		}
+		putUvarint(veCustomTagTerminate)
+	}
+	return buf.Bytes()
+}
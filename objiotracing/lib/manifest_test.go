@@ -0,0 +1,212 @@
+package lib
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/cockroachdb/pebble/objstorage/objstorageprovider/objiotracing"
+	"github.com/cockroachdb/pebble/record"
+	"github.com/stretchr/testify/require"
+)
+
+// writeTestManifest writes a MANIFEST with the given version edits.
+func writeTestManifest(t *testing.T, filename string, edits ...versionEdit) {
+	var buf bytes.Buffer
+	w := record.NewWriter(&buf)
+	for i := range edits {
+		_, err := w.WriteRecord(encodeVersionEdit(&edits[i]))
+		require.NoError(t, err)
+	}
+	require.NoError(t, w.Close())
+	require.NoError(t, os.MkdirAll(filepath.Dir(filename), 0777))
+	require.NoError(t, os.WriteFile(filename, buf.Bytes(), 0666))
+}
+
+func newFile(level int, fileNum uint64, creationTime int64) veNewFile {
+	return veNewFile{level: level, meta: &FileMetadata{
+		FileNum:      fileNum,
+		Size:         fileNum * 1000,
+		SmallestKey:  []byte("a"),
+		LargestKey:   []byte("z"),
+		CreationTime: creationTime,
+	}}
+}
+
+// testManifestEdits are version edits for: two flushes (files 1 and 2), a
+// compaction of files 1 and 2 into file 3, a move of file 3 to L6, and an
+// ingestion of file 4.
+var testManifestEdits = []versionEdit{
+	{comparerName: "leveldb.BytewiseComparator"},
+	{hasLogNum: true, newFiles: []veNewFile{newFile(0, 1, 100)}},
+	{hasLogNum: true, newFiles: []veNewFile{newFile(0, 2, 110)}},
+	{
+		deletedFiles: []veDeletedFile{{0, 1}, {0, 2}},
+		newFiles:     []veNewFile{newFile(5, 3, 150)},
+	},
+	{
+		deletedFiles: []veDeletedFile{{5, 3}},
+		newFiles:     []veNewFile{newFile(6, 3, 150)},
+	},
+	{newFiles: []veNewFile{newFile(6, 4, 200)}},
+}
+
+func TestReadManifest(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "MANIFEST-000001")
+	writeTestManifest(t, filename, testManifestEdits...)
+	f, err := os.Open(filename)
+	require.NoError(t, err)
+	defer f.Close()
+	m, err := ReadManifest(f)
+	require.NoError(t, err)
+	require.Equal(t, "leveldb.BytewiseComparator", m.ComparerName)
+	require.Equal(t, len(testManifestEdits), m.NumEdits)
+
+	type summary struct {
+		fileNum      uint64
+		level        int
+		createdBy    FileCreationReason
+		deletionTime int64
+		inputs       []uint64
+	}
+	var res []summary
+	for _, f := range m.Files() {
+		require.Equal(t, "a", string(f.SmallestKey))
+		require.Equal(t, "z", string(f.LargestKey))
+		require.Equal(t, f.Deleted, f.DeletionTime != 0)
+		res = append(res, summary{f.FileNum, f.Level, f.CreatedBy, f.DeletionTime, f.Inputs})
+	}
+	require.Equal(t, []summary{
+		{1, 0, CreatedByFlush, 150, nil},
+		{2, 0, CreatedByFlush, 150, nil},
+		{3, 6, CreatedByCompaction, 0, []uint64{1, 2}},
+		{4, 6, CreatedByIngestion, 0, nil},
+	}, res)
+	require.Equal(t, 50*time.Second, m.Lookup(1).Lifetime())
+
+	// A truncated MANIFEST is read up to the last complete edit.
+	buf, err := os.ReadFile(filename)
+	require.NoError(t, err)
+	m, err = ReadManifest(bytes.NewReader(buf[:len(buf)-5]))
+	require.NoError(t, err)
+	require.Equal(t, len(testManifestEdits)-1, m.NumEdits)
+	require.Nil(t, m.Lookup(4))
+}
+
+func TestParseOptions(t *testing.T) {
+	opts, err := ParseOptions(bytes.NewReader([]byte(`
+[Version]
+  pebble_version=0.1
+
+[Options]
+  bytes_per_sync=524288
+  cache_size=8388608
+
+[Level "0"]
+  block_size=4096
+  target_file_size=2097152
+`)))
+	require.NoError(t, err)
+	require.Equal(t, "8388608", opts["Options.cache_size"])
+	require.Equal(t, "2097152", opts[`Level "0".target_file_size`])
+	require.Len(t, opts, 5)
+
+	_, err = ParseOptions(bytes.NewReader([]byte("[Options]\n  invalid\n")))
+	require.Error(t, err)
+}
+
+func TestStoreFileTable(t *testing.T) {
+	root := t.TempDir()
+	s := NewDirStore(root)
+	require.NoError(t, s.Add(TraceMetadata{Name: "multi", Sources: []string{"n1", "n2"}}, SliceIterator(nil)))
+
+	manifest := filepath.Join(t.TempDir(), "MANIFEST-000001")
+	writeTestManifest(t, manifest, testManifestEdits...)
+	require.Error(t, s.AttachManifest("multi", "", manifest))
+	require.Error(t, s.AttachManifest("multi", "n3", manifest))
+	require.NoError(t, s.AttachManifest("multi", "n2", manifest))
+	// The MANIFEST was rotated; the new one starts with a snapshot of the
+	// current version.
+	manifest2 := filepath.Join(t.TempDir(), "MANIFEST-000010")
+	writeTestManifest(t, manifest2,
+		versionEdit{comparerName: "leveldb.BytewiseComparator", newFiles: []veNewFile{newFile(6, 3, 150), newFile(6, 4, 200)}},
+		versionEdit{deletedFiles: []veDeletedFile{{6, 4}}, newFiles: []veNewFile{newFile(6, 5, 300)}},
+	)
+	require.NoError(t, s.AttachManifest("multi", "n2", manifest2))
+
+	files, err := s.FileTable("multi")
+	require.NoError(t, err)
+	require.Equal(t, 5, files.Len())
+	require.Nil(t, files.Lookup(1, 1))
+	f := files.Lookup(2, 4)
+	require.NotNil(t, f)
+	require.Equal(t, CreatedByIngestion, f.CreatedBy)
+	require.Equal(t, int64(300), f.DeletionTime)
+	require.Equal(t, []Deletion{
+		{UnixNano: 150 * int64(time.Second), Source: 2, FileNum: 1},
+		{UnixNano: 150 * int64(time.Second), Source: 2, FileNum: 2},
+		{UnixNano: 300 * int64(time.Second), Source: 2, FileNum: 4},
+	}, files.Deletions())
+
+	// Raw traces use the files next to the IOTRACES- files.
+	raw := filepath.Join(root, "raw", "n1")
+	require.NoError(t, os.MkdirAll(raw, 0777))
+	require.NoError(t, os.WriteFile(filepath.Join(raw, "IOTRACES-2023-01-01T00:00:00Z"), nil, 0666))
+	writeTestManifest(t, filepath.Join(raw, "MANIFEST-000001"), testManifestEdits...)
+	require.NoError(t, os.WriteFile(filepath.Join(raw, "OPTIONS-000003"), []byte("[Options]\n  cache_size=1\n"), 0666))
+	// A second node without MANIFEST files.
+	raw2 := filepath.Join(root, "raw", "n2")
+	require.NoError(t, os.MkdirAll(raw2, 0777))
+	require.NoError(t, os.WriteFile(filepath.Join(raw2, "IOTRACES-2023-01-01T00:00:00Z"), nil, 0666))
+	require.Error(t, s.AttachManifest("raw", "n1", manifest))
+	files, err = s.FileTable("raw")
+	require.NoError(t, err)
+	require.Equal(t, 4, files.Len())
+	require.NotNil(t, files.Lookup(1, 3))
+	require.Equal(t, "1", files.Options[1]["Options.cache_size"])
+
+	require.NoError(t, s.Delete("multi"))
+	require.NoDirExists(t, filepath.Join(root, "multi.manifest"))
+}
+
+func TestSimulateFileTable(t *testing.T) {
+	read := func(secs int, fileNum uint64) objiotracing.Event {
+		e := objiotracing.Event{
+			StartUnixNano: int64(secs) * int64(time.Second),
+			Op:            objiotracing.ReadOp,
+			Size:          100,
+		}
+		setFileNum(&e.FileNum, fileNum)
+		return e
+	}
+	// Files 1 and 2 are deleted at 150s.
+	files := NewFileTable()
+	m := NewManifest()
+	for _, ve := range testManifestEdits {
+		ve := ve
+		m.apply(&ve)
+	}
+	files.AddManifest(0, m)
+
+	trace := []objiotracing.Event{
+		read(100, 1), read(140, 2), read(145, 2), read(160, 3), read(170, 1), read(180, 3),
+	}
+	config := Config{Policy: LRU, CacheSize: 10, Deletion: DeleteManifest}
+	_, err := Simulate(t.Name(), &wrappedTrace{inner: trace}, config, nil /* files */)
+	require.Error(t, err)
+
+	results, err := Simulate(t.Name(), &wrappedTrace{inner: trace}, config, files)
+	require.NoError(t, err)
+	require.Equal(t, 2, results.DeletedFiles)
+	require.Equal(t, 1, results.DeletedFileReads)
+
+	// With a minimum lifetime of 30s, the block of file 2 is not admitted, so
+	// its second read is a miss.
+	config = Config{Policy: LRU, CacheSize: 10, Admission: AdmitFileLifetime, AdmissionMinFileLifetimeSecs: 30}
+	results, err = Simulate(t.Name(), &wrappedTrace{inner: trace}, config, files)
+	require.NoError(t, err)
+	require.Equal(t, 2, results.Hits)
+	require.Equal(t, 4, results.Misses)
+}
@@ -0,0 +1,114 @@
+package lib
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/cockroachdb/pebble/objstorage/objstorageprovider/objiotracing"
+)
+
+// The objiotracing enums don't have String methods; these names are used for
+// reporting and for parsing command-line arguments and requests.
+
+var opNames = []string{
+	objiotracing.ReadOp:           "read",
+	objiotracing.WriteOp:          "write",
+	objiotracing.RecordCacheHitOp: "cache-hit",
+	objiotracing.MaxReadaheadOp:   "max-readahead",
+}
+
+var reasonNames = []string{
+	objiotracing.UnknownReason: "unknown",
+	objiotracing.ForFlush:      "flush",
+	objiotracing.ForCompaction: "compaction",
+	objiotracing.ForIngestion:  "ingestion",
+}
+
+var blockTypeNames = []string{
+	objiotracing.UnknownBlock:  "unknown",
+	objiotracing.DataBlock:     "data",
+	objiotracing.ValueBlock:    "value",
+	objiotracing.FilterBlock:   "filter",
+	objiotracing.MetadataBlock: "metadata",
+}
+
+func enumName(names []string, v uint8) string {
+	if int(v) < len(names) {
+		return names[v]
+	}
+	return fmt.Sprintf("%d", v)
+}
+
+func parseEnum(kind string, names []string, s string) (uint8, error) {
+	for i, n := range names {
+		if strings.EqualFold(n, s) {
+			return uint8(i), nil
+		}
+	}
+	return 0, fmt.Errorf("invalid %s %q (valid values: %s)", kind, s, strings.Join(names, ", "))
+}
+
+// OpName returns a short name for the op type (e.g. "read").
+func OpName(op objiotracing.OpType) string {
+	return enumName(opNames, uint8(op))
+}
+
+// ReasonName returns a short name for the reason (e.g. "compaction").
+func ReasonName(reason objiotracing.Reason) string {
+	return enumName(reasonNames, uint8(reason))
+}
+
+// BlockTypeName returns a short name for the block type (e.g. "data").
+func BlockTypeName(blockType objiotracing.BlockType) string {
+	return enumName(blockTypeNames, uint8(blockType))
+}
+
+// LevelName returns the name of the LSM level (e.g. "L6"), given the
+// LevelPlusOne field of an event.
+func LevelName(levelPlusOne uint8) string {
+	if levelPlusOne == 0 {
+		return "unknown"
+	}
+	return fmt.Sprintf("L%d", levelPlusOne-1)
+}
+
+// fileName returns a name for an sstable (e.g. "000123", or "s2/000123" for a
+// file from source 2).
+func fileName(source uint32, fileNum uint64) string {
+	if source == 0 {
+		return fmt.Sprintf("%06d", fileNum)
+	}
+	return fmt.Sprintf("s%d/%06d", source, fileNum)
+}
+
+// ParseOp parses an op type name (see OpName).
+func ParseOp(s string) (objiotracing.OpType, error) {
+	v, err := parseEnum("op", opNames, s)
+	return objiotracing.OpType(v), err
+}
+
+// ParseReason parses a reason name (see ReasonName).
+func ParseReason(s string) (objiotracing.Reason, error) {
+	v, err := parseEnum("reason", reasonNames, s)
+	return objiotracing.Reason(v), err
+}
+
+// ParseBlockType parses a block type name (see BlockTypeName).
+func ParseBlockType(s string) (objiotracing.BlockType, error) {
+	v, err := parseEnum("block type", blockTypeNames, s)
+	return objiotracing.BlockType(v), err
+}
+
+// ParseLevel parses a level name (see LevelName) and returns the corresponding
+// LevelPlusOne value. Both "L5" and "5" are accepted.
+func ParseLevel(s string) (uint8, error) {
+	if strings.EqualFold(s, "unknown") {
+		return 0, nil
+	}
+	level, err := strconv.ParseUint(strings.TrimPrefix(strings.ToUpper(s), "L"), 10, 8)
+	if err != nil || level > 6 {
+		return 0, fmt.Errorf("invalid level %q", s)
+	}
+	return uint8(level) + 1, nil
+}
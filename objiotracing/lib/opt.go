@@ -0,0 +1,389 @@
+package lib
+
+import (
+	"container/heap"
+	"math"
+	"os"
+	"unsafe"
+)
+
+// simulateOPT runs the simulation for Belady's offline optimal policy (OPT).
+//
+// OPT needs to know when each block will be accessed next. We make a first
+// pass over the trace which records all cache accesses (with each block mapped
+// to an integer ID), compute the next use of each access with a backward pass
+// over the recorded accesses, and then replay the accesses against the cache.
+// The access log and the next-use index are spilled to temporary files when
+// the trace is large (see optMaxInMemoryOps).
+func simulateOPT(it iterator, config *Config, files *FileTable, results *Results) error {
+	keyIDs := make(map[blockID]uint32)
+	var sources []uint32
+	// Admission decisions only depend on the sequence of accesses, so we make
+	// them in the first pass.
+	admitters := make(map[uint32]admitter)
+	ops := newSpillSlice[optOp](optMaxInMemoryOps)
+	defer ops.close()
+	var err error
+	if iterErr := forEachAccess(it, config, nil /* onEvent */, func(a access) {
+		if err != nil {
+			return
+		}
+		id, ok := keyIDs[a.id]
+		if !ok {
+			id = uint32(len(keyIDs))
+			keyIDs[a.id] = id
+			sources = append(sources, a.id.source)
+		}
+		source := uint32(0)
+		if config.CachePerSource {
+			source = a.id.source
+		}
+		adm, ok := admitters[source]
+		if !ok {
+			adm = newAdmitter(config, files)
+			admitters[source] = adm
+		}
+		op := optOp{keyID: id, size: a.size, overRead: a.overRead}
+		if a.write {
+			op.flags |= optWrite
+		}
+		if a.lastBlock {
+			op.flags |= optLastBlock
+		}
+		if !adm.admit(&a) {
+			op.flags |= optNoAdmit
+		}
+		err = ops.append(op)
+	}); iterErr != nil {
+		return iterErr
+	}
+	if err != nil {
+		return err
+	}
+	if err := ops.finish(); err != nil {
+		return err
+	}
+	// We don't need the map anymore, only the source of each key.
+	keyIDs = nil
+
+	n := ops.len()
+	nextUse, err := makeSpillSlice[int64](n, optMaxInMemoryOps)
+	if err != nil {
+		return err
+	}
+	defer nextUse.close()
+
+	const chunkSize = 64 * 1024
+	opsBuf := make([]optOp, chunkSize)
+	nextUseBuf := make([]int64, chunkSize)
+
+	// Backward pass: compute the next use of each access.
+	last := make([]int64, len(sources))
+	for i := range last {
+		last[i] = optNever
+	}
+	for end := n; end > 0; {
+		start := end - chunkSize
+		if start < 0 {
+			start = 0
+		}
+		chunk := opsBuf[:end-start]
+		if err := ops.readAt(start, chunk); err != nil {
+			return err
+		}
+		for j := len(chunk) - 1; j >= 0; j-- {
+			nextUseBuf[j] = last[chunk[j].keyID]
+			last[chunk[j].keyID] = start + int64(j)
+		}
+		if err := nextUse.writeAt(start, nextUseBuf[:len(chunk)]); err != nil {
+			return err
+		}
+		end = start
+	}
+	last = nil
+
+	// Forward pass: replay the accesses.
+	caches := make(map[uint32]*optCache)
+	var reads readCounter
+	for start := int64(0); start < n; start += chunkSize {
+		end := start + chunkSize
+		if end > n {
+			end = n
+		}
+		chunk := opsBuf[:end-start]
+		if err := ops.readAt(start, chunk); err != nil {
+			return err
+		}
+		if err := nextUse.readAt(start, nextUseBuf[:len(chunk)]); err != nil {
+			return err
+		}
+		for j := range chunk {
+			op := &chunk[j]
+			source := uint32(0)
+			if config.CachePerSource {
+				source = sources[op.keyID]
+			}
+			c, ok := caches[source]
+			if !ok {
+				c = newOPTCache(int64(config.CacheSize))
+				caches[source] = c
+			}
+			weight := int64(1)
+			if config.ByteWeighted {
+				weight = op.size
+			}
+			hit := c.access(op.keyID, weight, nextUseBuf[j], op.flags&optNoAdmit == 0)
+			if op.flags&optWrite == 0 {
+				reads.add(hit, op.flags&optLastBlock != 0, results)
+				if hit {
+					results.Hits++
+					results.HitBytes += op.size
+				} else {
+					results.Misses++
+					results.MissBytes += op.size
+					results.BlockOverReadBytes += op.overRead
+					results.ObjectStoreRequests++
+					results.ObjectStoreBytes += op.size
+					results.RequestSizes.Add(op.size)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// optNever is the next use of an access to a block which is never accessed
+// again.
+const optNever = math.MaxInt64
+
+// optMaxInMemoryOps is the number of accesses above which simulateOPT uses
+// temporary files for the access log and the next-use index.
+var optMaxInMemoryOps = 16 << 20
+
+type optOp struct {
+	keyID    uint32
+	flags    uint32
+	size     int64
+	overRead int64
+}
+
+// Values for optOp.flags.
+const (
+	optWrite = 1 << iota
+	optNoAdmit
+	optLastBlock
+)
+
+// optCache implements Belady's algorithm, given the next use of each access.
+// Blocks are only admitted if they will be used before some block that would
+// need to be evicted to make room.
+//
+// In byte-weighted mode, this is a heuristic (the optimal solution with
+// variable sizes is NP-hard), but it is still a very good upper bound.
+type optCache struct {
+	capacity int64
+	used     int64
+	items    map[uint32]*optItem
+	// heap contains all items, with the one used furthest in the future at
+	// the top.
+	heap optHeap
+	// evicted is used as temporary storage in access.
+	evicted []*optItem
+}
+
+type optItem struct {
+	key     uint32
+	size    int64
+	nextUse int64
+	index   int
+}
+
+func newOPTCache(capacity int64) *optCache {
+	return &optCache{
+		capacity: capacity,
+		items:    make(map[uint32]*optItem),
+	}
+}
+
+// access processes an access to a block which occupies the given capacity (1
+// in entry-count mode); returns true if it was a hit. If admit is false, the
+// block is not inserted on a miss.
+func (c *optCache) access(key uint32, size int64, nextUse int64, admit bool) bool {
+	if item, ok := c.items[key]; ok {
+		item.nextUse = nextUse
+		heap.Fix(&c.heap, item.index)
+		return true
+	}
+	if !admit || nextUse == optNever || size > c.capacity {
+		return false
+	}
+	// Evict blocks which are used later than this block, until we have room.
+	c.evicted = c.evicted[:0]
+	for c.used+size > c.capacity && c.heap[0].nextUse > nextUse {
+		item := heap.Pop(&c.heap).(*optItem)
+		c.used -= item.size
+		c.evicted = append(c.evicted, item)
+	}
+	if c.used+size > c.capacity {
+		// All remaining blocks are needed before this one; don't admit it (and
+		// put back anything we evicted).
+		for _, item := range c.evicted {
+			heap.Push(&c.heap, item)
+			c.used += item.size
+		}
+		return false
+	}
+	for _, item := range c.evicted {
+		delete(c.items, item.key)
+	}
+	item := &optItem{key: key, size: size, nextUse: nextUse}
+	c.items[key] = item
+	heap.Push(&c.heap, item)
+	c.used += size
+	return false
+}
+
+type optHeap []*optItem
+
+func (h optHeap) Len() int           { return len(h) }
+func (h optHeap) Less(i, j int) bool { return h[i].nextUse > h[j].nextUse }
+func (h optHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index = i
+	h[j].index = j
+}
+func (h *optHeap) Push(x any) {
+	item := x.(*optItem)
+	item.index = len(*h)
+	*h = append(*h, item)
+}
+func (h *optHeap) Pop() any {
+	old := *h
+	n := len(old)
+	item := old[n-1]
+	old[n-1] = nil
+	*h = old[:n-1]
+	return item
+}
+
+// spillSlice is an append-only (or fixed size) array of fixed-size records
+// which is kept in memory up to a limit and in a temporary file beyond that.
+type spillSlice[T any] struct {
+	limit int
+	n     int64
+	// mem contains all the records if file is nil; otherwise it is a write
+	// buffer for records that have not been written to the file yet.
+	mem  []T
+	file *os.File
+}
+
+func newSpillSlice[T any](limit int) *spillSlice[T] {
+	return &spillSlice[T]{limit: limit}
+}
+
+// makeSpillSlice creates a spillSlice with n (zero) records, to be set with
+// writeAt.
+func makeSpillSlice[T any](n int64, limit int) (*spillSlice[T], error) {
+	s := &spillSlice[T]{limit: limit, n: n}
+	if n <= int64(limit) {
+		s.mem = make([]T, n)
+		return s, nil
+	}
+	if err := s.createFile(); err != nil {
+		return nil, err
+	}
+	var zero T
+	if err := s.file.Truncate(n * int64(unsafe.Sizeof(zero))); err != nil {
+		s.close()
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *spillSlice[T]) createFile() error {
+	f, err := os.CreateTemp("", "objiotracing-spill-")
+	if err != nil {
+		return err
+	}
+	// Unlink the file right away; it is removed when it is closed.
+	os.Remove(f.Name())
+	s.file = f
+	return nil
+}
+
+func asBytes[T any](vals []T) []byte {
+	if len(vals) == 0 {
+		return nil
+	}
+	var zero T
+	return unsafe.Slice((*byte)(unsafe.Pointer(&vals[0])), len(vals)*int(unsafe.Sizeof(zero)))
+}
+
+func (s *spillSlice[T]) len() int64 {
+	return s.n
+}
+
+// append adds a record at the end.
+func (s *spillSlice[T]) append(v T) error {
+	s.mem = append(s.mem, v)
+	s.n++
+	if len(s.mem) < s.limit {
+		return nil
+	}
+	if s.file == nil {
+		if err := s.createFile(); err != nil {
+			return err
+		}
+	}
+	return s.flush()
+}
+
+// flush writes out the records buffered in memory (if the records are being
+// spilled to a file).
+func (s *spillSlice[T]) flush() error {
+	if s.file == nil || len(s.mem) == 0 {
+		return nil
+	}
+	var zero T
+	pos := (s.n - int64(len(s.mem))) * int64(unsafe.Sizeof(zero))
+	if _, err := s.file.WriteAt(asBytes(s.mem), pos); err != nil {
+		return err
+	}
+	s.mem = s.mem[:0]
+	return nil
+}
+
+// finish must be called after the last append.
+func (s *spillSlice[T]) finish() error {
+	return s.flush()
+}
+
+// readAt reads len(buf) records starting at record i.
+func (s *spillSlice[T]) readAt(i int64, buf []T) error {
+	if s.file == nil {
+		copy(buf, s.mem[i:i+int64(len(buf))])
+		return nil
+	}
+	var zero T
+	_, err := s.file.ReadAt(asBytes(buf), i*int64(unsafe.Sizeof(zero)))
+	return err
+}
+
+// writeAt overwrites len(vals) records starting at record i.
+func (s *spillSlice[T]) writeAt(i int64, vals []T) error {
+	if s.file == nil {
+		copy(s.mem[i:], vals)
+		return nil
+	}
+	var zero T
+	_, err := s.file.WriteAt(asBytes(vals), i*int64(unsafe.Sizeof(zero)))
+	return err
+}
+
+func (s *spillSlice[T]) close() {
+	if s.file != nil {
+		s.file.Close()
+		s.file = nil
+	}
+	s.mem = nil
+}
@@ -0,0 +1,354 @@
+package lib
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/cockroachdb/pebble/objstorage/objstorageprovider/objiotracing"
+)
+
+// TimeAxis describes the ticks of a time series: tick i covers
+// [StartUnixNano + i*TickNanos, StartUnixNano + (i+1)*TickNanos).
+type TimeAxis struct {
+	StartUnixNano int64
+	TickNanos     int64
+	NumTicks      int
+}
+
+// Tick returns the index of the tick which contains the given time; times
+// before the start of the axis belong to tick 0. The result can be NumTicks or
+// larger.
+func (a TimeAxis) Tick(unixNano int64) int {
+	if unixNano <= a.StartUnixNano {
+		return 0
+	}
+	return int((unixNano - a.StartUnixNano) / a.TickNanos)
+}
+
+// UnixSecs returns the start times of the first numTicks ticks, in (fractional)
+// Unix seconds.
+func (a TimeAxis) UnixSecs(numTicks int) []float64 {
+	res := make([]float64, numTicks)
+	for i := range res {
+		res[i] = float64(a.StartUnixNano+int64(i)*a.TickNanos) / float64(time.Second)
+	}
+	return res
+}
+
+// PlotMetric is the value plotted for each tick (see PlotConfig).
+type PlotMetric int
+
+const (
+	// PlotMBPS is the throughput, in MB/s.
+	PlotMBPS PlotMetric = iota
+	// PlotOpsPerSec is the number of operations per second.
+	PlotOpsPerSec
+	// PlotMeanSize is the mean operation size, in bytes.
+	PlotMeanSize
+)
+
+var plotMetricNames = []string{
+	PlotMBPS:      "mbps",
+	PlotOpsPerSec: "ops",
+	PlotMeanSize:  "mean-size",
+}
+
+var plotMetricUnits = []string{
+	PlotMBPS:      "MB/s",
+	PlotOpsPerSec: "ops/s",
+	PlotMeanSize:  "bytes",
+}
+
+func (m PlotMetric) String() string {
+	return enumName(plotMetricNames, uint8(m))
+}
+
+// ParsePlotMetric parses a metric name (e.g. "mbps").
+func ParsePlotMetric(s string) (PlotMetric, error) {
+	v, err := parseEnum("plot metric", plotMetricNames, s)
+	return PlotMetric(v), err
+}
+
+// PlotGroupBy is the dimension by which events are split into series (see
+// PlotConfig).
+type PlotGroupBy int
+
+const (
+	// GroupByNone produces a single series, named "all".
+	GroupByNone PlotGroupBy = iota
+	// GroupByLevel produces a series per level (see LevelName).
+	GroupByLevel
+	// GroupByReason produces a series per reason (see ReasonName).
+	GroupByReason
+	// GroupByBlockType produces a series per block type (see BlockTypeName).
+	GroupByBlockType
+	// GroupByOp produces a series per op type (see OpName).
+	GroupByOp
+	// GroupByFile produces a series per file (see PlotConfig.MaxSeries).
+	GroupByFile
+)
+
+var plotGroupByNames = []string{
+	GroupByNone:      "none",
+	GroupByLevel:     "level",
+	GroupByReason:    "reason",
+	GroupByBlockType: "block-type",
+	GroupByOp:        "op",
+	GroupByFile:      "file",
+}
+
+func (g PlotGroupBy) String() string {
+	return enumName(plotGroupByNames, uint8(g))
+}
+
+// ParsePlotGroupBy parses a group-by dimension name (e.g. "level").
+func ParsePlotGroupBy(s string) (PlotGroupBy, error) {
+	v, err := parseEnum("group-by dimension", plotGroupByNames, s)
+	return PlotGroupBy(v), err
+}
+
+// PlotConfig describes the time series produced by a Plotter.
+type PlotConfig struct {
+	Metric  PlotMetric
+	GroupBy PlotGroupBy
+	// Ops restricts the events to the given op types; empty means all ops.
+	Ops []objiotracing.OpType
+	// MaxSeries is the maximum number of series; the series with the most bytes
+	// are kept, and the rest are merged into a series named "other". 0 means
+	// defaultPlotMaxSeries.
+	MaxSeries int
+	// IOPS adds a series with the operations per second for each group (unless
+	// that is already the metric).
+	IOPS bool
+	// SizePercentiles adds a series for each percentile (e.g. 0.99) of the
+	// operation sizes in each tick, for each group.
+	//
+	// Latency percentiles would be computed the same way, but the trace events
+	// don't record the duration of the operations.
+	SizePercentiles []float64
+}
+
+const defaultPlotMaxSeries = 20
+
+// PlotSeries is a named time series, aligned with the time axis returned by
+// Plotter.Finish.
+type PlotSeries struct {
+	Name string `json:"name"`
+	// Unit is the unit of the values: "MB/s", "ops/s" or "bytes".
+	Unit   string    `json:"unit"`
+	Values []float64 `json:"values"`
+}
+
+// Plotter aggregates the events of a trace into time series, according to a
+// PlotConfig.
+type Plotter struct {
+	axis   TimeAxis
+	config PlotConfig
+	series map[plotKey]*plotSeries
+}
+
+// plotKey identifies a series; its meaning depends on PlotConfig.GroupBy.
+type plotKey struct {
+	source uint32
+	value  uint64
+}
+
+type plotSeries struct {
+	// ops and bytes contain the number of operations and bytes in each tick.
+	ops   []float64
+	bytes []float64
+	// sizes contains the operation sizes in each tick; only used if
+	// PlotConfig.SizePercentiles is set.
+	sizes      []QuantileSketch
+	totalBytes int64
+}
+
+// NewPlotter creates a Plotter. The series are aligned with the given axis;
+// they are extended past axis.NumTicks if there are later events.
+func NewPlotter(axis TimeAxis, config PlotConfig) *Plotter {
+	if config.MaxSeries == 0 {
+		config.MaxSeries = defaultPlotMaxSeries
+	}
+	return &Plotter{
+		axis:   axis,
+		config: config,
+		series: make(map[plotKey]*plotSeries),
+	}
+}
+
+// Add adds a batch of events.
+func (p *Plotter) Add(events []objiotracing.Event) {
+	for i := range events {
+		e := &events[i]
+		if len(p.config.Ops) > 0 && !contains(p.config.Ops, e.Op) {
+			continue
+		}
+		tick := p.axis.Tick(e.StartUnixNano)
+		k := p.key(e)
+		s, ok := p.series[k]
+		if !ok {
+			s = &plotSeries{
+				ops:   make([]float64, p.axis.NumTicks),
+				bytes: make([]float64, p.axis.NumTicks),
+			}
+			if len(p.config.SizePercentiles) > 0 {
+				s.sizes = make([]QuantileSketch, p.axis.NumTicks)
+			}
+			p.series[k] = s
+		}
+		s.grow(tick+1, len(p.config.SizePercentiles) > 0)
+		s.ops[tick]++
+		s.bytes[tick] += float64(e.Size)
+		s.totalBytes += e.Size
+		if len(p.config.SizePercentiles) > 0 {
+			s.sizes[tick].Add(e.Size)
+		}
+	}
+}
+
+// grow makes sure the series has at least numTicks ticks.
+func (s *plotSeries) grow(numTicks int, withSizes bool) {
+	for len(s.ops) < numTicks {
+		s.ops = append(s.ops, 0)
+		s.bytes = append(s.bytes, 0)
+	}
+	for withSizes && len(s.sizes) < numTicks {
+		s.sizes = append(s.sizes, QuantileSketch{})
+	}
+}
+
+func (p *Plotter) key(e *objiotracing.Event) plotKey {
+	switch p.config.GroupBy {
+	case GroupByLevel:
+		return plotKey{value: uint64(e.LevelPlusOne)}
+	case GroupByReason:
+		return plotKey{value: uint64(e.Reason)}
+	case GroupByBlockType:
+		return plotKey{value: uint64(e.BlockType)}
+	case GroupByOp:
+		return plotKey{value: uint64(e.Op)}
+	case GroupByFile:
+		return plotKey{source: EventSource(e), value: uint64(e.FileNum)}
+	default:
+		return plotKey{}
+	}
+}
+
+func (p *Plotter) name(k plotKey) string {
+	switch p.config.GroupBy {
+	case GroupByLevel:
+		return LevelName(uint8(k.value))
+	case GroupByReason:
+		return ReasonName(objiotracing.Reason(k.value))
+	case GroupByBlockType:
+		return BlockTypeName(objiotracing.BlockType(k.value))
+	case GroupByOp:
+		return OpName(objiotracing.OpType(k.value))
+	case GroupByFile:
+		return fileName(k.source, k.value)
+	default:
+		return "all"
+	}
+}
+
+// Finish returns the time axis (the start of each tick, in Unix seconds; see
+// TimeAxis.UnixSecs) and the series, ordered by their key (e.g. by level).
+func (p *Plotter) Finish() (timeAxisUnixSecs []float64, series []PlotSeries) {
+	keys := make([]plotKey, 0, len(p.series))
+	numTicks := p.axis.NumTicks
+	for k, s := range p.series {
+		keys = append(keys, k)
+		if len(s.ops) > numTicks {
+			numTicks = len(s.ops)
+		}
+	}
+	timeAxisUnixSecs = p.axis.UnixSecs(numTicks)
+
+	// Keep the series with the most bytes and merge the rest.
+	sort.Slice(keys, func(i, j int) bool {
+		return p.series[keys[i]].totalBytes > p.series[keys[j]].totalBytes
+	})
+	var other *plotSeries
+	if len(keys) > p.config.MaxSeries {
+		other = &plotSeries{}
+		for _, k := range keys[p.config.MaxSeries-1:] {
+			other.add(p.series[k])
+		}
+		keys = keys[:p.config.MaxSeries-1]
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].source != keys[j].source {
+			return keys[i].source < keys[j].source
+		}
+		return keys[i].value < keys[j].value
+	})
+
+	for _, k := range keys {
+		series = append(series, p.finishSeries(p.name(k), p.series[k], numTicks)...)
+	}
+	if other != nil {
+		series = append(series, p.finishSeries("other", other, numTicks)...)
+	}
+	return timeAxisUnixSecs, series
+}
+
+// add adds the values of another series.
+func (s *plotSeries) add(o *plotSeries) {
+	s.grow(len(o.ops), o.sizes != nil)
+	for i := range o.ops {
+		s.ops[i] += o.ops[i]
+		s.bytes[i] += o.bytes[i]
+	}
+	for i := range o.sizes {
+		s.sizes[i].Merge(&o.sizes[i])
+	}
+	s.totalBytes += o.totalBytes
+}
+
+// finishSeries returns the series for a group: the metric, followed by the
+// IOPS and size percentile series (if requested).
+func (p *Plotter) finishSeries(name string, s *plotSeries, numTicks int) []PlotSeries {
+	metric := PlotSeries{
+		Name:   name,
+		Unit:   enumName(plotMetricUnits, uint8(p.config.Metric)),
+		Values: make([]float64, numTicks),
+	}
+	tickSecs := float64(p.axis.TickNanos) / float64(time.Second)
+	for i := range s.ops {
+		switch p.config.Metric {
+		case PlotMBPS:
+			metric.Values[i] = s.bytes[i] / (1024 * 1024) / tickSecs
+		case PlotOpsPerSec:
+			metric.Values[i] = s.ops[i] / tickSecs
+		case PlotMeanSize:
+			if s.ops[i] > 0 {
+				metric.Values[i] = s.bytes[i] / s.ops[i]
+			}
+		}
+	}
+	res := []PlotSeries{metric}
+	if p.config.IOPS && p.config.Metric != PlotOpsPerSec {
+		iops := PlotSeries{
+			Name:   name + " iops",
+			Unit:   plotMetricUnits[PlotOpsPerSec],
+			Values: make([]float64, numTicks),
+		}
+		for i := range s.ops {
+			iops.Values[i] = s.ops[i] / tickSecs
+		}
+		res = append(res, iops)
+	}
+	for _, q := range p.config.SizePercentiles {
+		sizes := PlotSeries{
+			Name:   fmt.Sprintf("%s size p%g", name, q*100),
+			Unit:   "bytes",
+			Values: make([]float64, numTicks),
+		}
+		for i := range s.sizes {
+			sizes.Values[i] = s.sizes[i].Quantile(q)
+		}
+		res = append(res, sizes)
+	}
+	return res
+}
@@ -0,0 +1,92 @@
+package lib
+
+import (
+	"testing"
+	"time"
+
+	"github.com/cockroachdb/pebble/objstorage/objstorageprovider/objiotracing"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPlotter(t *testing.T) {
+	event := func(secs int64, op objiotracing.OpType, levelPlusOne uint8, fileNum uint64, size int64) objiotracing.Event {
+		e := objiotracing.Event{
+			StartUnixNano: (1000+secs)*int64(time.Second) + 1,
+			Op:            op,
+			LevelPlusOne:  levelPlusOne,
+			Size:          size,
+		}
+		setFileNum(&e.FileNum, fileNum)
+		return e
+	}
+	events := []objiotracing.Event{
+		event(0, objiotracing.ReadOp, 7, 1, 1<<20),
+		event(1, objiotracing.ReadOp, 1, 2, 2<<20),
+		event(3, objiotracing.WriteOp, 7, 3, 4<<20),
+		event(5, objiotracing.RecordCacheHitOp, 7, 1, 1<<20),
+		// Past the end of the axis.
+		event(6, objiotracing.ReadOp, 7, 1, 1<<20),
+	}
+	axis := TimeAxis{StartUnixNano: 1000 * int64(time.Second), TickNanos: 2 * int64(time.Second), NumTicks: 3}
+	plot := func(config PlotConfig) ([]float64, []PlotSeries) {
+		p := NewPlotter(axis, config)
+		p.Add(events[:2])
+		p.Add(events[2:])
+		return p.Finish()
+	}
+
+	timeAxis, series := plot(PlotConfig{})
+	require.Equal(t, []float64{1000, 1002, 1004, 1006}, timeAxis)
+	require.Equal(t, []PlotSeries{
+		{Name: "all", Unit: "MB/s", Values: []float64{1.5, 2, 0.5, 0.5}},
+	}, series)
+
+	_, series = plot(PlotConfig{
+		Metric:  PlotOpsPerSec,
+		GroupBy: GroupByLevel,
+		Ops:     []objiotracing.OpType{objiotracing.ReadOp},
+	})
+	require.Equal(t, []PlotSeries{
+		{Name: "L0", Unit: "ops/s", Values: []float64{0.5, 0, 0, 0}},
+		{Name: "L6", Unit: "ops/s", Values: []float64{0.5, 0, 0, 0.5}},
+	}, series)
+
+	_, series = plot(PlotConfig{Metric: PlotMeanSize, GroupBy: GroupByOp})
+	require.Equal(t, []PlotSeries{
+		{Name: "read", Unit: "bytes", Values: []float64{1.5 * (1 << 20), 0, 0, 1 << 20}},
+		{Name: "write", Unit: "bytes", Values: []float64{0, 4 << 20, 0, 0}},
+		{Name: "cache-hit", Unit: "bytes", Values: []float64{0, 0, 1 << 20, 0}},
+	}, series)
+
+	// Only the file with the most bytes gets its own series.
+	_, series = plot(PlotConfig{GroupBy: GroupByFile, MaxSeries: 2})
+	require.Equal(t, []PlotSeries{
+		{Name: "000003", Unit: "MB/s", Values: []float64{0, 2, 0, 0}},
+		{Name: "other", Unit: "MB/s", Values: []float64{1.5, 0, 0.5, 0.5}},
+	}, series)
+
+	// Per-group IOPS and size percentiles. Sizes are estimated by the midpoint
+	// of their sketch bucket.
+	const mid1MB = 1<<20 + 1<<15 - 0.5
+	const mid2MB = 2<<20 + 1<<16 - 0.5
+	_, series = plot(PlotConfig{
+		GroupBy:         GroupByReason,
+		Ops:             []objiotracing.OpType{objiotracing.ReadOp},
+		IOPS:            true,
+		SizePercentiles: []float64{0.5, 1},
+	})
+	require.Equal(t, []PlotSeries{
+		{Name: "unknown", Unit: "MB/s", Values: []float64{1.5, 0, 0, 0.5}},
+		{Name: "unknown iops", Unit: "ops/s", Values: []float64{1, 0, 0, 0.5}},
+		{Name: "unknown size p50", Unit: "bytes", Values: []float64{mid1MB, 0, 0, mid1MB}},
+		{Name: "unknown size p100", Unit: "bytes", Values: []float64{mid2MB, 0, 0, mid1MB}},
+	}, series)
+
+	// Sub-second ticks.
+	axis = TimeAxis{StartUnixNano: 1001 * int64(time.Second), TickNanos: int64(500 * time.Millisecond), NumTicks: 2}
+	timeAxis, series = plot(PlotConfig{Metric: PlotOpsPerSec})
+	require.Equal(t, []float64{1001, 1001.5, 1002, 1002.5, 1003, 1003.5, 1004, 1004.5, 1005, 1005.5, 1006}, timeAxis)
+	require.Equal(t, []PlotSeries{
+		{Name: "all", Unit: "ops/s", Values: []float64{4, 0, 0, 0, 2, 0, 0, 0, 2, 0, 2}},
+	}, series)
+}
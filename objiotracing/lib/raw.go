@@ -0,0 +1,335 @@
+package lib
+
+import (
+	"bufio"
+	"container/heap"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/cockroachdb/pebble/objstorage/objstorageprovider/objiotracing"
+)
+
+// A raw trace is a directory containing the IOTRACES- files written by Pebble's
+// objiotracing.Tracer, either directly (single source) or in one subdirectory
+// per node/store, e.g.:
+//
+//	run1/n1/IOTRACES-2023-03-29T17:12:30.123Z
+//	run1/n1/IOTRACES-2023-03-29T17:14:03.456Z
+//	run1/n2/IOTRACES-2023-03-29T17:12:31.789Z
+//
+// A directory is only considered a multi-source trace if it contains nothing
+// but (at least two) subdirectories which contain nothing but IOTRACES- files
+// (and MANIFEST and OPTIONS files, see DirStore.FileTable); otherwise, e.g. for cluster1/run1/IOTRACES-*, the trace is the deepest
+// directory which directly contains the files (cluster1/run1).
+//
+// Raw traces can be used directly (without running addtrace) and promoted into
+// the trace library with PromoteRawTrace if they turn out to be interesting.
+
+const rawTraceFilePrefix = "IOTRACES-"
+
+// rawReorderWindow is the number of events we read ahead in each source when
+// merging a raw trace. The Tracer buffers events per read handle/writable, so
+// events in a file are not ordered by time; this window needs to be large
+// enough to cover the reordering.
+const rawReorderWindow = 64 * 1024
+
+type rawSource struct {
+	// name is the subdirectory name; empty if the files are directly in the
+	// trace directory.
+	name string
+	// files contains the paths of all trace files of this source, in order of
+	// creation.
+	files []string
+}
+
+// rawTraceSources returns the sources of a raw trace, or nil if the directory
+// is not a raw trace (or is a folder containing raw traces, see above).
+func rawTraceSources(dir string) ([]rawSource, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var files []string
+	var subdirs []string
+	otherFiles := false
+	for _, e := range entries {
+		switch {
+		case strings.HasPrefix(e.Name(), "."):
+		case e.IsDir():
+			subdirs = append(subdirs, e.Name())
+		case strings.HasPrefix(e.Name(), rawTraceFilePrefix):
+			files = append(files, filepath.Join(dir, e.Name()))
+		default:
+			otherFiles = true
+		}
+	}
+	if len(files) > 0 {
+		// The file names contain the creation time, so sorting them sorts them
+		// by time.
+		sort.Strings(files)
+		return []rawSource{{files: files}}, nil
+	}
+	if otherFiles || len(subdirs) < 2 {
+		return nil, nil
+	}
+	var sources []rawSource
+	for _, subdir := range subdirs {
+		entries, err := os.ReadDir(filepath.Join(dir, subdir))
+		if err != nil {
+			return nil, err
+		}
+		s := rawSource{name: subdir}
+		for _, e := range entries {
+			switch {
+			case strings.HasPrefix(e.Name(), "."):
+			case !e.IsDir() && strings.HasPrefix(e.Name(), rawTraceFilePrefix):
+				s.files = append(s.files, filepath.Join(dir, subdir, e.Name()))
+			case !e.IsDir() && isManifestFile(e.Name()):
+			default:
+				// This is a folder of traces, not a node directory.
+				return nil, nil
+			}
+		}
+		if len(s.files) == 0 {
+			return nil, nil
+		}
+		sort.Strings(s.files)
+		sources = append(sources, s)
+	}
+	return sources, nil
+}
+
+// isRawTrace returns true if the directory is a raw trace.
+func isRawTrace(dir string) bool {
+	sources, err := rawTraceSources(dir)
+	return err == nil && len(sources) > 0
+}
+
+// rawMetadata returns the metadata for a raw trace. The number of events is
+// exact, but (to avoid reading all the files) the start time and duration are
+// estimated from the events at the beginning and end of each file.
+func rawMetadata(name string, sources []rawSource) (TraceMetadata, error) {
+	md := TraceMetadata{Name: name, Raw: true}
+	var b metadataBuilder
+	numEvents := 0
+	buf := make([]objiotracing.Event, rawReorderWindow/16)
+	for _, s := range sources {
+		if s.name != "" {
+			md.Sources = append(md.Sources, s.name)
+		}
+		for _, filename := range s.files {
+			f, err := os.Open(filename)
+			if err != nil {
+				return TraceMetadata{}, err
+			}
+			info, err := f.Stat()
+			if err != nil {
+				f.Close()
+				return TraceMetadata{}, err
+			}
+			n := int(info.Size()) / eventSize
+			numEvents += n
+			// Read events from the beginning and the end of the file.
+			for _, pos := range []int{0, n - len(buf)} {
+				if pos < 0 {
+					pos = 0
+				}
+				k, err := f.ReadAt(EventsAsBytes(buf), int64(pos*eventSize))
+				if err != nil && err != io.EOF {
+					f.Close()
+					return TraceMetadata{}, err
+				}
+				b.add(buf[:k/eventSize])
+			}
+			f.Close()
+		}
+	}
+	b.finish(&md)
+	md.NumEvents = numEvents
+	return md, nil
+}
+
+// OpenRawTrace opens a raw trace directory (see rawTraceFilePrefix). Events
+// from all sources are merged by time; the source ID of each event is set
+// according to the subdirectory it came from.
+func OpenRawTrace(dir string, name string) (TraceMetadata, Iterator, error) {
+	sources, err := rawTraceSources(dir)
+	if err != nil {
+		return TraceMetadata{}, nil, err
+	}
+	if len(sources) == 0 {
+		return TraceMetadata{}, nil, os.ErrNotExist
+	}
+	md, err := rawMetadata(name, sources)
+	if err != nil {
+		return TraceMetadata{}, nil, err
+	}
+	it := &rawIterator{
+		batch: make([]objiotracing.Event, 0, 1024),
+	}
+	for i, s := range sources {
+		var sourceID uint32
+		if s.name != "" {
+			sourceID = uint32(i + 1)
+		}
+		it.streams = append(it.streams, &rawStream{
+			files:    s.files,
+			sourceID: sourceID,
+		})
+	}
+	for _, s := range it.streams {
+		if err := it.fill(s); err != nil {
+			it.Close()
+			return TraceMetadata{}, nil, err
+		}
+	}
+	heap.Init(&it.heap)
+	return md, it, nil
+}
+
+// rawStream reads the events of one source, one file after another.
+type rawStream struct {
+	files    []string
+	sourceID uint32
+	file     *os.File
+	reader   *bufio.Reader
+	// buffered is the number of events from this stream that are in the heap.
+	buffered int
+	done     bool
+	buf      [1]objiotracing.Event
+}
+
+// next returns the next event in the stream, or false if there are no more
+// events.
+func (s *rawStream) next() (objiotracing.Event, bool, error) {
+	for !s.done {
+		if s.reader == nil {
+			if len(s.files) == 0 {
+				s.done = true
+				break
+			}
+			f, err := os.Open(s.files[0])
+			if err != nil {
+				return objiotracing.Event{}, false, err
+			}
+			s.files = s.files[1:]
+			s.file = f
+			s.reader = bufio.NewReaderSize(f, 256*eventSize)
+		}
+		_, err := io.ReadFull(s.reader, EventsAsBytes(s.buf[:]))
+		if err == nil {
+			e := s.buf[0]
+			SetEventSource(&e, s.sourceID)
+			return e, true, nil
+		}
+		if err != io.EOF && err != io.ErrUnexpectedEOF {
+			return objiotracing.Event{}, false, err
+		}
+		// A partial event at the end of a file can happen if the file was
+		// copied while being written; we ignore it.
+		s.close()
+	}
+	return objiotracing.Event{}, false, nil
+}
+
+func (s *rawStream) close() {
+	if s.file != nil {
+		s.file.Close()
+		s.file = nil
+		s.reader = nil
+	}
+}
+
+// rawIterator merges the streams of a raw trace using a heap. Each stream
+// keeps rawReorderWindow events in the heap, which reorders events within each
+// stream as well as across streams.
+type rawIterator struct {
+	streams []*rawStream
+	heap    rawHeap
+	batch   []objiotracing.Event
+}
+
+var _ Iterator = (*rawIterator)(nil)
+
+type rawHeapItem struct {
+	e      objiotracing.Event
+	stream *rawStream
+}
+
+type rawHeap []rawHeapItem
+
+func (h rawHeap) Len() int           { return len(h) }
+func (h rawHeap) Less(i, j int) bool { return h[i].e.StartUnixNano < h[j].e.StartUnixNano }
+func (h rawHeap) Swap(i, j int)      { h[i], h[j] = h[j], h[i] }
+func (h *rawHeap) Push(x any)        { *h = append(*h, x.(rawHeapItem)) }
+func (h *rawHeap) Pop() any {
+	old := *h
+	n := len(old)
+	x := old[n-1]
+	*h = old[:n-1]
+	return x
+}
+
+// fill reads events from a stream until it has rawReorderWindow events in the
+// heap (or it is exhausted). It does not maintain the heap invariant.
+func (it *rawIterator) fill(s *rawStream) error {
+	for s.buffered < rawReorderWindow {
+		e, ok, err := s.next()
+		if err != nil {
+			return err
+		}
+		if !ok {
+			break
+		}
+		it.heap = append(it.heap, rawHeapItem{e: e, stream: s})
+		s.buffered++
+	}
+	return nil
+}
+
+// NextBatch is part of the Iterator interface.
+func (it *rawIterator) NextBatch() ([]objiotracing.Event, error) {
+	it.batch = it.batch[:0]
+	for len(it.batch) < cap(it.batch) && len(it.heap) > 0 {
+		item := heap.Pop(&it.heap).(rawHeapItem)
+		it.batch = append(it.batch, item.e)
+		s := item.stream
+		s.buffered--
+		if !s.done {
+			e, ok, err := s.next()
+			if err != nil {
+				return nil, err
+			}
+			if ok {
+				heap.Push(&it.heap, rawHeapItem{e: e, stream: s})
+				s.buffered++
+			}
+		}
+	}
+	if len(it.batch) == 0 {
+		return nil, nil
+	}
+	return it.batch, nil
+}
+
+// Close is part of the Iterator interface.
+func (it *rawIterator) Close() {
+	for _, s := range it.streams {
+		s.close()
+	}
+	*it = rawIterator{}
+}
+
+// PromoteRawTrace adds a copy of a trace (typically a raw trace) to the store
+// under a new name.
+func PromoteRawTrace(s TraceStore, trace string, newName string) error {
+	md, it, err := s.Open(trace)
+	if err != nil {
+		return err
+	}
+	md.Name = newName
+	return s.Add(md, it)
+}
@@ -0,0 +1,215 @@
+package lib
+
+import (
+	"fmt"
+
+	"github.com/cockroachdb/pebble/objstorage/objstorageprovider/objiotracing"
+)
+
+// ReadaheadMode determines how the simulated cache prefetches blocks that
+// follow a missed block (see Config.Readahead).
+type ReadaheadMode int
+
+const (
+	// ReadaheadNone only fetches the missed blocks.
+	ReadaheadNone ReadaheadMode = iota
+	// ReadaheadFixed fetches Config.ReadaheadSize bytes on each miss in a
+	// sequential run.
+	ReadaheadFixed
+	// ReadaheadAdaptive is like ReadaheadFixed, but the readahead size starts at
+	// a quarter of Config.ReadaheadSize and doubles with each fetch in the same
+	// run (like Pebble's own readahead).
+	ReadaheadAdaptive
+	// ReadaheadWholeFile fetches entire files which are at most
+	// Config.ReadaheadSize, on the first miss (without waiting for a sequential
+	// run); larger files use ReadaheadFixed. File sizes are known from the
+	// sstable metadata (see Simulate) or from writes in the trace; files
+	// of unknown size are treated as large.
+	ReadaheadWholeFile
+)
+
+var readaheadModeNames = []string{
+	ReadaheadNone:      "none",
+	ReadaheadFixed:     "fixed",
+	ReadaheadAdaptive:  "adaptive",
+	ReadaheadWholeFile: "whole-file",
+}
+
+func (m ReadaheadMode) String() string {
+	return enumName(readaheadModeNames, uint8(m))
+}
+
+// ParseReadaheadMode parses a readahead mode name (e.g. "adaptive").
+func ParseReadaheadMode(s string) (ReadaheadMode, error) {
+	v, err := parseEnum("readahead mode", readaheadModeNames, s)
+	return ReadaheadMode(v), err
+}
+
+const (
+	// defaultReadaheadSize is used when Config.ReadaheadSize is 0.
+	defaultReadaheadSize = 256 * 1024
+	// readaheadTrigger is the number of sequential reads after which readahead
+	// starts.
+	readaheadTrigger = 2
+)
+
+// readaheadStream tracks reads of a file for a given reason (compaction reads
+// and user reads of the same file are interleaved, but are separate streams).
+type readaheadStream struct {
+	// lastOffset and lastEnd describe the previous read.
+	lastOffset, lastEnd int64
+	// sequential is the number of consecutive sequential reads.
+	sequential int
+	// size is the current readahead size (for ReadaheadAdaptive).
+	size int64
+}
+
+type readaheadStreamID struct {
+	file   fileID
+	reason objiotracing.Reason
+}
+
+// readaheadModel detects sequential runs and prefetches blocks into the cache
+// on misses, according to Config.Readahead.
+type readaheadModel struct {
+	config  *Config
+	files   *FileTable
+	results *Results
+	maxSize int64
+	streams map[readaheadStreamID]*readaheadStream
+	// fileSizes contains the sizes of the files written in the trace.
+	fileSizes map[fileID]int64
+	// prefetched contains the prefetched blocks which were not accessed yet.
+	prefetched map[blockID]struct{}
+}
+
+func newReadaheadModel(config *Config, files *FileTable, results *Results) *readaheadModel {
+	if config.BlockSize == 0 {
+		panic("readahead requires BlockSize")
+	}
+	if !config.Policy.SupportsInvalidation() {
+		panic(fmt.Sprintf("%s does not support readahead", config.Policy))
+	}
+	maxSize := config.ReadaheadSize
+	if maxSize == 0 {
+		maxSize = defaultReadaheadSize
+	}
+	return &readaheadModel{
+		config:     config,
+		files:      files,
+		results:    results,
+		maxSize:    maxSize,
+		streams:    make(map[readaheadStreamID]*readaheadStream),
+		fileSizes:  make(map[fileID]int64),
+		prefetched: make(map[blockID]struct{}),
+	}
+}
+
+// observe is called for each event, to detect sequential runs and the sizes of
+// written files.
+func (m *readaheadModel) observe(e *objiotracing.Event) {
+	f := eventFile(e)
+	switch e.Op {
+	case objiotracing.WriteOp:
+		if end := e.Offset + e.Size; end > m.fileSizes[f] {
+			m.fileSizes[f] = end
+		}
+	case objiotracing.ReadOp, objiotracing.RecordCacheHitOp:
+		id := readaheadStreamID{file: f, reason: e.Reason}
+		s, ok := m.streams[id]
+		if !ok {
+			s = &readaheadStream{}
+			m.streams[id] = s
+		}
+		// Allow small gaps (e.g. skipped blocks) between sequential reads.
+		if ok && e.Offset >= s.lastOffset && e.Offset <= s.lastEnd+m.config.BlockSize {
+			s.sequential++
+		} else {
+			s.sequential = 0
+			s.size = 0
+		}
+		s.lastOffset = e.Offset
+		s.lastEnd = e.Offset + e.Size
+	}
+}
+
+// fileSize returns the size of a file, or 0 if it is unknown.
+func (m *readaheadModel) fileSize(f fileID) int64 {
+	if m.files != nil {
+		if md := m.files.Lookup(f.source, f.fileNum); md != nil {
+			return int64(md.Size)
+		}
+	}
+	return m.fileSizes[f]
+}
+
+// hit is called when a read access hits the cache.
+func (m *readaheadModel) hit(a *access) {
+	if _, ok := m.prefetched[a.id]; ok {
+		delete(m.prefetched, a.id)
+		m.results.PrefetchHits++
+	}
+}
+
+// miss is called when a read access misses the cache and the missed block was
+// inserted. It inserts the blocks to be prefetched, if any, and returns the
+// byte range fetched from the object store (including the missed block).
+func (m *readaheadModel) miss(
+	c cache, a *access, insert func(c cache, a *access),
+) (offset, length int64) {
+	if _, ok := m.prefetched[a.id]; ok {
+		// The block was prefetched but evicted before it was used.
+		delete(m.prefetched, a.id)
+	}
+	f := fileID{source: a.id.source, fileNum: a.id.fileNum}
+	fileSize := m.fileSize(f)
+	s := m.streams[readaheadStreamID{file: f, reason: a.event.Reason}]
+
+	start := a.id.block
+	var readahead int64
+	switch {
+	case m.config.Readahead == ReadaheadWholeFile && fileSize != 0 && fileSize <= m.maxSize:
+		// Fetch the entire file.
+		start = 0
+		readahead = fileSize
+	case s == nil || s.sequential < readaheadTrigger:
+	case m.config.Readahead == ReadaheadAdaptive:
+		if s.size == 0 {
+			s.size = max64(m.maxSize/4, m.config.BlockSize)
+		} else {
+			s.size = min64(s.size*2, m.maxSize)
+		}
+		readahead = s.size
+	default:
+		readahead = m.maxSize
+	}
+
+	blockSize := m.config.BlockSize
+	numBlocks := (readahead + blockSize - 1) / blockSize
+	if fileSize != 0 {
+		numBlocks = min64(numBlocks, (fileSize+blockSize-1)/blockSize-start)
+	}
+	fetched := a.size
+	ic := c.(invalidatingCache)
+	for i := int64(0); i < numBlocks; i++ {
+		p := *a
+		p.id.block = start + i
+		if p.id.block == a.id.block {
+			continue
+		}
+		fetched += blockSize
+		if _, ok := ic.peek(p.id.key()); ok {
+			continue
+		}
+		p.size = blockSize
+		insert(c, &p)
+		m.prefetched[p.id] = struct{}{}
+		m.results.PrefetchedBlocks++
+	}
+	return min64(start, a.id.block) * blockSize, fetched
+}
+
+// finish is called at the end of the simulation.
+func (m *readaheadModel) finish() {
+	m.results.WastedPrefetchBytes = int64(m.results.PrefetchedBlocks-m.results.PrefetchHits) * m.config.BlockSize
+}
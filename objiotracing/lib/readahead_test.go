@@ -0,0 +1,74 @@
+package lib
+
+import (
+	"testing"
+
+	"github.com/cockroachdb/pebble/objstorage/objstorageprovider/objiotracing"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReadahead(t *testing.T) {
+	const blockSize = 4096
+	// A 64KB file is written and then read sequentially, one block at a time.
+	write := objiotracing.Event{Op: objiotracing.WriteOp, Size: 16 * blockSize}
+	setFileNum(&write.FileNum, 1)
+	trace := []objiotracing.Event{write}
+	for i := 0; i < 16; i++ {
+		e := objiotracing.Event{
+			StartUnixNano: int64(i + 1),
+			Op:            objiotracing.ReadOp,
+			Offset:        int64(i) * blockSize,
+			Size:          blockSize,
+		}
+		setFileNum(&e.FileNum, 1)
+		trace = append(trace, e)
+	}
+
+	testCases := []struct {
+		mode          ReadaheadMode
+		size          int64
+		hits          int
+		prefetched    int
+		objStoreBytes int64
+	}{
+		{mode: ReadaheadNone, hits: 0, prefetched: 0, objStoreBytes: 16 * blockSize},
+		// Readahead starts at the third read; each fetch reads 4 blocks.
+		{mode: ReadaheadFixed, size: 4 * blockSize, hits: 10, prefetched: 10, objStoreBytes: 16 * blockSize},
+		// The fetches at blocks 2, 4 and 8 read 2, 4 and 8 blocks.
+		{mode: ReadaheadAdaptive, size: 8 * blockSize, hits: 11, prefetched: 11, objStoreBytes: 16 * blockSize},
+		// The file is read in full on the first miss.
+		{mode: ReadaheadWholeFile, size: 16 * blockSize, hits: 15, prefetched: 15, objStoreBytes: 16 * blockSize},
+		// The file is too large to be read in full, so fixed readahead is used.
+		{mode: ReadaheadWholeFile, size: 4 * blockSize, hits: 10, prefetched: 10, objStoreBytes: 16 * blockSize},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.mode.String(), func(t *testing.T) {
+			config := Config{
+				Policy:        LRU,
+				BlockSize:     blockSize,
+				CacheSize:     100,
+				Readahead:     tc.mode,
+				ReadaheadSize: tc.size,
+			}
+			results, err := Simulate(t.Name(), &wrappedTrace{inner: trace}, config, nil /* files */)
+			require.NoError(t, err)
+			require.Equal(t, tc.hits, results.Hits)
+			require.Equal(t, 16-tc.hits, results.Misses)
+			require.Equal(t, 16-tc.hits, results.ObjectStoreRequests)
+			require.Equal(t, tc.objStoreBytes, results.ObjectStoreBytes)
+			require.Equal(t, tc.prefetched, results.PrefetchedBlocks)
+			require.Equal(t, tc.prefetched, results.PrefetchHits)
+			require.Equal(t, int64(0), results.WastedPrefetchBytes)
+		})
+	}
+
+	// Without the write, the file size is unknown and the last fetch reads past
+	// the end of the file.
+	config := Config{Policy: LRU, BlockSize: blockSize, CacheSize: 100, Readahead: ReadaheadFixed, ReadaheadSize: 4 * blockSize}
+	results, err := Simulate(t.Name(), &wrappedTrace{inner: trace[1:]}, config, nil /* files */)
+	require.NoError(t, err)
+	require.Equal(t, 10, results.Hits)
+	require.Equal(t, 12, results.PrefetchedBlocks)
+	require.Equal(t, int64(2*blockSize), results.WastedPrefetchBytes)
+	require.InDelta(t, 10.0/12, results.PrefetchAccuracy(), 1e-9)
+}
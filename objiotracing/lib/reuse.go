@@ -0,0 +1,169 @@
+package lib
+
+import (
+	"sort"
+
+	"github.com/cockroachdb/pebble/objstorage/objstorageprovider/objiotracing"
+)
+
+// ReuseHistograms describes the reuse of blocks by a set of accesses.
+type ReuseHistograms struct {
+	// Distance is the distribution of reuse distances: the number of unique
+	// blocks accessed since the previous access to the same block. An LRU cache
+	// of N blocks hits exactly the accesses with reuse distance below N.
+	Distance Log2Histogram
+	// TimeMicros is the distribution of reuse times: the time since the
+	// previous access to the same block, in microseconds.
+	TimeMicros Log2Histogram
+	// Cold is the number of first accesses to a block (which have no reuse
+	// distance).
+	Cold int64
+}
+
+// ReuseAnalysis contains reuse histograms for all read accesses of a trace,
+// and split by the level, reason and block type of the access (the keys are
+// the names returned by LevelName, ReasonName and BlockTypeName).
+type ReuseAnalysis struct {
+	All         ReuseHistograms
+	ByLevel     map[string]*ReuseHistograms
+	ByReason    map[string]*ReuseHistograms
+	ByBlockType map[string]*ReuseHistograms
+}
+
+// AnalyzeReuse calculates the reuse histograms of the reads in a trace
+// (including reads that hit Pebble's block cache). Accesses are tracked at the
+// granularity of blocks of the given size (or by offset, if blockSize is 0);
+// see Config.BlockSize.
+func AnalyzeReuse(it iterator, blockSize int64) (*ReuseAnalysis, error) {
+	r := &ReuseAnalysis{
+		ByLevel:     make(map[string]*ReuseHistograms),
+		ByReason:    make(map[string]*ReuseHistograms),
+		ByBlockType: make(map[string]*ReuseHistograms),
+	}
+	get := func(m map[string]*ReuseHistograms, key string) *ReuseHistograms {
+		h, ok := m[key]
+		if !ok {
+			h = &ReuseHistograms{}
+			m[key] = h
+		}
+		return h
+	}
+	config := Config{BlockSize: blockSize}
+	t := newReuseTracker()
+	for {
+		batch, err := it.NextBatch()
+		if err != nil {
+			return nil, err
+		}
+		if batch == nil {
+			return r, nil
+		}
+		for i := range batch {
+			e := &batch[i]
+			if e.Op != objiotracing.ReadOp && e.Op != objiotracing.RecordCacheHitOp {
+				continue
+			}
+			id := blockID{source: EventSource(e), fileNum: uint64(e.FileNum), block: config.block(e.Offset)}
+			distance, prevTime, ok := t.access(id, e.StartUnixNano)
+			for _, h := range []*ReuseHistograms{
+				&r.All,
+				get(r.ByLevel, LevelName(e.LevelPlusOne)),
+				get(r.ByReason, ReasonName(e.Reason)),
+				get(r.ByBlockType, BlockTypeName(e.BlockType)),
+			} {
+				if !ok {
+					h.Cold++
+					continue
+				}
+				h.Distance.Add(distance)
+				h.TimeMicros.Add((e.StartUnixNano - prevTime) / 1000)
+			}
+		}
+	}
+}
+
+// reuseTracker calculates reuse distances, using a Fenwick tree over the
+// positions of the accesses in which each position is marked if it is the
+// latest access to its block. The number of marked positions between the
+// previous access to a block and the current position is the reuse distance.
+//
+// To bound the memory usage, the positions are renumbered when the tree is
+// full, so that the tree size is proportional to the number of unique blocks.
+type reuseTracker struct {
+	tree   []int32
+	pos    int
+	blocks map[blockID]reuseEntry
+}
+
+type reuseEntry struct {
+	pos  int
+	time int64
+}
+
+// reuseTrackerMinSize is the minimum size of the tree; tests lower it.
+var reuseTrackerMinSize = 1 << 16
+
+func newReuseTracker() *reuseTracker {
+	return &reuseTracker{
+		tree:   make([]int32, reuseTrackerMinSize+1),
+		blocks: make(map[blockID]reuseEntry),
+	}
+}
+
+// add adds delta at the given position (0-based).
+func (t *reuseTracker) add(pos int, delta int32) {
+	for i := pos + 1; i < len(t.tree); i += i & -i {
+		t.tree[i] += delta
+	}
+}
+
+// sum returns the sum of the values at positions [0, pos).
+func (t *reuseTracker) sum(pos int) int64 {
+	var s int64
+	for i := pos; i > 0; i -= i & -i {
+		s += int64(t.tree[i])
+	}
+	return s
+}
+
+// access records an access and returns the reuse distance and the time of the
+// previous access; ok is false if this is the first access to the block.
+func (t *reuseTracker) access(id blockID, now int64) (distance int64, prevTime int64, ok bool) {
+	if t.pos == len(t.tree)-1 {
+		t.renumber()
+	}
+	prev, ok := t.blocks[id]
+	if ok {
+		distance = t.sum(t.pos) - t.sum(prev.pos+1)
+		t.add(prev.pos, -1)
+	}
+	t.add(t.pos, 1)
+	t.blocks[id] = reuseEntry{pos: t.pos, time: now}
+	t.pos++
+	return distance, prev.time, ok
+}
+
+// renumber assigns consecutive positions to the latest accesses of all blocks
+// (preserving their order) and rebuilds the tree, leaving room for at least as
+// many new accesses.
+func (t *reuseTracker) renumber() {
+	ids := make([]blockID, 0, len(t.blocks))
+	for id := range t.blocks {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool {
+		return t.blocks[ids[i]].pos < t.blocks[ids[j]].pos
+	})
+	size := 2 * len(ids)
+	if size < reuseTrackerMinSize {
+		size = reuseTrackerMinSize
+	}
+	t.tree = make([]int32, size+1)
+	for i, id := range ids {
+		e := t.blocks[id]
+		e.pos = i
+		t.blocks[id] = e
+		t.add(i, 1)
+	}
+	t.pos = len(ids)
+}
@@ -0,0 +1,63 @@
+package lib
+
+import (
+	"math/rand"
+	"testing"
+
+	"github.com/cockroachdb/pebble/objstorage/objstorageprovider/objiotracing"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAnalyzeReuse(t *testing.T) {
+	read := func(secs int64, levelPlusOne uint8, fileNum uint64) objiotracing.Event {
+		e := objiotracing.Event{StartUnixNano: secs * 1e9, Op: objiotracing.ReadOp, LevelPlusOne: levelPlusOne}
+		setFileNum(&e.FileNum, fileNum)
+		return e
+	}
+	// Accesses: A B C A B B (A and B are in L6, C in L0).
+	trace := []objiotracing.Event{
+		read(0, 7, 1), read(1, 7, 2), read(2, 1, 3), read(3, 7, 1), read(5, 7, 2), read(6, 7, 2),
+	}
+	r, err := AnalyzeReuse(&wrappedTrace{inner: trace}, 0)
+	require.NoError(t, err)
+	require.Equal(t, int64(3), r.All.Cold)
+	require.Equal(t, []HistogramBucket{
+		{Lo: 0, Hi: 1, Count: 1},
+		{Lo: 1, Hi: 2, Count: 0},
+		{Lo: 2, Hi: 4, Count: 2},
+	}, r.All.Distance.Buckets())
+	// Reuse times are 3s, 4s and 1s.
+	require.Equal(t, int64(3), r.All.TimeMicros.Count())
+	require.Equal(t, int64(1<<22), r.All.TimeMicros.Quantile(0.99))
+	require.Equal(t, int64(1), r.ByLevel["L0"].Cold)
+	require.Equal(t, int64(0), r.ByLevel["L0"].Distance.Count())
+	require.Equal(t, int64(3), r.ByLevel["L6"].Distance.Count())
+	require.Equal(t, int64(3), r.ByReason["unknown"].Distance.Count())
+}
+
+func TestReuseTracker(t *testing.T) {
+	defer func(old int) { reuseTrackerMinSize = old }(reuseTrackerMinSize)
+	reuseTrackerMinSize = 16
+
+	// Compare against a naive LRU stack.
+	rng := rand.New(rand.NewSource(1))
+	tracker := newReuseTracker()
+	var stack []blockID
+	for i := 0; i < 10000; i++ {
+		id := blockID{block: int64(rng.Intn(50))}
+		distance, _, ok := tracker.access(id, int64(i))
+		expected := -1
+		for j := range stack {
+			if stack[j] == id {
+				expected = len(stack) - 1 - j
+				stack = append(stack[:j], stack[j+1:]...)
+				break
+			}
+		}
+		stack = append(stack, id)
+		require.Equal(t, expected != -1, ok)
+		if ok {
+			require.Equal(t, int64(expected), distance)
+		}
+	}
+}
@@ -0,0 +1,135 @@
+package lib
+
+// s3FIFOCache implements S3-FIFO (Yang et al., SOSP '23). New entries go into
+// a small FIFO (10% of the capacity); entries that are accessed while in the
+// small FIFO move to the main FIFO when they reach its end, the others are
+// evicted and remembered in a ghost FIFO. Entries found in the ghost FIFO go
+// directly into the main FIFO, which uses a small frequency counter to give
+// accessed entries another pass (like CLOCK).
+//
+// With byte-weighted capacity, the FIFO sizes are in bytes.
+type s3FIFOCache struct {
+	capacity  int64
+	smallSize int64
+	entries   map[string]*cacheEntry
+	lists     [3]entryList
+}
+
+var _ sizedCache = (*s3FIFOCache)(nil)
+var _ invalidatingCache = (*s3FIFOCache)(nil)
+
+// Values for cacheEntry.list.
+const (
+	s3FIFOSmall = iota
+	s3FIFOMain
+	s3FIFOGhost
+)
+
+// s3FIFOMaxFreq is the maximum value of the frequency counter.
+const s3FIFOMaxFreq = 3
+
+func newS3FIFOCache(capacity int64) *s3FIFOCache {
+	return &s3FIFOCache{
+		capacity:  capacity,
+		smallSize: max64(capacity/10, 1),
+		entries:   make(map[string]*cacheEntry),
+	}
+}
+
+func (c *s3FIFOCache) Get(key string) interface{} {
+	e, ok := c.entries[key]
+	if !ok || e.list == s3FIFOGhost {
+		return nil
+	}
+	if e.freq < s3FIFOMaxFreq {
+		e.freq++
+	}
+	return e.value
+}
+
+func (c *s3FIFOCache) Set(key string, value interface{}) {
+	c.SetSized(key, value, 1)
+}
+
+func (c *s3FIFOCache) SetSized(key string, value interface{}, size int64) {
+	e, ok := c.entries[key]
+	if ok && e.list != s3FIFOGhost {
+		e.value = value
+		c.lists[e.list].resize(e, size)
+		if e.freq < s3FIFOMaxFreq {
+			e.freq++
+		}
+		c.evict(0)
+		return
+	}
+	if size > c.capacity {
+		return
+	}
+	if ok {
+		c.lists[s3FIFOGhost].remove(e)
+		delete(c.entries, key)
+	}
+	c.evict(size)
+	list := uint8(s3FIFOSmall)
+	if ok {
+		list = s3FIFOMain
+	}
+	e = &cacheEntry{key: key, value: value, size: size, list: list}
+	c.entries[key] = e
+	c.lists[list].pushFront(e)
+}
+
+// evict evicts entries until an entry of the given size fits.
+func (c *s3FIFOCache) evict(size int64) {
+	small, main, ghost := &c.lists[s3FIFOSmall], &c.lists[s3FIFOMain], &c.lists[s3FIFOGhost]
+	for small.size+main.size+size > c.capacity {
+		if small.len > 0 && (small.size >= c.smallSize || main.len == 0) {
+			e := small.back()
+			small.remove(e)
+			if e.freq > 0 {
+				e.freq = 0
+				e.list = s3FIFOMain
+				main.pushFront(e)
+			} else {
+				e.value = nil
+				e.list = s3FIFOGhost
+				ghost.pushFront(e)
+				// The ghost FIFO remembers about as many entries as the main FIFO
+				// can hold.
+				for ghost.size > c.capacity-c.smallSize {
+					g := ghost.back()
+					ghost.remove(g)
+					delete(c.entries, g.key)
+				}
+			}
+			continue
+		}
+		e := main.back()
+		main.remove(e)
+		if e.freq > 0 {
+			e.freq--
+			main.pushFront(e)
+		} else {
+			delete(c.entries, e.key)
+		}
+	}
+}
+
+func (c *s3FIFOCache) Remove(key string) {
+	if e, ok := c.entries[key]; ok && e.list != s3FIFOGhost {
+		c.lists[e.list].remove(e)
+		delete(c.entries, key)
+	}
+}
+
+func (c *s3FIFOCache) peek(key string) (size int64, ok bool) {
+	if e, ok := c.entries[key]; ok && e.list != s3FIFOGhost {
+		return e.size, true
+	}
+	return 0, false
+}
+
+// residentSize returns the total size of the resident entries.
+func (c *s3FIFOCache) residentSize() int64 {
+	return c.lists[s3FIFOSmall].size + c.lists[s3FIFOMain].size
+}
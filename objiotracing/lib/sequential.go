@@ -0,0 +1,164 @@
+package lib
+
+import (
+	"github.com/cockroachdb/pebble/objstorage/objstorageprovider/objiotracing"
+)
+
+// DefaultSequentialGap is the default maximum gap between sequential reads (see
+// ClassifyAccesses).
+const DefaultSequentialGap = 32 * 1024
+
+// AccessPatternStats describes how much of a set of reads is sequential.
+//
+// The reads of each file are split into streams by reason (compaction reads
+// and user reads of the same file are interleaved, but are separate streams,
+// as in the readahead model; see Config.Readahead). A read continues the
+// sequential run of its stream if it starts at or after the start of the
+// previous read, and at most the allowed gap after its end. Runs of a single
+// read are random accesses.
+type AccessPatternStats struct {
+	Reads int64
+	Bytes int64
+	// SequentialReads and SequentialBytes count the reads which are part of
+	// sequential runs of at least two reads.
+	SequentialReads int64
+	SequentialBytes int64
+	// Runs is the number of runs, including random accesses.
+	Runs int64
+	// RunLengths is the distribution of the number of reads in each run, and
+	// RunBytes the distribution of the bytes read by each run.
+	RunLengths Log2Histogram
+	RunBytes   Log2Histogram
+}
+
+// SequentialFraction returns the fraction of the bytes read that were read
+// sequentially.
+func (s *AccessPatternStats) SequentialFraction() float64 {
+	if s.Bytes == 0 {
+		return 0
+	}
+	return float64(s.SequentialBytes) / float64(s.Bytes)
+}
+
+// MeanSequentialRunLength returns the average number of reads in the sequential
+// runs (excluding random accesses).
+func (s *AccessPatternStats) MeanSequentialRunLength() float64 {
+	seqRuns := s.Runs - (s.Reads - s.SequentialReads)
+	if seqRuns == 0 {
+		return 0
+	}
+	return float64(s.SequentialReads) / float64(seqRuns)
+}
+
+func (s *AccessPatternStats) addRun(r *sequentialRun) {
+	s.Reads += r.reads
+	s.Bytes += r.bytes
+	if r.reads > 1 {
+		s.SequentialReads += r.reads
+		s.SequentialBytes += r.bytes
+	}
+	s.Runs++
+	s.RunLengths.Add(r.reads)
+	s.RunBytes.Add(r.bytes)
+}
+
+// AccessPatterns contains access pattern statistics for all reads in a trace,
+// split by the level of the file (the keys are the names returned by
+// LevelName) and by reason (the keys are the names returned by ReasonName).
+type AccessPatterns struct {
+	All      AccessPatternStats
+	ByLevel  map[string]*AccessPatternStats
+	ByReason map[string]*AccessPatternStats
+}
+
+// sequentialRun is the current run of a stream.
+type sequentialRun struct {
+	levelPlusOne uint8
+	reason       objiotracing.Reason
+	// lastOffset and lastEnd describe the previous read.
+	lastOffset, lastEnd int64
+	reads               int64
+	bytes               int64
+}
+
+// sequentialClassifier segments the read streams of a trace into sequential
+// runs.
+type sequentialClassifier struct {
+	maxGap  int64
+	streams map[readaheadStreamID]*sequentialRun
+	// onRun is called for each finished run.
+	onRun func(r *sequentialRun)
+}
+
+func newSequentialClassifier(maxGap int64, onRun func(r *sequentialRun)) *sequentialClassifier {
+	return &sequentialClassifier{
+		maxGap:  maxGap,
+		streams: make(map[readaheadStreamID]*sequentialRun),
+		onRun:   onRun,
+	}
+}
+
+func (c *sequentialClassifier) add(e *objiotracing.Event) {
+	if e.Op != objiotracing.ReadOp && e.Op != objiotracing.RecordCacheHitOp {
+		return
+	}
+	id := readaheadStreamID{file: eventFile(e), reason: e.Reason}
+	r, ok := c.streams[id]
+	if ok && (e.Offset < r.lastOffset || e.Offset > r.lastEnd+c.maxGap) {
+		c.onRun(r)
+		ok = false
+	}
+	if !ok {
+		r = &sequentialRun{levelPlusOne: e.LevelPlusOne, reason: e.Reason}
+		c.streams[id] = r
+	}
+	r.lastOffset = e.Offset
+	r.lastEnd = e.Offset + e.Size
+	r.reads++
+	r.bytes += e.Size
+}
+
+// finish ends all runs.
+func (c *sequentialClassifier) finish() {
+	for id, r := range c.streams {
+		c.onRun(r)
+		delete(c.streams, id)
+	}
+}
+
+// ClassifyAccesses segments the reads of each file into sequential runs and
+// random accesses (see AccessPatternStats), allowing gaps of up to maxGap bytes
+// (e.g. skipped blocks) between sequential reads. Reads that hit Pebble's
+// block cache are included.
+func ClassifyAccesses(it iterator, maxGap int64) (*AccessPatterns, error) {
+	r := &AccessPatterns{
+		ByLevel:  make(map[string]*AccessPatternStats),
+		ByReason: make(map[string]*AccessPatternStats),
+	}
+	get := func(m map[string]*AccessPatternStats, key string) *AccessPatternStats {
+		s, ok := m[key]
+		if !ok {
+			s = &AccessPatternStats{}
+			m[key] = s
+		}
+		return s
+	}
+	c := newSequentialClassifier(maxGap, func(run *sequentialRun) {
+		r.All.addRun(run)
+		get(r.ByLevel, LevelName(run.levelPlusOne)).addRun(run)
+		get(r.ByReason, ReasonName(run.reason)).addRun(run)
+	})
+	for {
+		batch, err := it.NextBatch()
+		if err != nil {
+			return nil, err
+		}
+		if batch == nil {
+			c.finish()
+			return r, nil
+		}
+		for i := range batch {
+			c.add(&batch[i])
+		}
+	}
+}
@@ -0,0 +1,64 @@
+package lib
+
+import (
+	"testing"
+
+	"github.com/cockroachdb/pebble/objstorage/objstorageprovider/objiotracing"
+	"github.com/stretchr/testify/require"
+)
+
+func TestClassifyAccesses(t *testing.T) {
+	read := func(reason objiotracing.Reason, levelPlusOne uint8, fileNum uint64, offset int64) objiotracing.Event {
+		e := objiotracing.Event{
+			Op:           objiotracing.ReadOp,
+			Reason:       reason,
+			LevelPlusOne: levelPlusOne,
+			Offset:       offset,
+			Size:         100,
+		}
+		setFileNum(&e.FileNum, fileNum)
+		return e
+	}
+	const (
+		user    = objiotracing.UnknownReason
+		compact = objiotracing.ForCompaction
+	)
+	trace := []objiotracing.Event{
+		// A compaction scans file 1, interleaved with random user reads of the
+		// same file.
+		read(compact, 7, 1, 0),
+		read(user, 7, 1, 5000),
+		read(compact, 7, 1, 100),
+		read(user, 7, 1, 1000),
+		// A gap of 50 bytes is allowed.
+		read(compact, 7, 1, 250),
+		read(compact, 7, 1, 350),
+		// A user scan of file 2 which goes back, then a gap which is too large.
+		read(user, 1, 2, 0),
+		read(user, 1, 2, 100),
+		read(user, 1, 2, 0),
+		read(user, 1, 2, 100),
+		read(user, 1, 2, 1000),
+	}
+	r, err := ClassifyAccesses(&wrappedTrace{inner: trace}, 50)
+	require.NoError(t, err)
+
+	require.Equal(t, int64(11), r.All.Reads)
+	require.Equal(t, int64(1100), r.All.Bytes)
+	require.Equal(t, int64(8), r.All.SequentialReads)
+	require.Equal(t, int64(800), r.All.SequentialBytes)
+	require.Equal(t, int64(6), r.All.Runs)
+	require.InDelta(t, 8.0/11, r.All.SequentialFraction(), 1e-9)
+	require.InDelta(t, 8.0/3, r.All.MeanSequentialRunLength(), 1e-9)
+	require.Equal(t, []HistogramBucket{
+		{Lo: 1, Hi: 2, Count: 3},
+		{Lo: 2, Hi: 4, Count: 2},
+		{Lo: 4, Hi: 8, Count: 1},
+	}, r.All.RunLengths.Buckets())
+
+	require.Equal(t, 1.0, r.ByReason["compaction"].SequentialFraction())
+	require.Equal(t, int64(1), r.ByReason["compaction"].Runs)
+	require.InDelta(t, 4.0/7, r.ByReason["unknown"].SequentialFraction(), 1e-9)
+	require.InDelta(t, 4.0/6, r.ByLevel["L6"].SequentialFraction(), 1e-9)
+	require.InDelta(t, 4.0/5, r.ByLevel["L0"].SequentialFraction(), 1e-9)
+}
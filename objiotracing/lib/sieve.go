@@ -0,0 +1,99 @@
+package lib
+
+// sieveCache implements SIEVE (Zhang et al., NSDI '24). Entries are kept in a
+// FIFO with a "visited" bit which is set on access. A hand moves from the
+// oldest entry towards the newest, clearing visited bits and evicting the first
+// entry that was not visited; unlike CLOCK, surviving entries are not moved.
+//
+// With byte-weighted capacity, the FIFO size is in bytes.
+type sieveCache struct {
+	capacity int64
+	entries  map[string]*cacheEntry
+	// list contains the entries, newest at the front. The freq field is used as
+	// the visited bit.
+	list entryList
+	// hand is the next entry to consider for eviction; nil means the back of the
+	// list.
+	hand *cacheEntry
+}
+
+var _ sizedCache = (*sieveCache)(nil)
+var _ invalidatingCache = (*sieveCache)(nil)
+
+func newSIEVECache(capacity int64) *sieveCache {
+	return &sieveCache{
+		capacity: capacity,
+		entries:  make(map[string]*cacheEntry),
+	}
+}
+
+func (c *sieveCache) Get(key string) interface{} {
+	e, ok := c.entries[key]
+	if !ok {
+		return nil
+	}
+	e.freq = 1
+	return e.value
+}
+
+func (c *sieveCache) Set(key string, value interface{}) {
+	c.SetSized(key, value, 1)
+}
+
+func (c *sieveCache) SetSized(key string, value interface{}, size int64) {
+	if e, ok := c.entries[key]; ok {
+		e.value = value
+		e.freq = 1
+		c.list.resize(e, size)
+		c.evict(0)
+		return
+	}
+	if size > c.capacity {
+		return
+	}
+	c.evict(size)
+	e := &cacheEntry{key: key, value: value, size: size}
+	c.entries[key] = e
+	c.list.pushFront(e)
+}
+
+// evict evicts entries until an entry of the given size fits.
+func (c *sieveCache) evict(size int64) {
+	for c.list.size+size > c.capacity {
+		e := c.hand
+		if e == nil {
+			e = c.list.back()
+		}
+		for e.freq != 0 {
+			e.freq = 0
+			if e = c.list.before(e); e == nil {
+				e = c.list.back()
+			}
+		}
+		c.hand = c.list.before(e)
+		c.list.remove(e)
+		delete(c.entries, e.key)
+	}
+}
+
+func (c *sieveCache) Remove(key string) {
+	if e, ok := c.entries[key]; ok {
+		if c.hand == e {
+			c.hand = c.list.before(e)
+		}
+		c.list.remove(e)
+		delete(c.entries, key)
+	}
+}
+
+func (c *sieveCache) peek(key string) (size int64, ok bool) {
+	if e, ok := c.entries[key]; ok {
+		return e.size, true
+	}
+	return 0, false
+}
+
+// residentSize returns the total size of the resident entries.
+func (c *sieveCache) residentSize() int64 {
+	return c.list.size
+}
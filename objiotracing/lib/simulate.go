@@ -2,6 +2,9 @@ package lib
 
 import (
 	"fmt"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/cockroachdb/pebble/objstorage/objstorageprovider/objiotracing"
 	"github.com/dgryski/go-clockpro"
@@ -15,8 +18,19 @@ const (
 	ClockPro ReplacementPolicy = iota
 	S4LRU
 	TinyLFU
-	// TODO(josh): Implement. Can use https://github.com/dgryski/go-tinylfu/blob/master/lru.go.
 	LRU
+	// OPT is Belady's offline optimal policy: it evicts the entry that will be
+	// used furthest in the future. It serves as an upper bound for the hit rate
+	// of any policy.
+	OPT
+	ARC
+	LIRS
+	TwoQ
+	// WTinyLFU is Window TinyLFU with an adaptive window size (as in Caffeine).
+	// It is different from TinyLFU, which uses a fixed-size window.
+	WTinyLFU
+	S3FIFO
+	SIEVE
 )
 
 func (p ReplacementPolicy) String() string {
@@ -28,11 +42,54 @@ func (p ReplacementPolicy) String() string {
 		return  "TinyLFU"
 	} else if p == LRU {
 		return "LRU"
+	} else if p == OPT {
+		return "OPT"
+	} else if p == ARC {
+		return "ARC"
+	} else if p == LIRS {
+		return "LIRS"
+	} else if p == TwoQ {
+		return "2Q"
+	} else if p == WTinyLFU {
+		return "W-TinyLFU"
+	} else if p == S3FIFO {
+		return "S3-FIFO"
+	} else if p == SIEVE {
+		return "SIEVE"
 	} else {
 		panic("not implemented")
 	}
 }
 
+// ParseReplacementPolicy parses a replacement policy name (see
+// ReplacementPolicy.String); the name is case-insensitive.
+func ParseReplacementPolicy(s string) (ReplacementPolicy, error) {
+	for p := ClockPro; p <= SIEVE; p++ {
+		if strings.EqualFold(p.String(), s) {
+			return p, nil
+		}
+	}
+	return 0, fmt.Errorf("invalid replacement policy %q", s)
+}
+
+// SupportsInvalidation returns true if the policy supports removing entries
+// and checking whether entries are resident without accessing them (see
+// Config.InvalidateOnDelete and Config.Readahead).
+func (p ReplacementPolicy) SupportsInvalidation() bool {
+	return p.SupportsByteWeighted() && p != OPT
+}
+
+// SupportsByteWeighted returns true if the policy can be used with
+// Config.ByteWeighted.
+func (p ReplacementPolicy) SupportsByteWeighted() bool {
+	switch p {
+	case ClockPro, S4LRU, TinyLFU:
+		return false
+	default:
+		return true
+	}
+}
+
 // Note that this is used a key into a cache. Avoid pointer fields.
 // See resultCacheKey for more.
 type Config struct {
@@ -43,71 +100,455 @@ type Config struct {
 	// I have is whether the existing in-memory pebble block cache caches in units of pebble
 	// sstable blocks.
 	BlockSize                int64
+	// If set (with BlockSize), reads and writes access all the blocks they
+	// overlap, rather than only the first one.
+	AllBlocks bool
 	CacheSize                int
+	// If set, CacheSize is in bytes and each entry occupies its size (BlockSize,
+	// or the size of the read if BlockSize is 0); otherwise CacheSize is the
+	// number of entries. Not supported by all policies (see
+	// ReplacementPolicy.SupportsByteWeighted).
+	ByteWeighted bool
 	// Must be set >0 if Policy == TinyLFU. Else must be 0.
 	TinyLFUSamples           int
 	WriteThru                bool
 	CacheUserFacingReadsOnly bool
 	L5AndL6Only              bool
+	// If set, each source (node/store) of a multi-source trace gets its own
+	// cache of size CacheSize; otherwise all sources share one cache. Note that
+	// cache keys always include the source, so files from different sources
+	// never collide.
+	CachePerSource bool
+
+	// Admission decides which missing (or written) blocks are inserted into the
+	// cache; it applies in addition to the WriteThru, CacheUserFacingReadsOnly
+	// and L5AndL6Only options. The fields below are the parameters of the
+	// admission policies, and must be 0 if not applicable.
+	Admission AdmissionPolicy
+	// AdmissionMinFrequency is used with AdmitTinyLFU; 0 means 2.
+	AdmissionMinFrequency int
+	// AdmissionMaxSize is used with AdmitSizeThreshold.
+	AdmissionMaxSize int64
+	// AdmissionLevels, AdmissionReasons and AdmissionBlockTypes are used with
+	// AdmitPredicate. They are bitmasks of admitted values: bit i is set if
+	// LevelPlusOne (resp. Reason, BlockType) i is admitted. A zero mask admits
+	// all values.
+	AdmissionLevels     uint8
+	AdmissionReasons    uint8
+	AdmissionBlockTypes uint8
+	// AdmissionProbability is used with AdmitProbabilistic.
+	AdmissionProbability float64
+	// AdmissionMinFileLifetimeSecs is used with AdmitFileLifetime.
+	AdmissionMinFileLifetimeSecs int
+
+	// Deletion determines how the simulator infers that sstables are deleted;
+	// blocks of deleted files can never be read again, so they only waste
+	// capacity. Ignored by OPT, which evicts blocks that are not used again
+	// before any others.
+	Deletion DeletionMode
+	// InvalidateOnDelete removes the blocks of a file from the cache when the
+	// file is deleted. Requires a policy that supports invalidation.
+	InvalidateOnDelete bool
+	// DeletionGraceSecs is used with DeleteAfterCompactionRead; 0 means 10s.
+	DeletionGraceSecs int
+	// DeletionsFile is used with DeleteExternal (see LoadDeletions).
+	DeletionsFile string
+
+	// Readahead models prefetching of the blocks that follow a missed block
+	// when a file is read sequentially. Requires BlockSize and a policy that
+	// supports invalidation. Prefetched blocks bypass the admission policy.
+	// Ignored by OPT.
+	Readahead ReadaheadMode
+	// ReadaheadSize is the (maximum) readahead size in bytes; 0 means 256KB.
+	ReadaheadSize int64
+
+	// CoalesceWindow enables merging the object store reads for misses on the
+	// same file which are issued within this time of the first read, and whose
+	// byte ranges are at most CoalesceMaxGap bytes apart. Ignored by OPT.
+	CoalesceWindow time.Duration
+	CoalesceMaxGap int64
 }
 
 func (c *Config) String() string {
-	return fmt.Sprintf("%+v", c)
+	return fmt.Sprintf("%+v", *c)
+}
+
+// usesFileTable returns true if the simulation requires the sstable metadata
+// of the trace (see Simulate).
+func (c *Config) usesFileTable() bool {
+	return c.Admission == AdmitFileLifetime || (c.Deletion == DeleteManifest && c.Policy != OPT)
+}
+
+// readsFileTable returns true if the simulation uses the sstable metadata of
+// the trace, when it is available.
+func (c *Config) readsFileTable() bool {
+	return c.usesFileTable() || (c.Readahead == ReadaheadWholeFile && c.Policy != OPT)
 }
 
 type Results struct {
 	Hits   int
 	Misses int
+	// HitBytes and MissBytes are the total sizes of the cache entries that were
+	// hit or missed.
+	HitBytes  int64
+	MissBytes int64
+	// ReadHits and ReadMisses count the reads of the trace rather than the
+	// blocks they access: a read hits only if all its blocks hit. They only
+	// differ from Hits and Misses if Config.AllBlocks is set.
+	ReadHits   int
+	ReadMisses int
+
+	// The fields below are only set when Config.Deletion is set.
+
+	// DeletedFiles is the number of files that were deemed deleted.
+	DeletedFiles int
+	// DeletedFileReads is the number of reads of files after they were deemed
+	// deleted (which indicates a wrong inference).
+	DeletedFileReads int
+	// InvalidatedBlocks is the number of resident blocks that were removed
+	// because their file was deleted (with Config.InvalidateOnDelete).
+	InvalidatedBlocks int
+	// WastedCapacity is the average fraction of the cache capacity occupied by
+	// blocks of deleted files (sampled periodically). It is only computed for
+	// policies that support invalidation (see
+	// ReplacementPolicy.SupportsInvalidation).
+	WastedCapacity float64
+
+	// ObjectStoreRequests is the number of reads sent to the object store: one
+	// per miss (which includes any readahead), unless misses are coalesced.
+	// ObjectStoreBytes is the number of bytes read, including prefetched blocks
+	// and over-read bytes.
+	ObjectStoreRequests int
+	ObjectStoreBytes    int64
+	// RequestSizes is the distribution of the sizes of the object store reads.
+	RequestSizes Log2Histogram
+	// OverReadBytes is the number of bytes read unnecessarily because of
+	// coalescing (see Config.CoalesceWindow).
+	OverReadBytes int64
+	// BlockOverReadBytes is the number of bytes read unnecessarily because the
+	// cache fetches whole blocks (see Config.BlockSize): the parts of the missed
+	// blocks which are outside the range of the reads that missed them.
+	BlockOverReadBytes int64
+	// WrittenFiles and WrittenBytes are the number of files and bytes written
+	// in the trace (each file is uploaded to the object store), regardless of
+	// the cache.
+	WrittenFiles int
+	WrittenBytes int64
+
+	// The fields below are only set when Config.Readahead is set.
+
+	// PrefetchedBlocks is the number of blocks inserted by readahead.
+	PrefetchedBlocks int
+	// PrefetchHits is the number of prefetched blocks which were read before
+	// being evicted.
+	PrefetchHits int
+	// WastedPrefetchBytes is the total size of the prefetched blocks which were
+	// evicted (or still resident at the end) without being read.
+	WastedPrefetchBytes int64
 }
 
-func Simulate(traceID string, it iterator, config Config) (*Results, error) {
+// HitRate returns the fraction of accesses which hit the cache, or 0 if there
+// were no accesses.
+func (r *Results) HitRate() float64 {
+	if r.Hits+r.Misses == 0 {
+		return 0
+	}
+	return float64(r.Hits) / float64(r.Hits+r.Misses)
+}
+
+// ReadHitRate returns the fraction of reads which hit the cache (see
+// ReadHits), or 0 if there were no reads.
+func (r *Results) ReadHitRate() float64 {
+	if r.ReadHits+r.ReadMisses == 0 {
+		return 0
+	}
+	return float64(r.ReadHits) / float64(r.ReadHits+r.ReadMisses)
+}
+
+// PrefetchAccuracy returns the fraction of prefetched blocks which were read
+// before being evicted.
+func (r *Results) PrefetchAccuracy() float64 {
+	if r.PrefetchedBlocks == 0 {
+		return 0
+	}
+	return float64(r.PrefetchHits) / float64(r.PrefetchedBlocks)
+}
+
+// Simulate runs the trace through the cache described by the config. The
+// sstable metadata of the trace (typically obtained with TraceStore.FileTable)
+// is required by AdmitFileLifetime and DeleteManifest, and is optional
+// otherwise. Results are cached by trace ID, config and file table.
+func Simulate(traceID string, it iterator, config Config, files *FileTable) (*Results, error) {
 	if config.Policy == S4LRU {
 		// Requires that size is divisible by four. Must adjust before
 		// interacting with the cache.
 		config.CacheSize = config.CacheSize / 4 * 4
 	}
+	if !config.readsFileTable() {
+		// Don't cache results separately for tables that aren't used.
+		files = nil
+	}
 
-	results, ok := resultCache[resultCacheKey{
+	key := resultCacheKey{
 		traceID: traceID,
 		config:  config,
-	}]
+		files:   files,
+	}
+	resultCacheMu.Lock()
+	results, ok := resultCache[key]
+	resultCacheMu.Unlock()
 	if ok {
 		return &results, nil
 	}
 
+	if config.Policy != TinyLFU && config.TinyLFUSamples != 0 {
+		panic("sampled expected to not be set (set to 0) but is set")
+	}
+
+	if files == nil && config.usesFileTable() {
+		return nil, fmt.Errorf("no sstable metadata for trace %q", traceID)
+	}
+
 	results = Results{}
-	var cache cache
-	if config.Policy == ClockPro {
-		cache = clockpro.New(config.CacheSize)
-	} else if config.Policy == S4LRU {
-		cache = &wrappedS4LRU{s4lru.New(config.CacheSize)}
-	} else if config.Policy == TinyLFU {
-		if config.TinyLFUSamples == 0 {
-			panic("samples expected to be set but not set")
+	it = &writeCounter{iterator: it, results: &results, files: make(map[fileID]struct{})}
+	var err error
+	if config.Policy == OPT {
+		err = simulateOPT(it, &config, files, &results)
+	} else {
+		err = simulateOnline(it, &config, files, &results, nil /* observer */)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	resultCacheMu.Lock()
+	resultCache[key] = results
+	resultCacheMu.Unlock()
+	return &results, nil
+}
+
+// accessObserver is notified of the cache operations of an online simulation
+// (see simulateOnline).
+type accessObserver interface {
+	// observe is called for each event, before its accesses.
+	observe(e *objiotracing.Event)
+	// inserted is called for each block inserted into the cache.
+	inserted(a *access)
+	// hit is called for each read that hits the cache.
+	hit(a *access)
+}
+
+// simulateOnline runs the simulation for the (non-offline) replacement
+// policies. The observer is optional.
+func simulateOnline(
+	it iterator, config *Config, files *FileTable, results *Results, observer accessObserver,
+) error {
+	caches := newSourceCaches(config, files)
+	var deletions *deletionModel
+	if config.Deletion != DeleteNone {
+		var err error
+		deletions, it, err = newDeletionModel(it, config, files, results, caches)
+		if err != nil {
+			return err
+		}
+		defer deletions.close()
+	}
+	insert := func(c cache, a *access) {
+		if config.ByteWeighted {
+			c.(sizedCache).SetSized(a.id.key(), true, a.size)
+		} else {
+			c.Set(a.id.key(), true)
+		}
+		if deletions != nil {
+			deletions.inserted(a)
+		}
+		if observer != nil {
+			observer.inserted(a)
+		}
+	}
+	var readahead *readaheadModel
+	if config.Readahead != ReadaheadNone {
+		readahead = newReadaheadModel(config, files, results)
+	}
+	var coalesce *coalescer
+	if config.CoalesceWindow > 0 {
+		coalesce = newCoalescer(config, results)
+	}
+	var onEvent func(e *objiotracing.Event)
+	if deletions != nil || readahead != nil || observer != nil {
+		onEvent = func(e *objiotracing.Event) {
+			if deletions != nil {
+				deletions.observe(e)
+			}
+			if readahead != nil {
+				readahead.observe(e)
+			}
+			if observer != nil {
+				observer.observe(e)
+			}
+		}
+	}
+	var reads readCounter
+	err := forEachAccess(it, config, onEvent, func(a access) {
+		c := caches.get(a.id.source)
+		admit := c.admit(&a)
+		if a.write {
+			if admit {
+				insert(c.cache, &a)
+			}
+			return
+		}
+		v := c.Get(a.id.key())
+		reads.add(v != nil, a.lastBlock, results)
+		if v == nil {
+			results.Misses++
+			results.MissBytes += a.size
+			results.BlockOverReadBytes += a.overRead
+			offset, length := config.blockOffset(a.id.block), a.size
+			if admit {
+				insert(c.cache, &a)
+				if readahead != nil {
+					offset, length = readahead.miss(c.cache, &a, insert)
+				}
+			}
+			if coalesce != nil {
+				f := fileID{source: a.id.source, fileNum: a.id.fileNum}
+				coalesce.add(f, a.event.StartUnixNano, offset, offset+length)
+			} else {
+				results.ObjectStoreRequests++
+				results.ObjectStoreBytes += length
+				results.RequestSizes.Add(length)
+			}
+		} else {
+			results.Hits++
+			results.HitBytes += a.size
+			if readahead != nil {
+				readahead.hit(&a)
+			}
+			if observer != nil {
+				observer.hit(&a)
+			}
 		}
-		cache = &wrappedTinyLFU{tinylfu.New(config.CacheSize, config.TinyLFUSamples)}
+	})
+	if err != nil {
+		return err
+	}
+	if deletions != nil {
+		deletions.finish()
+	}
+	if readahead != nil {
+		readahead.finish()
+	}
+	if coalesce != nil {
+		coalesce.finish()
+	}
+	return nil
+}
+
+// readCounter calculates Results.ReadHits and ReadMisses from the outcomes of
+// the accesses of each read.
+type readCounter struct {
+	missed bool
+}
+
+// add records the outcome of a read access; lastBlock is set for the last
+// access of the read.
+func (rc *readCounter) add(hit, lastBlock bool, results *Results) {
+	rc.missed = rc.missed || !hit
+	if !lastBlock {
+		return
+	}
+	if rc.missed {
+		results.ReadMisses++
 	} else {
-		panic("replacement policy not implemented")
+		results.ReadHits++
 	}
-	if config.Policy != TinyLFU && config.TinyLFUSamples != 0 {
-		panic("sampled expected to not be set (set to 0) but is set")
+	rc.missed = false
+}
+
+// sourceCaches maps source IDs to caches; if CachePerSource is not set, all
+// sources map to the same cache (under ID 0).
+type sourceCaches struct {
+	config *Config
+	files  *FileTable
+	caches map[uint32]sourceCache
+}
+
+type sourceCache struct {
+	cache
+	admitter
+}
+
+func newSourceCaches(config *Config, files *FileTable) *sourceCaches {
+	return &sourceCaches{
+		config: config,
+		files:  files,
+		caches: make(map[uint32]sourceCache),
 	}
+}
 
+// get returns the cache for the given source, creating it if necessary.
+func (s *sourceCaches) get(source uint32) sourceCache {
+	if !s.config.CachePerSource {
+		source = 0
+	}
+	c, ok := s.caches[source]
+	if !ok {
+		c = sourceCache{
+			cache:    newCache(*s.config),
+			admitter: newAdmitter(s.config, s.files),
+		}
+		s.caches[source] = c
+	}
+	return c
+}
+
+// access is a cache operation derived from a trace event.
+type access struct {
+	id blockID
+	// size is the size of the cache entry (used when Config.ByteWeighted is
+	// set).
+	size int64
+	// write is set for write-thru insertions, which don't count as hits or
+	// misses.
+	write bool
+	// overRead is the number of bytes of the block which are outside the range
+	// of the read (see Results.BlockOverReadBytes).
+	overRead int64
+	// lastBlock is set for the last access of an event (the accesses of an
+	// event are consecutive).
+	lastBlock bool
+	// event is the trace event that caused the access.
+	event *objiotracing.Event
+}
+
+// forEachAccess calls fn for each cache access implied by the trace events,
+// according to the config. If onEvent is not nil, it is called for each event
+// (including events that don't result in accesses), before the event's
+// accesses.
+func forEachAccess(
+	it iterator, config *Config, onEvent func(e *objiotracing.Event), fn func(a access),
+) error {
 	for {
 		trace, err := it.NextBatch()
 		if err != nil {
-			return nil, err
+			return err
 		}
 		if trace == nil {
-			break
+			return nil
 		}
 
-		for _, e := range trace {
+		for i := range trace {
+			e := &trace[i]
+			if onEvent != nil {
+				onEvent(e)
+			}
 			if config.L5AndL6Only {
 				if e.LevelPlusOne <= 5 {
 					continue
 				}
 			}
+			source := EventSource(e)
 			// TODO(josh): We may want to ignore RecordCacheHitOps, or at least
 			// not call Set when they come up. Some discussion about this is at
 			// https://github.com/RaduBerinde/pebble_analysis/pull/1#discussion_r1158823825
@@ -117,43 +558,159 @@ func Simulate(traceID string, it iterator, config Config) (*Results, error) {
 						continue
 					}
 				}
-				offset := e.Offset
-				if config.BlockSize != 0 {
-					// TODO(josh): The end of a read may hit a different "cache block" than
-					// the start of a read. This code currently only simulates reading the
-					// first "cache block".
-					offset = offset / config.BlockSize
-				}
-				k := fmt.Sprintf("%v/%v", e.FileNum, offset)
-				v := cache.Get(k)
-				if v == nil {
-					results.Misses++
-					cache.Set(k, true)
-				} else  {
-					results.Hits++
+				// TODO(josh): The end of a read may hit a different "cache block" than
+				// the start of a read. Unless AllBlocks is set, this code only
+				// simulates reading the first "cache block".
+				first, last := config.blockRange(e)
+				for b := first; b <= last; b++ {
+					fn(access{
+						id:        blockID{source: source, fileNum: uint64(e.FileNum), block: b},
+						size:      config.entrySize(e),
+						overRead:  config.overRead(e, b),
+						lastBlock: b == last,
+						event:     e,
+					})
 				}
 			}
 			if config.WriteThru {
 				if e.Op == objiotracing.WriteOp {
 					// TODO(josh): The end of a write may hit a different "cache block" than
-					// the start of a write. This code currently only simulates writing the
-					// first "cache block" out.
-					offset := e.Offset
-					if config.BlockSize != 0 {
-						offset = offset / config.BlockSize
+					// the start of a write. Unless AllBlocks is set, this code only
+					// simulates writing the first "cache block" out.
+					first, last := config.blockRange(e)
+					for b := first; b <= last; b++ {
+						fn(access{
+							id:        blockID{source: source, fileNum: uint64(e.FileNum), block: b},
+							size:      config.entrySize(e),
+							write:     true,
+							lastBlock: b == last,
+							event:     e,
+						})
 					}
-					k := fmt.Sprintf("%v/%v", e.FileNum, e.Offset)
-					cache.Set(k, true)
 				}
 			}
 		}
 	}
+}
 
-	resultCache[resultCacheKey{
-		traceID: traceID,
-		config:  config,
-	}] = results
-	return &results, nil
+func newCache(config Config) cache {
+	if config.ByteWeighted && !config.Policy.SupportsByteWeighted() {
+		panic(fmt.Sprintf("%s does not support byte-weighted capacity", config.Policy))
+	}
+	if config.InvalidateOnDelete && !config.Policy.SupportsInvalidation() {
+		panic(fmt.Sprintf("%s does not support invalidation", config.Policy))
+	}
+	capacity := int64(config.CacheSize)
+	switch config.Policy {
+	case ClockPro:
+		return clockpro.New(config.CacheSize)
+	case S4LRU:
+		return &wrappedS4LRU{s4lru.New(config.CacheSize)}
+	case TinyLFU:
+		if config.TinyLFUSamples == 0 {
+			panic("samples expected to be set but not set")
+		}
+		return &wrappedTinyLFU{tinylfu.New(config.CacheSize, config.TinyLFUSamples)}
+	case LRU:
+		return newLRUCache(capacity)
+	case ARC:
+		return newARCCache(capacity)
+	case LIRS:
+		return newLIRSCache(capacity)
+	case TwoQ:
+		return newTwoQCache(capacity)
+	case WTinyLFU:
+		expectedEntries := config.CacheSize
+		if config.ByteWeighted {
+			expectedEntries = int(capacity / typicalEntrySize)
+		}
+		return newWTinyLFUCache(capacity, expectedEntries)
+	case S3FIFO:
+		return newS3FIFOCache(capacity)
+	case SIEVE:
+		return newSIEVECache(capacity)
+	default:
+		panic("replacement policy not implemented")
+	}
+}
+
+// typicalEntrySize is used to estimate the number of entries in a
+// byte-weighted cache.
+const typicalEntrySize = 4096
+
+// block returns the cache block that contains the given file offset. If
+// BlockSize is 0, the offset itself identifies the block.
+func (c *Config) block(offset int64) int64 {
+	if c.BlockSize == 0 {
+		return offset
+	}
+	return offset / c.BlockSize
+}
+
+// blockRange returns the blocks accessed by an event: the first block it
+// overlaps or, with AllBlocks, all of them.
+func (c *Config) blockRange(e *objiotracing.Event) (first, last int64) {
+	first = c.block(e.Offset)
+	if !c.AllBlocks || c.BlockSize == 0 || e.Size == 0 {
+		return first, first
+	}
+	return first, c.block(e.Offset + e.Size - 1)
+}
+
+// overRead returns the number of bytes of a block which are outside the range
+// of the event (0 if BlockSize is 0).
+func (c *Config) overRead(e *objiotracing.Event, block int64) int64 {
+	if c.BlockSize == 0 {
+		return 0
+	}
+	start := max64(c.blockOffset(block), e.Offset)
+	end := min64(c.blockOffset(block)+c.BlockSize, e.Offset+e.Size)
+	return c.BlockSize - max64(end-start, 0)
+}
+
+// blockOffset returns the offset of the start of a block.
+func (c *Config) blockOffset(block int64) int64 {
+	if c.BlockSize == 0 {
+		return block
+	}
+	return block * c.BlockSize
+}
+
+// entrySize returns the size of the cache entry for an event.
+func (c *Config) entrySize(e *objiotracing.Event) int64 {
+	if c.BlockSize == 0 {
+		return e.Size
+	}
+	return c.BlockSize
+}
+
+// blockID identifies a cache block. The source is part of the ID because file
+// numbers are only unique within a source.
+type blockID struct {
+	source  uint32
+	fileNum uint64
+	block   int64
+}
+
+// key returns the cache key for the block.
+func (b blockID) key() string {
+	return fmt.Sprintf("%d/%d/%d", b.source, b.fileNum, b.block)
+}
+
+// hash returns a 64-bit hash of the block ID.
+func (b blockID) hash() uint64 {
+	h := mix64(uint64(b.source)<<32 ^ b.fileNum)
+	return mix64(h ^ uint64(b.block))
+}
+
+// mix64 is the finalizer of the SplitMix64 generator.
+func mix64(x uint64) uint64 {
+	x ^= x >> 30
+	x *= 0xbf58476d1ce4e5b9
+	x ^= x >> 27
+	x *= 0x94d049bb133111eb
+	x ^= x >> 31
+	return x
 }
 
 type cache interface {
@@ -161,6 +718,25 @@ type cache interface {
 	Set(key string, value interface{})
 }
 
+// invalidatingCache is implemented by caches that support removing entries
+// and checking whether an entry is resident without affecting its state (see
+// Config.Deletion).
+type invalidatingCache interface {
+	cache
+	// Remove removes an entry, if it is resident.
+	Remove(key string)
+	// peek returns the size of an entry, if it is resident.
+	peek(key string) (size int64, ok bool)
+}
+
+// sizedCache is implemented by caches that support byte-weighted capacity
+// (see Config.ByteWeighted).
+type sizedCache interface {
+	cache
+	// SetSized adds an entry which occupies the given number of bytes.
+	SetSized(key string, value interface{}, size int64)
+}
+
 type wrappedS4LRU struct {
 	c *s4lru.Cache
 }
@@ -203,7 +779,12 @@ type iterator interface {
 type resultCacheKey struct {
 	traceID string
 	config  Config
+	// files is the sstable metadata used by the simulation (nil if the config
+	// doesn't use it).
+	files *FileTable
 }
 
+// resultCacheMu protects resultCache; simulations can run concurrently.
+var resultCacheMu sync.Mutex
 var resultCache = map[resultCacheKey]Results{}
 
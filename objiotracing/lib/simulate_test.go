@@ -22,7 +22,7 @@ func (t *wrappedTrace) NextBatch() ([]objiotracing.Event, error) {
 }
 
 func TestSimulate(t *testing.T) {
-	for i, policy := range []ReplacementPolicy{ClockPro, S4LRU, TinyLFU} {
+	for i, policy := range []ReplacementPolicy{ClockPro, S4LRU, TinyLFU, LRU, ARC, LIRS, TwoQ, WTinyLFU, S3FIFO, SIEVE} {
 		t.Run(fmt.Sprint(i), func(t *testing.T) {
 			var config Config
 			var trace []objiotracing.Event
@@ -33,7 +33,7 @@ func TestSimulate(t *testing.T) {
 				}
 				if policy == TinyLFU {
 					samples := 10 * config.CacheSize
-					config.TinyLFUSamples = &samples
+					config.TinyLFUSamples = samples
 				}
 				trace = []objiotracing.Event{
 					{
@@ -100,7 +100,7 @@ func TestSimulate(t *testing.T) {
 						Size: 1024,
 					},
 				}
-				results, err := Simulate(t.Name(), &wrappedTrace{inner: trace}, config)
+				results, err := Simulate(t.Name(), &wrappedTrace{inner: trace}, config, nil /* files */)
 				require.NoError(t, err)
 				require.Equal(t, 3, results.Hits)
 				require.Equal(t, 3, results.Misses)
@@ -110,7 +110,7 @@ func TestSimulate(t *testing.T) {
 				defer func() {
 					config.L5AndL6Only = false
 				}()
-				results, err := Simulate(t.Name(), &wrappedTrace{inner: trace}, config)
+				results, err := Simulate(t.Name(), &wrappedTrace{inner: trace}, config, nil /* files */)
 				require.NoError(t, err)
 				// Reads to other levels don't count as either hits or misses.
 				require.Equal(t, 1, results.Hits)
@@ -121,7 +121,7 @@ func TestSimulate(t *testing.T) {
 				defer func() {
 					config.CacheUserFacingReadsOnly = false
 				}()
-				results, err := Simulate(t.Name(), &wrappedTrace{inner: trace}, config)
+				results, err := Simulate(t.Name(), &wrappedTrace{inner: trace}, config, nil /* files */)
 				require.NoError(t, err)
 				// Reads done for reasons other as part of compaction, etc. don't
 				// count as either hits or misses.
@@ -130,11 +130,11 @@ func TestSimulate(t *testing.T) {
 			})
 			t.Run("large block size", func(t *testing.T) {
 				var blockSize int64 = 1024 * 10
-				config.BlockSize = &blockSize
+				config.BlockSize = blockSize
 				defer func() {
-					config.BlockSize = nil
+					config.BlockSize = 0
 				}()
-				results, err := Simulate(t.Name(), &wrappedTrace{inner: trace}, config)
+				results, err := Simulate(t.Name(), &wrappedTrace{inner: trace}, config, nil /* files */)
 				require.NoError(t, err)
 				// Initial miss will fill cache with what is needed for rest of reads
 				// to be hits.
@@ -146,7 +146,7 @@ func TestSimulate(t *testing.T) {
 				defer func() {
 					config.WriteThru = false
 				}()
-				results, err := Simulate(t.Name(), &wrappedTrace{inner: trace}, config)
+				results, err := Simulate(t.Name(), &wrappedTrace{inner: trace}, config, nil /* files */)
 				require.NoError(t, err)
 				// Inclusion of write-thru leads to one more hit than first test case.
 				require.Equal(t, 4, results.Hits)
@@ -159,7 +159,7 @@ func TestSimulate(t *testing.T) {
 					config.WriteThru = false
 					config.CacheUserFacingReadsOnly = false
 				}()
-				results, err := Simulate(t.Name(), &wrappedTrace{inner: trace}, config)
+				results, err := Simulate(t.Name(), &wrappedTrace{inner: trace}, config, nil /* files */)
 				require.NoError(t, err)
 				// Both reads that are (likely) user-facing are hits, since earlier
 				// write done as part of compaction has filled cache.
@@ -169,3 +169,149 @@ func TestSimulate(t *testing.T) {
 		})
 	}
 }
+
+func TestSimulateSources(t *testing.T) {
+	trace := []objiotracing.Event{
+		{Op: objiotracing.ReadOp, FileNum: 10, Offset: 0, Size: 1024},
+		{Op: objiotracing.ReadOp, FileNum: 10, Offset: 0, Size: 1024},
+		{Op: objiotracing.ReadOp, FileNum: 10, Offset: 0, Size: 1024},
+		{Op: objiotracing.ReadOp, FileNum: 10, Offset: 0, Size: 1024},
+	}
+	// Alternate between two sources.
+	for i := range trace {
+		SetEventSource(&trace[i], uint32(1+i%2))
+		require.Equal(t, uint32(1+i%2), EventSource(&trace[i]))
+	}
+
+	config := Config{Policy: ClockPro, CacheSize: 16}
+	results, err := Simulate(t.Name(), &wrappedTrace{inner: trace}, config, nil /* files */)
+	require.NoError(t, err)
+	// The same file on two different sources doesn't collide.
+	require.Equal(t, 2, results.Hits)
+	require.Equal(t, 2, results.Misses)
+
+	// With S4LRU of size 4, new blocks go into a segment of size 1.
+	config = Config{Policy: S4LRU, CacheSize: 4}
+	results, err = Simulate(t.Name(), &wrappedTrace{inner: trace}, config, nil /* files */)
+	require.NoError(t, err)
+	// The two sources keep evicting each other's block.
+	require.Equal(t, 0, results.Hits)
+	require.Equal(t, 4, results.Misses)
+
+	config.CachePerSource = true
+	results, err = Simulate(t.Name(), &wrappedTrace{inner: trace}, config, nil /* files */)
+	require.NoError(t, err)
+	// Each source has its own cache.
+	require.Equal(t, 2, results.Hits)
+	require.Equal(t, 2, results.Misses)
+}
+
+func TestSimulateAllBlocks(t *testing.T) {
+	read := func(offset, size int64) objiotracing.Event {
+		return objiotracing.Event{Op: objiotracing.ReadOp, FileNum: 1, Offset: offset, Size: size}
+	}
+	// The first read straddles blocks 0 and 1.
+	trace := []objiotracing.Event{read(4000, 200), read(4096, 100), read(0, 100)}
+	for _, policy := range []ReplacementPolicy{LRU, OPT} {
+		config := Config{Policy: policy, BlockSize: 4096, CacheSize: 16}
+		results, err := Simulate(t.Name(), &wrappedTrace{inner: trace}, config, nil /* files */)
+		require.NoError(t, err)
+		// Only the first block of each read is accessed.
+		require.Equal(t, 1, results.Hits)
+		require.Equal(t, 2, results.Misses)
+		require.Equal(t, int64(4000+3996), results.BlockOverReadBytes)
+
+		config.AllBlocks = true
+		results, err = Simulate(t.Name(), &wrappedTrace{inner: trace}, config, nil /* files */)
+		require.NoError(t, err)
+		require.Equal(t, 2, results.Hits)
+		require.Equal(t, 2, results.Misses)
+		require.Equal(t, int64(4000+3992), results.BlockOverReadBytes)
+	}
+}
+
+func TestSimulateReadHitRate(t *testing.T) {
+	read := func(offset, size int64) objiotracing.Event {
+		return objiotracing.Event{Op: objiotracing.ReadOp, FileNum: 1, Offset: offset, Size: size}
+	}
+	trace := []objiotracing.Event{read(0, 8192), read(8192, 8192), read(0, 8192), read(6000, 4096)}
+	for _, policy := range []ReplacementPolicy{LRU, OPT} {
+		// With 4KB blocks, each read accesses two blocks.
+		config := Config{Policy: policy, BlockSize: 4096, AllBlocks: true, CacheSize: 16}
+		results, err := Simulate(t.Name(), &wrappedTrace{inner: trace}, config, nil /* files */)
+		require.NoError(t, err)
+		require.Equal(t, 4, results.Hits)
+		require.Equal(t, 4, results.Misses)
+		require.Equal(t, 2, results.ReadHits)
+		require.Equal(t, 2, results.ReadMisses)
+		require.Equal(t, 0.5, results.ReadHitRate())
+
+		// With 16KB blocks, all reads access the same block.
+		config.BlockSize = 16384
+		results, err = Simulate(t.Name(), &wrappedTrace{inner: trace}, config, nil /* files */)
+		require.NoError(t, err)
+		require.Equal(t, 3, results.Hits)
+		require.Equal(t, 1, results.Misses)
+		require.Equal(t, 3, results.ReadHits)
+		require.Equal(t, 1, results.ReadMisses)
+		require.Equal(t, 0.75, results.ReadHitRate())
+	}
+
+	// A read which straddles a resident block and a missing block misses.
+	trace = []objiotracing.Event{read(0, 100), read(4000, 200)}
+	for _, policy := range []ReplacementPolicy{LRU, OPT} {
+		config := Config{Policy: policy, BlockSize: 4096, AllBlocks: true, CacheSize: 16}
+		results, err := Simulate(t.Name()+"/straddle", &wrappedTrace{inner: trace}, config, nil /* files */)
+		require.NoError(t, err)
+		require.Equal(t, 1, results.Hits)
+		require.Equal(t, 0, results.ReadHits)
+	}
+}
+
+func TestSimulateOPT(t *testing.T) {
+	read := func(offset, size int64) objiotracing.Event {
+		return objiotracing.Event{Op: objiotracing.ReadOp, FileNum: 1, Offset: offset, Size: size}
+	}
+	// Cyclic pattern over three blocks; LRU with a cache of two blocks never
+	// hits.
+	var trace []objiotracing.Event
+	for i := 0; i < 3; i++ {
+		trace = append(trace, read(0, 1024), read(1024, 1024), read(2048, 1024))
+	}
+	config := Config{Policy: OPT, CacheSize: 2}
+	check := func(name string) {
+		results, err := Simulate(name, &wrappedTrace{inner: trace}, config, nil /* files */)
+		require.NoError(t, err)
+		// The third block is never admitted, so the first two always hit after
+		// the initial misses.
+		require.Equal(t, 4, results.Hits)
+		require.Equal(t, 5, results.Misses)
+		require.Equal(t, int64(4*1024), results.HitBytes)
+	}
+	check(t.Name())
+
+	// Same, with the access log and next-use index spilled to disk.
+	defer func(old int) { optMaxInMemoryOps = old }(optMaxInMemoryOps)
+	optMaxInMemoryOps = 4
+	check(t.Name() + "/spilled")
+
+	// Byte-weighted: the first block takes up the entire cache.
+	trace = []objiotracing.Event{
+		read(0, 2), read(10, 1), read(20, 1),
+		read(0, 2), read(10, 1), read(20, 1),
+	}
+	config = Config{Policy: OPT, CacheSize: 2, ByteWeighted: true}
+	results, err := Simulate(t.Name()+"/bytes", &wrappedTrace{inner: trace}, config, nil /* files */)
+	require.NoError(t, err)
+	require.Equal(t, 1, results.Hits)
+	require.Equal(t, 5, results.Misses)
+	require.Equal(t, int64(2), results.HitBytes)
+	require.Equal(t, int64(6), results.MissBytes)
+
+	// For comparison, LRU never hits.
+	config.Policy = LRU
+	results, err = Simulate(t.Name()+"/bytes", &wrappedTrace{inner: trace}, config, nil /* files */)
+	require.NoError(t, err)
+	require.Equal(t, 0, results.Hits)
+	require.Equal(t, int64(8), results.MissBytes)
+}
@@ -0,0 +1,108 @@
+package lib
+
+import "math/bits"
+
+// QuantileSketch is a mergeable histogram of non-negative values (similar to
+// an HDR histogram): values below 32 are counted exactly, and larger values are
+// counted in buckets with 16 sub-buckets per power of two, so quantiles have a
+// relative error of at most ~3%. Merging two sketches is exact, so sketches for
+// fine-grained ticks can be combined into coarser ones.
+//
+// Only the range of buckets between the smallest and the largest value is
+// allocated, so sketches of values with a narrow distribution (e.g. block
+// sizes) are small.
+type QuantileSketch struct {
+	// counts[i] is the count of bucket offset+i.
+	counts []int64
+	offset int
+	total  int64
+}
+
+const sketchSubBucketBits = 4
+
+// sketchBucket returns the index of the bucket which contains v.
+func sketchBucket(v int64) int {
+	const sub = 1 << sketchSubBucketBits
+	if v < 2*sub {
+		if v < 0 {
+			return 0
+		}
+		return int(v)
+	}
+	shift := bits.Len64(uint64(v)) - sketchSubBucketBits - 1
+	return (shift+1)*sub + int(v>>shift) - sub
+}
+
+// sketchBucketBounds returns the range [lo, hi) of the values in a bucket.
+func sketchBucketBounds(i int) (lo, hi int64) {
+	const sub = 1 << sketchSubBucketBits
+	if i < 2*sub {
+		return int64(i), int64(i) + 1
+	}
+	shift := i/sub - 1
+	m := int64(i%sub + sub)
+	return m << shift, (m + 1) << shift
+}
+
+// Add adds a value to the sketch; negative values are counted as 0.
+func (s *QuantileSketch) Add(v int64) {
+	s.addBucket(sketchBucket(v), 1)
+}
+
+func (s *QuantileSketch) addBucket(b int, count int64) {
+	switch {
+	case len(s.counts) == 0:
+		s.counts = make([]int64, 1)
+		s.offset = b
+	case b < s.offset:
+		counts := make([]int64, len(s.counts)+s.offset-b)
+		copy(counts[s.offset-b:], s.counts)
+		s.counts = counts
+		s.offset = b
+	case b >= s.offset+len(s.counts):
+		s.counts = append(s.counts, make([]int64, b-s.offset-len(s.counts)+1)...)
+	}
+	s.counts[b-s.offset] += count
+	s.total += count
+}
+
+// Merge adds all the values of another sketch.
+func (s *QuantileSketch) Merge(o *QuantileSketch) {
+	for i, c := range o.counts {
+		if c > 0 {
+			s.addBucket(o.offset+i, c)
+		}
+	}
+}
+
+// Count returns the number of values in the sketch.
+func (s *QuantileSketch) Count() int64 {
+	return s.total
+}
+
+// Quantile returns an estimate of the given quantile (e.g. 0.99): the midpoint
+// of the bucket which contains it (or the exact value, for small values).
+// Returns 0 if the sketch is empty. Quantiles outside [0, 1] are clamped.
+func (s *QuantileSketch) Quantile(q float64) float64 {
+	if s.total == 0 {
+		return 0
+	}
+	// We want the value with this (1-based) rank.
+	rank := int64(q*float64(s.total) + 0.5)
+	if rank < 1 {
+		rank = 1
+	}
+	if rank > s.total {
+		rank = s.total
+	}
+	var n int64
+	for i, c := range s.counts {
+		n += c
+		if n >= rank {
+			lo, hi := sketchBucketBounds(s.offset + i)
+			return float64(lo) + float64(hi-lo-1)/2
+		}
+	}
+	// Not reachable.
+	return 0
+}
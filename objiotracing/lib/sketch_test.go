@@ -0,0 +1,52 @@
+package lib
+
+import (
+	"math"
+	"math/rand"
+	"sort"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestQuantileSketch(t *testing.T) {
+	// Bucket boundaries are contiguous.
+	for i := 0; i < 900; i++ {
+		lo, hi := sketchBucketBounds(i)
+		require.Equal(t, i, sketchBucket(lo))
+		require.Equal(t, i, sketchBucket(hi-1))
+		require.Equal(t, i+1, sketchBucket(hi))
+	}
+
+	var s QuantileSketch
+	require.Equal(t, 0.0, s.Quantile(0.5))
+	for _, v := range []int64{5, 1, 3, 2, 4} {
+		s.Add(v)
+	}
+	require.Equal(t, int64(5), s.Count())
+	require.Equal(t, 1.0, s.Quantile(0))
+	require.Equal(t, 3.0, s.Quantile(0.5))
+	require.Equal(t, 5.0, s.Quantile(1))
+	require.Equal(t, 1.0, s.Quantile(-0.5))
+	require.Equal(t, 5.0, s.Quantile(1.5))
+
+	// Compare against the exact quantiles, for a sketch built by merging.
+	rng := rand.New(rand.NewSource(1))
+	var values []int64
+	var merged QuantileSketch
+	for i := 0; i < 10; i++ {
+		var part QuantileSketch
+		for j := 0; j < 1000; j++ {
+			v := int64(math.Exp(rng.Float64() * 20))
+			values = append(values, v)
+			part.Add(v)
+		}
+		merged.Merge(&part)
+	}
+	require.Equal(t, int64(len(values)), merged.Count())
+	sort.Slice(values, func(i, j int) bool { return values[i] < values[j] })
+	for _, q := range []float64{0.1, 0.5, 0.9, 0.99, 0.999} {
+		exact := float64(values[int(q*float64(len(values))+0.5)-1])
+		require.InEpsilon(t, exact, merged.Quantile(q), 0.035, "q=%g", q)
+	}
+}
@@ -0,0 +1,34 @@
+package lib
+
+import (
+	"unsafe"
+
+	"github.com/cockroachdb/pebble/objstorage/objstorageprovider/objiotracing"
+)
+
+// Traces can combine events from multiple sources (nodes or stores). File
+// numbers are only unique within a source, so we need to know which source
+// each event came from.
+//
+// We don't want to change the on-disk Event format, so we store the source ID
+// in the 4 bytes of padding that follow LevelPlusOne. Pebble always leaves
+// these bytes zeroed, so source 0 means "unknown" (which is also what we get
+// for traces from a single node). Source N corresponds to
+// TraceMetadata.Sources[N-1].
+const sourceOffset = unsafe.Offsetof(objiotracing.Event{}.LevelPlusOne) + 1
+
+func init() {
+	if unsafe.Offsetof(objiotracing.Event{}.FileNum)-sourceOffset != 4 {
+		panic("unexpected objiotracing.Event layout")
+	}
+}
+
+// EventSource returns the source ID of an event (see TraceMetadata.Sources).
+func EventSource(e *objiotracing.Event) uint32 {
+	return *(*uint32)(unsafe.Add(unsafe.Pointer(e), sourceOffset))
+}
+
+// SetEventSource sets the source ID of an event (see TraceMetadata.Sources).
+func SetEventSource(e *objiotracing.Event, source uint32) {
+	*(*uint32)(unsafe.Add(unsafe.Pointer(e), sourceOffset)) = source
+}
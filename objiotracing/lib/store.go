@@ -0,0 +1,388 @@
+package lib
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/cockroachdb/pebble/objstorage/objstorageprovider/objiotracing"
+	gzip "github.com/klauspost/pgzip"
+)
+
+// TraceStore is a library of traces. Traces are identified by slash-separated
+// names (e.g. "cluster1/2023-03-29/run1"), which allows grouping traces into
+// nested folders.
+//
+// Stores can also contain raw traces (see OpenRawTrace), which are read-only.
+type TraceStore interface {
+	// List returns the names of all traces in the store, sorted.
+	List() ([]string, error)
+	// Stat returns the metadata of a trace.
+	Stat(trace string) (TraceMetadata, error)
+	// Open returns the metadata of a trace and a streaming iterator over its
+	// events. The iterator must be closed by the caller.
+	Open(trace string) (TraceMetadata, Iterator, error)
+	// Add stores a new trace under md.Name, consuming the given iterator (which
+	// should produce events in time order). The StartTime, DurationSecs and
+	// NumEvents fields of md are calculated from the events.
+	Add(md TraceMetadata, it Iterator) error
+	// Delete removes a trace from the store.
+	Delete(trace string) error
+	// FileTable returns the sstable metadata recovered from the Pebble MANIFEST
+	// (and OPTIONS) files attached to a trace. The table is empty if there are
+	// no such files.
+	FileTable(trace string) (*FileTable, error)
+	// AttachManifest attaches a copy of a Pebble MANIFEST or OPTIONS file to a
+	// trace. The source is the name of the node/store the file belongs to (see
+	// TraceMetadata.Sources), or empty for single-source traces.
+	AttachManifest(trace string, source string, filename string) error
+}
+
+// DirStore is a TraceStore backed by one or more directories (roots). Each
+// trace consists of a <name>.json metadata file and a <name>.gz events file,
+// where the name can contain slashes (corresponding to subdirectories).
+// MANIFEST and OPTIONS files attached to a trace are stored in a
+// <name>.manifest directory, with one subdirectory per source for multi-source
+// traces.
+//
+// Any directory under a root which contains Pebble IOTRACES- files (directly
+// or in per-node subdirectories) is a raw trace (see rawTraceSources for how
+// per-node subdirectories are told apart from nested traces).
+//
+// If the same trace name exists under multiple roots, the first root wins. New
+// traces are always added to the first root.
+type DirStore struct {
+	roots []string
+}
+
+var _ TraceStore = (*DirStore)(nil)
+
+// NewDirStore creates a DirStore with the given root directories.
+func NewDirStore(roots ...string) *DirStore {
+	if len(roots) == 0 {
+		panic("no roots")
+	}
+	return &DirStore{roots: roots}
+}
+
+// Roots returns the root directories of the store.
+func (s *DirStore) Roots() []string {
+	return s.roots
+}
+
+// List is part of the TraceStore interface.
+func (s *DirStore) List() ([]string, error) {
+	seen := make(map[string]struct{})
+	var traces []string
+	add := func(root, p string) error {
+		rel, err := filepath.Rel(root, p)
+		if err != nil {
+			return err
+		}
+		name := strings.TrimSuffix(filepath.ToSlash(rel), ".json")
+		if _, ok := seen[name]; !ok {
+			seen[name] = struct{}{}
+			traces = append(traces, name)
+		}
+		return nil
+	}
+	for _, root := range s.roots {
+		err := filepath.WalkDir(root, func(p string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			if d.IsDir() {
+				if p != root && isRawTrace(p) {
+					if err := add(root, p); err != nil {
+						return err
+					}
+					// Don't look for traces inside the raw trace directory.
+					return fs.SkipDir
+				}
+				return nil
+			}
+			if strings.HasSuffix(p, ".json") {
+				return add(root, p)
+			}
+			return nil
+		})
+		if err != nil && !os.IsNotExist(err) {
+			return nil, err
+		}
+	}
+	sort.Strings(traces)
+	return traces, nil
+}
+
+// Stat is part of the TraceStore interface.
+func (s *DirStore) Stat(trace string) (TraceMetadata, error) {
+	basePath, raw, err := s.find(trace)
+	if err != nil {
+		return TraceMetadata{}, err
+	}
+	if raw {
+		sources, err := rawTraceSources(basePath)
+		if err != nil {
+			return TraceMetadata{}, err
+		}
+		return rawMetadata(trace, sources)
+	}
+	return readMetadata(basePath + ".json")
+}
+
+// Open is part of the TraceStore interface.
+func (s *DirStore) Open(trace string) (TraceMetadata, Iterator, error) {
+	basePath, raw, err := s.find(trace)
+	if err != nil {
+		return TraceMetadata{}, nil, err
+	}
+	if raw {
+		return OpenRawTrace(basePath, trace)
+	}
+	md, err := readMetadata(basePath + ".json")
+	if err != nil {
+		return TraceMetadata{}, nil, err
+	}
+	file, err := os.Open(basePath + ".gz")
+	if err != nil {
+		return TraceMetadata{}, nil, err
+	}
+	reader, err := gzip.NewReader(file)
+	if err != nil {
+		file.Close()
+		return TraceMetadata{}, nil, err
+	}
+	return md, newReaderIterator(reader, reader, file), nil
+}
+
+// Add is part of the TraceStore interface.
+//
+// The files are first written under temporary names and renamed at the end,
+// so a failed Add does not leave a partial trace behind.
+func (s *DirStore) Add(md TraceMetadata, it Iterator) error {
+	defer it.Close()
+	if err := checkTraceName(md.Name); err != nil {
+		return err
+	}
+	if _, _, err := s.find(md.Name); err == nil {
+		return fmt.Errorf("trace %q already exists", md.Name)
+	}
+	basePath := filepath.Join(s.roots[0], filepath.FromSlash(md.Name))
+	if err := os.MkdirAll(filepath.Dir(basePath), 0777); err != nil {
+		return err
+	}
+
+	tmpGz := basePath + ".gz.tmp"
+	out, err := os.Create(tmpGz)
+	if err != nil {
+		return err
+	}
+	b := metadataBuilder{accessPattern: true}
+	w := gzip.NewWriter(out)
+	for err == nil {
+		var events []objiotracing.Event
+		events, err = it.NextBatch()
+		if err != nil || events == nil {
+			break
+		}
+		b.add(events)
+		_, err = w.Write(EventsAsBytes(events))
+	}
+	if err == nil {
+		err = w.Close()
+	}
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tmpGz)
+		return err
+	}
+
+	b.finish(&md)
+	md.Raw = false
+	jsonBuf, err := json.Marshal(&md)
+	if err != nil {
+		os.Remove(tmpGz)
+		return err
+	}
+	tmpJSON := basePath + ".json.tmp"
+	if err := os.WriteFile(tmpJSON, jsonBuf, 0666); err != nil {
+		os.Remove(tmpGz)
+		return err
+	}
+	// Rename the events file first: a trace is only listed once the .json
+	// file exists.
+	if err := os.Rename(tmpGz, basePath+".gz"); err != nil {
+		os.Remove(tmpGz)
+		os.Remove(tmpJSON)
+		return err
+	}
+	return os.Rename(tmpJSON, basePath+".json")
+}
+
+// Delete is part of the TraceStore interface.
+func (s *DirStore) Delete(trace string) error {
+	basePath, raw, err := s.find(trace)
+	if err != nil {
+		return err
+	}
+	if raw {
+		return fmt.Errorf("cannot delete raw trace %q", trace)
+	}
+	// Remove the metadata first, so that the trace stops being listed even if
+	// removing the events file fails.
+	if err := os.Remove(basePath + ".json"); err != nil {
+		return err
+	}
+	if err := os.Remove(basePath + ".gz"); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return os.RemoveAll(basePath + manifestDirSuffix)
+}
+
+const manifestDirSuffix = ".manifest"
+
+// FileTable is part of the TraceStore interface.
+//
+// For raw traces, the MANIFEST and OPTIONS files are read from the trace
+// directory (or the per-source subdirectories), next to the IOTRACES- files.
+func (s *DirStore) FileTable(trace string) (*FileTable, error) {
+	basePath, raw, err := s.find(trace)
+	if err != nil {
+		return nil, err
+	}
+	t := NewFileTable()
+	if raw {
+		sources, err := rawTraceSources(basePath)
+		if err != nil {
+			return nil, err
+		}
+		for i, src := range sources {
+			if src.name == "" {
+				return t, t.loadManifestDir(0, basePath)
+			}
+			if err := t.loadManifestDir(uint32(i+1), filepath.Join(basePath, src.name)); err != nil {
+				return nil, err
+			}
+		}
+		return t, nil
+	}
+
+	md, err := readMetadata(basePath + ".json")
+	if err != nil {
+		return nil, err
+	}
+	dir := basePath + manifestDirSuffix
+	if _, err := os.Stat(dir); os.IsNotExist(err) {
+		return t, nil
+	}
+	if len(md.Sources) == 0 {
+		return t, t.loadManifestDir(0, dir)
+	}
+	for i, name := range md.Sources {
+		sourceDir := filepath.Join(dir, filepath.FromSlash(name))
+		if _, err := os.Stat(sourceDir); os.IsNotExist(err) {
+			continue
+		}
+		if err := t.loadManifestDir(uint32(i+1), sourceDir); err != nil {
+			return nil, err
+		}
+	}
+	return t, nil
+}
+
+// AttachManifest is part of the TraceStore interface.
+func (s *DirStore) AttachManifest(trace string, source string, filename string) error {
+	basePath, raw, err := s.find(trace)
+	if err != nil {
+		return err
+	}
+	if raw {
+		return fmt.Errorf("cannot attach files to raw trace %q; copy them into the trace directory instead", trace)
+	}
+	name := filepath.Base(filename)
+	if !isManifestFile(name) {
+		return fmt.Errorf("%s: not a MANIFEST- or OPTIONS- file", filename)
+	}
+	md, err := readMetadata(basePath + ".json")
+	if err != nil {
+		return err
+	}
+	dir := basePath + manifestDirSuffix
+	switch {
+	case len(md.Sources) == 0 && source != "":
+		return fmt.Errorf("trace %q has no sources", trace)
+	case len(md.Sources) > 0:
+		if !contains(md.Sources, source) {
+			return fmt.Errorf("trace %q has no source %q (sources: %s)", trace, source, strings.Join(md.Sources, ", "))
+		}
+		dir = filepath.Join(dir, filepath.FromSlash(source))
+	}
+
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return err
+	}
+	// Check that the file can be parsed before storing it.
+	if strings.HasPrefix(name, manifestFilePrefix) {
+		_, err = ReadManifest(bytes.NewReader(data))
+	} else {
+		_, err = ParseOptions(bytes.NewReader(data))
+	}
+	if err != nil {
+		return fmt.Errorf("%s: %w", filename, err)
+	}
+	if err := os.MkdirAll(dir, 0777); err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(dir, name), data, 0666)
+}
+
+// find returns the path (without extension) of the given trace, in the first
+// root that contains it. For raw traces, the path is the trace directory.
+func (s *DirStore) find(trace string) (basePath string, raw bool, _ error) {
+	if err := checkTraceName(trace); err != nil {
+		return "", false, err
+	}
+	for _, root := range s.roots {
+		basePath := filepath.Join(root, filepath.FromSlash(trace))
+		if _, err := os.Stat(basePath + ".json"); err == nil {
+			return basePath, false, nil
+		} else if !os.IsNotExist(err) {
+			return "", false, err
+		}
+		if isRawTrace(basePath) {
+			return basePath, true, nil
+		}
+	}
+	return "", false, fmt.Errorf("trace %q not found", trace)
+}
+
+// checkTraceName verifies that a trace name is a clean relative path which
+// can't escape the store roots.
+func checkTraceName(trace string) error {
+	if trace == "" || path.IsAbs(trace) || path.Clean(trace) != trace ||
+		trace == ".." || strings.HasPrefix(trace, "../") || strings.Contains(trace, "\\") {
+		return fmt.Errorf("invalid trace name %q", trace)
+	}
+	return nil
+}
+
+func readMetadata(filename string) (TraceMetadata, error) {
+	mdBuf, err := os.ReadFile(filename)
+	if err != nil {
+		return TraceMetadata{}, err
+	}
+	var md TraceMetadata
+	if err := json.Unmarshal(mdBuf, &md); err != nil {
+		return TraceMetadata{}, err
+	}
+	return md, nil
+}
@@ -0,0 +1,158 @@
+package lib
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/cockroachdb/pebble/objstorage/objstorageprovider/objiotracing"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDirStore(t *testing.T) {
+	root1 := t.TempDir()
+	root2 := t.TempDir()
+	s := NewDirStore(root1, root2)
+
+	events := []objiotracing.Event{
+		{StartUnixNano: 1, Op: objiotracing.ReadOp, FileNum: 1, Size: 100},
+		{StartUnixNano: 2, Op: objiotracing.WriteOp, FileNum: 2, Size: 200},
+	}
+	add := func(name string) {
+		require.NoError(t, s.Add(TraceMetadata{Name: name}, SliceIterator(events)))
+	}
+	add("a")
+	add("cluster1/2023-03-29/b")
+	require.Error(t, s.Add(TraceMetadata{Name: "a"}, SliceIterator(nil)))
+	for _, name := range []string{"", "/abs", "../escape", "x/../../escape", "x//y"} {
+		require.Error(t, s.Add(TraceMetadata{Name: name}, SliceIterator(nil)), name)
+	}
+
+	// A trace under the second root is visible, unless shadowed by the first.
+	require.NoError(t, NewDirStore(root2).Add(TraceMetadata{Name: "c"}, SliceIterator(nil)))
+	require.NoError(t, NewDirStore(root2).Add(TraceMetadata{Name: "a"}, SliceIterator(events[:1])))
+	require.FileExists(t, filepath.Join(root1, "cluster1", "2023-03-29", "b.gz"))
+
+	list, err := s.List()
+	require.NoError(t, err)
+	require.Equal(t, []string{"a", "c", "cluster1/2023-03-29/b"}, list)
+
+	md, err := s.Stat("a")
+	require.NoError(t, err)
+	require.Equal(t, len(events), md.NumEvents)
+	// The only read is a random access.
+	require.Equal(t, &AccessPatternSummary{}, md.AccessPattern)
+
+	md, it, err := s.Open("cluster1/2023-03-29/b")
+	require.NoError(t, err)
+	require.Equal(t, "cluster1/2023-03-29/b", md.Name)
+	require.Equal(t, 2, md.NumEvents)
+	batch, err := it.NextBatch()
+	require.NoError(t, err)
+	require.Equal(t, events, batch)
+	batch, err = it.NextBatch()
+	require.NoError(t, err)
+	require.Nil(t, batch)
+	it.Close()
+
+	require.NoError(t, s.Delete("a"))
+	// The trace in the second root is now visible.
+	md, err = s.Stat("a")
+	require.NoError(t, err)
+	require.Equal(t, 1, md.NumEvents)
+	require.NoError(t, s.Delete("a"))
+	_, err = s.Stat("a")
+	require.Error(t, err)
+}
+
+// writeRawTraceFile writes a raw trace file with read events at the given
+// times.
+func writeRawTraceFile(t *testing.T, root, path string, times ...int64) {
+	var events []objiotracing.Event
+	for _, t := range times {
+		events = append(events, objiotracing.Event{StartUnixNano: t, Op: objiotracing.ReadOp, Size: t})
+	}
+	full := filepath.Join(root, filepath.FromSlash(path))
+	require.NoError(t, os.MkdirAll(filepath.Dir(full), 0777))
+	// Add a partial event at the end, as if the file was being written.
+	buf := append(EventsAsBytes(events), 1, 2, 3)
+	require.NoError(t, os.WriteFile(full, buf, 0666))
+}
+
+func TestRawTrace(t *testing.T) {
+	root := t.TempDir()
+	writeRaw := func(path string, times ...int64) {
+		writeRawTraceFile(t, root, path, times...)
+	}
+	writeRaw("raw/n1/IOTRACES-2023-01-01T00:00:00Z", 1, 4, 3)
+	writeRaw("raw/n1/IOTRACES-2023-01-01T00:01:00Z", 7, 8)
+	writeRaw("raw/n2/IOTRACES-2023-01-01T00:00:00Z", 2, 6, 5)
+	writeRaw("single/IOTRACES-2023-01-01T00:00:00Z", 10, 20)
+
+	s := NewDirStore(root)
+	list, err := s.List()
+	require.NoError(t, err)
+	require.Equal(t, []string{"raw", "single"}, list)
+
+	md, it, err := s.Open("raw")
+	require.NoError(t, err)
+	require.True(t, md.Raw)
+	require.Equal(t, 8, md.NumEvents)
+	require.Equal(t, []string{"n1", "n2"}, md.Sources)
+	var times []int64
+	var sources []string
+	for {
+		batch, err := it.NextBatch()
+		require.NoError(t, err)
+		if batch == nil {
+			break
+		}
+		for i := range batch {
+			times = append(times, batch[i].StartUnixNano)
+			sources = append(sources, md.SourceName(EventSource(&batch[i])))
+		}
+	}
+	it.Close()
+	require.Equal(t, []int64{1, 2, 3, 4, 5, 6, 7, 8}, times)
+	require.Equal(t, []string{"n1", "n2", "n1", "n1", "n2", "n2", "n1", "n1"}, sources)
+
+	require.Error(t, s.Delete("raw"))
+	require.NoError(t, PromoteRawTrace(s, "raw", "promoted/raw"))
+	md, err = s.Stat("promoted/raw")
+	require.NoError(t, err)
+	require.False(t, md.Raw)
+	require.Equal(t, 8, md.NumEvents)
+	require.Equal(t, []string{"n1", "n2"}, md.Sources)
+
+	md, err = s.Stat("single")
+	require.NoError(t, err)
+	require.Equal(t, 2, md.NumEvents)
+	require.Empty(t, md.Sources)
+}
+
+func TestRawTraceNested(t *testing.T) {
+	root := t.TempDir()
+	// A single-source raw trace in a nested folder, next to a normal trace and
+	// a multi-source raw trace (with an OPTIONS file in a node directory).
+	writeRawTraceFile(t, root, "cluster1/run1/IOTRACES-2023-01-01T00:00:00Z", 1, 2)
+	writeRawTraceFile(t, root, "cluster1/run3/n1/IOTRACES-2023-01-01T00:00:00Z", 1)
+	writeRawTraceFile(t, root, "cluster1/run3/n2/IOTRACES-2023-01-01T00:00:00Z", 2)
+	require.NoError(t, os.WriteFile(filepath.Join(root, "cluster1/run3/n1/OPTIONS-000003"), nil, 0666))
+	s := NewDirStore(root)
+	require.NoError(t, s.Add(TraceMetadata{Name: "cluster1/run2"}, SliceIterator([]objiotracing.Event{{}})))
+
+	list, err := s.List()
+	require.NoError(t, err)
+	require.Equal(t, []string{"cluster1/run1", "cluster1/run2", "cluster1/run3"}, list)
+
+	md, err := s.Stat("cluster1/run1")
+	require.NoError(t, err)
+	require.True(t, md.Raw)
+	require.Empty(t, md.Sources)
+	require.Equal(t, 2, md.NumEvents)
+	md, err = s.Stat("cluster1/run3")
+	require.NoError(t, err)
+	require.Equal(t, []string{"n1", "n2"}, md.Sources)
+	_, err = s.Stat("cluster1")
+	require.Error(t, err)
+}
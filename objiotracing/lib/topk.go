@@ -0,0 +1,217 @@
+package lib
+
+import (
+	"container/heap"
+	"fmt"
+	"sort"
+
+	"github.com/cockroachdb/pebble/objstorage/objstorageprovider/objiotracing"
+)
+
+// TopKConfig describes the heavy hitters report produced by TopK.
+type TopKConfig struct {
+	// K is the number of files and blocks to report; it must be positive.
+	K int
+	// ByBytes ranks files and blocks by the bytes read, instead of the number
+	// of reads.
+	ByBytes bool
+	// BlockSize is the granularity of the blocks (see Config.BlockSize); if 0,
+	// blocks are identified by offset.
+	BlockSize int64
+	// IncludeCacheHits also counts reads that hit Pebble's block cache.
+	IncludeCacheHits bool
+}
+
+// HeavyHitter describes the reads from a file or a block.
+type HeavyHitter struct {
+	File string `json:"file"`
+	// Offset is the offset of the block, or -1 for files.
+	Offset int64  `json:"offset"`
+	Level  string `json:"level"`
+	Reads  int64  `json:"reads"`
+	Bytes  int64  `json:"bytes"`
+	// MaxError is an upper bound on the overestimation of the ranking value
+	// (Reads or Bytes, see TopKConfig.ByBytes); the other values only count
+	// the reads since the file or block started being tracked.
+	MaxError int64 `json:"max_error"`
+	// Reasons contains the number of reads for each reason (see ReasonName).
+	Reasons             map[string]int64 `json:"reasons"`
+	FirstAccessUnixNano int64            `json:"first_access_unix_nano"`
+	LastAccessUnixNano  int64            `json:"last_access_unix_nano"`
+}
+
+// TopKReport contains the files and blocks with the most reads.
+type TopKReport struct {
+	Files  []HeavyHitter `json:"files"`
+	Blocks []HeavyHitter `json:"blocks"`
+}
+
+// topKCapacityFactor is the number of entries tracked by the space-saving
+// sketches, as a multiple of K; more entries make the results more accurate.
+const topKCapacityFactor = 10
+
+// TopK finds the files and the blocks with the most reads in a trace, using
+// the space-saving algorithm (Metwally et al.), so memory usage is
+// proportional to K. The results are exact if there are few enough distinct
+// files or blocks; otherwise each value can be overestimated by at most
+// HeavyHitter.MaxError.
+func TopK(it Iterator, config TopKConfig) (*TopKReport, error) {
+	if config.K <= 0 {
+		return nil, fmt.Errorf("invalid K %d", config.K)
+	}
+	files := newSpaceSaving[fileID](config.K * topKCapacityFactor)
+	blocks := newSpaceSaving[blockID](config.K * topKCapacityFactor)
+	c := Config{BlockSize: config.BlockSize}
+	for {
+		batch, err := it.NextBatch()
+		if err != nil {
+			return nil, err
+		}
+		if batch == nil {
+			break
+		}
+		for i := range batch {
+			e := &batch[i]
+			if e.Op != objiotracing.ReadOp && (e.Op != objiotracing.RecordCacheHitOp || !config.IncludeCacheHits) {
+				continue
+			}
+			weight := int64(1)
+			if config.ByBytes {
+				weight = e.Size
+			}
+			files.add(eventFile(e), weight, e)
+			blocks.add(blockID{source: EventSource(e), fileNum: uint64(e.FileNum), block: c.block(e.Offset)}, weight, e)
+		}
+	}
+	r := &TopKReport{}
+	for _, s := range files.top(config.K) {
+		h := s.heavyHitter()
+		h.File = fileName(s.key.source, s.key.fileNum)
+		h.Offset = -1
+		r.Files = append(r.Files, h)
+	}
+	for _, s := range blocks.top(config.K) {
+		h := s.heavyHitter()
+		h.File = fileName(s.key.source, s.key.fileNum)
+		h.Offset = c.blockOffset(s.key.block)
+		r.Blocks = append(r.Blocks, h)
+	}
+	return r, nil
+}
+
+// spaceSaving is a space-saving sketch with a fixed number of entries. When
+// all entries are in use, a new key replaces the entry with the smallest
+// weight and inherits its weight (which becomes the error of the entry).
+type spaceSaving[K comparable] struct {
+	capacity int
+	entries  map[K]*spaceSavingEntry[K]
+	// heap contains all entries, with the smallest weight at the top.
+	heap spaceSavingHeap[K]
+}
+
+type spaceSavingEntry[K comparable] struct {
+	key    K
+	weight int64
+	error  int64
+	index  int
+
+	levelPlusOne uint8
+	reads        int64
+	bytes        int64
+	reasons      [objiotracing.ForIngestion + 1]int64
+	first, last  int64
+}
+
+func newSpaceSaving[K comparable](capacity int) *spaceSaving[K] {
+	return &spaceSaving[K]{
+		capacity: capacity,
+		entries:  make(map[K]*spaceSavingEntry[K]),
+	}
+}
+
+func (s *spaceSaving[K]) add(key K, weight int64, e *objiotracing.Event) {
+	entry, ok := s.entries[key]
+	switch {
+	case ok:
+	case len(s.entries) < s.capacity:
+		entry = &spaceSavingEntry[K]{key: key, first: e.StartUnixNano}
+		s.entries[key] = entry
+		heap.Push(&s.heap, entry)
+	default:
+		// Replace the entry with the smallest weight.
+		entry = s.heap[0]
+		delete(s.entries, entry.key)
+		*entry = spaceSavingEntry[K]{
+			key:    key,
+			weight: entry.weight,
+			error:  entry.weight,
+			index:  entry.index,
+			first:  e.StartUnixNano,
+		}
+		s.entries[key] = entry
+	}
+	entry.weight += weight
+	entry.levelPlusOne = e.LevelPlusOne
+	entry.reads++
+	entry.bytes += e.Size
+	if int(e.Reason) < len(entry.reasons) {
+		entry.reasons[e.Reason]++
+	}
+	entry.last = e.StartUnixNano
+	heap.Fix(&s.heap, entry.index)
+}
+
+// top returns the k entries with the largest weights, in decreasing order.
+func (s *spaceSaving[K]) top(k int) []*spaceSavingEntry[K] {
+	res := append([]*spaceSavingEntry[K](nil), s.heap...)
+	sort.Slice(res, func(i, j int) bool {
+		return res[i].weight > res[j].weight
+	})
+	if len(res) > k {
+		res = res[:k]
+	}
+	return res
+}
+
+func (e *spaceSavingEntry[K]) heavyHitter() HeavyHitter {
+	h := HeavyHitter{
+		Level:               LevelName(e.levelPlusOne),
+		Reads:               e.reads,
+		Bytes:               e.bytes,
+		MaxError:            e.error,
+		Reasons:             make(map[string]int64),
+		FirstAccessUnixNano: e.first,
+		LastAccessUnixNano:  e.last,
+	}
+	for r, n := range e.reasons {
+		if n > 0 {
+			h.Reasons[ReasonName(objiotracing.Reason(r))] = n
+		}
+	}
+	return h
+}
+
+type spaceSavingHeap[K comparable] []*spaceSavingEntry[K]
+
+func (h spaceSavingHeap[K]) Len() int { return len(h) }
+
+func (h spaceSavingHeap[K]) Less(i, j int) bool { return h[i].weight < h[j].weight }
+
+func (h spaceSavingHeap[K]) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index = i
+	h[j].index = j
+}
+
+func (h *spaceSavingHeap[K]) Push(x any) {
+	e := x.(*spaceSavingEntry[K])
+	e.index = len(*h)
+	*h = append(*h, e)
+}
+
+func (h *spaceSavingHeap[K]) Pop() any {
+	old := *h
+	e := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return e
+}
@@ -0,0 +1,75 @@
+package lib
+
+import (
+	"math/rand"
+	"testing"
+
+	"github.com/cockroachdb/pebble/objstorage/objstorageprovider/objiotracing"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTopK(t *testing.T) {
+	read := func(nanos int64, reason objiotracing.Reason, fileNum uint64, offset int64) objiotracing.Event {
+		e := objiotracing.Event{
+			StartUnixNano: nanos,
+			Op:            objiotracing.ReadOp,
+			Reason:        reason,
+			LevelPlusOne:  7,
+			Offset:        offset,
+			Size:          100,
+		}
+		setFileNum(&e.FileNum, fileNum)
+		return e
+	}
+	events := []objiotracing.Event{
+		read(1, objiotracing.UnknownReason, 1, 0),
+		read(2, objiotracing.ForCompaction, 1, 4096),
+		read(3, objiotracing.UnknownReason, 2, 0),
+		read(4, objiotracing.UnknownReason, 1, 100),
+		read(5, objiotracing.UnknownReason, 3, 0),
+		read(6, objiotracing.UnknownReason, 3, 0),
+	}
+	r, err := TopK(SliceIterator(events), TopKConfig{K: 2, BlockSize: 4096})
+	require.NoError(t, err)
+	require.Equal(t, []HeavyHitter{
+		{
+			File: "000001", Offset: -1, Level: "L6", Reads: 3, Bytes: 300,
+			Reasons:             map[string]int64{"unknown": 2, "compaction": 1},
+			FirstAccessUnixNano: 1, LastAccessUnixNano: 4,
+		},
+		{
+			File: "000003", Offset: -1, Level: "L6", Reads: 2, Bytes: 200,
+			Reasons:             map[string]int64{"unknown": 2},
+			FirstAccessUnixNano: 5, LastAccessUnixNano: 6,
+		},
+	}, r.Files)
+	require.Len(t, r.Blocks, 2)
+	for _, b := range r.Blocks {
+		require.Equal(t, int64(2), b.Reads)
+		require.Equal(t, int64(0), b.Offset)
+	}
+
+	// With many distinct blocks, the heavy hitters are still found.
+	rng := rand.New(rand.NewSource(1))
+	events = events[:0]
+	bytes := make(map[string]int64)
+	for i := 0; i < 100000; i++ {
+		fileNum := uint64(100 + rng.Intn(10000))
+		if i%5 == 0 {
+			fileNum = uint64(1 + i%3)
+		}
+		bytes[fileName(0, fileNum)] += 100
+		events = append(events, read(int64(i), objiotracing.UnknownReason, fileNum, 0))
+	}
+	r, err = TopK(SliceIterator(events), TopKConfig{K: 3, ByBytes: true})
+	require.NoError(t, err)
+	for _, f := range r.Files {
+		require.Contains(t, []string{"000001", "000002", "000003"}, f.File)
+		// The bytes are only counted since the file started being tracked.
+		require.LessOrEqual(t, f.Bytes, bytes[f.File])
+		require.GreaterOrEqual(t, f.Bytes+f.MaxError, bytes[f.File])
+	}
+
+	_, err = TopK(SliceIterator(events), TopKConfig{K: 0})
+	require.Error(t, err)
+}
@@ -1,8 +1,101 @@
 package lib
 
+import (
+	"time"
+
+	"github.com/cockroachdb/pebble/objstorage/objstorageprovider/objiotracing"
+)
+
 type TraceMetadata struct {
 	Name         string `json:"name"`
 	StartTime    string `json:"start_time"`
 	DurationSecs int    `json:"duration_secs"`
 	NumEvents    int    `json:"num_events"`
+	// Sources lists the nodes/stores the trace was collected from, for traces
+	// that combine multiple sources. Events from Sources[i] have source ID i+1
+	// (see EventSource); empty if the trace does not record sources.
+	Sources []string `json:"sources,omitempty"`
+	// Raw is set for traces that are read directly from a directory of Pebble
+	// IOTRACES- files (see OpenRawTrace). For these traces, StartTime and
+	// DurationSecs are estimates.
+	Raw bool `json:"raw,omitempty"`
+	// AccessPattern summarizes how sequential the reads of the trace are. It is
+	// calculated when the trace is added to a store, so it is not set for raw
+	// traces (or for traces added before it existed).
+	AccessPattern *AccessPatternSummary `json:"access_pattern,omitempty"`
+	// NoLevels is set if none of the events record the LSM level of the file
+	// (e.g. for imported traces), in which case level-based options (like
+	// Config.L5AndL6Only) are meaningless. It is calculated when the trace is
+	// added to a store.
+	NoLevels bool `json:"no_levels,omitempty"`
+}
+
+// AccessPatternSummary is a summary of the AccessPatternStats of all the reads
+// of a trace (see ClassifyAccesses), with DefaultSequentialGap.
+type AccessPatternSummary struct {
+	SequentialFraction      float64 `json:"sequential_fraction"`
+	MeanSequentialRunLength float64 `json:"mean_sequential_run_length"`
+}
+
+// SourceName returns the name of the source with the given ID, or "" if the
+// ID is 0 or unknown.
+func (md *TraceMetadata) SourceName(source uint32) string {
+	if source == 0 || int(source) > len(md.Sources) {
+		return ""
+	}
+	return md.Sources[source-1]
+}
+
+// metadataBuilder calculates the StartTime, DurationSecs, NumEvents and
+// NoLevels fields of a TraceMetadata from the events of the trace, and the
+// AccessPattern field if accessPattern is set.
+type metadataBuilder struct {
+	minNanos  int64
+	maxNanos  int64
+	numEvents int
+	hasLevels bool
+
+	accessPattern bool
+	classifier    *sequentialClassifier
+	patterns      AccessPatternStats
+}
+
+func (b *metadataBuilder) add(events []objiotracing.Event) {
+	if b.accessPattern && b.classifier == nil {
+		b.classifier = newSequentialClassifier(DefaultSequentialGap, b.patterns.addRun)
+	}
+	for i := range events {
+		if b.classifier != nil {
+			b.classifier.add(&events[i])
+		}
+		t := events[i].StartUnixNano
+		if b.numEvents == 0 || b.minNanos > t {
+			b.minNanos = t
+		}
+		if b.numEvents == 0 || b.maxNanos < t {
+			b.maxNanos = t
+		}
+		if events[i].LevelPlusOne != 0 {
+			b.hasLevels = true
+		}
+		b.numEvents++
+	}
+}
+
+func (b *metadataBuilder) finish(md *TraceMetadata) {
+	startTime := time.Unix(0, b.minNanos)
+	endTime := time.Unix(0, b.maxNanos)
+	md.StartTime = startTime.Format(time.RFC3339)
+	md.DurationSecs = int((endTime.Sub(startTime) + time.Second - 1) / time.Second)
+	md.NumEvents = b.numEvents
+	md.NoLevels = !b.hasLevels
+	if b.accessPattern {
+		if b.classifier != nil {
+			b.classifier.finish()
+		}
+		md.AccessPattern = &AccessPatternSummary{
+			SequentialFraction:      b.patterns.SequentialFraction(),
+			MeanSequentialRunLength: b.patterns.MeanSequentialRunLength(),
+		}
+	}
 }
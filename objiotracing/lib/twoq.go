@@ -0,0 +1,128 @@
+package lib
+
+// twoQCache implements the full version of the 2Q policy (Johnson & Shasha,
+// VLDB '94). New entries go into a1in (a FIFO); entries evicted from a1in are
+// remembered in the a1out ghost FIFO, and entries that are accessed again while
+// in a1out go into am (an LRU).
+//
+// With byte-weighted capacity, the list sizes are in bytes.
+type twoQCache struct {
+	capacity int64
+	// kin and kout are the target sizes of a1in and a1out.
+	kin, kout int64
+	entries   map[string]*cacheEntry
+	lists     [3]entryList
+}
+
+var _ sizedCache = (*twoQCache)(nil)
+var _ invalidatingCache = (*twoQCache)(nil)
+
+// Values for cacheEntry.list.
+const (
+	twoQA1in = iota
+	twoQA1out
+	twoQAm
+)
+
+func newTwoQCache(capacity int64) *twoQCache {
+	// These are the parameters recommended in the paper.
+	return &twoQCache{
+		capacity: capacity,
+		kin:      max64(capacity/4, 1),
+		kout:     max64(capacity/2, 1),
+		entries:  make(map[string]*cacheEntry),
+	}
+}
+
+func (c *twoQCache) Get(key string) interface{} {
+	e, ok := c.entries[key]
+	if !ok {
+		return nil
+	}
+	switch e.list {
+	case twoQAm:
+		c.lists[twoQAm].moveToFront(e)
+		return e.value
+	case twoQA1in:
+		// Accesses to entries in a1in are assumed to be correlated, so they
+		// don't affect the order.
+		return e.value
+	default:
+		return nil
+	}
+}
+
+func (c *twoQCache) Set(key string, value interface{}) {
+	c.SetSized(key, value, 1)
+}
+
+func (c *twoQCache) SetSized(key string, value interface{}, size int64) {
+	e, ok := c.entries[key]
+	if ok && e.list != twoQA1out {
+		e.value = value
+		c.lists[e.list].resize(e, size)
+		if e.list == twoQAm {
+			c.lists[twoQAm].moveToFront(e)
+		}
+		c.reclaim(0)
+		return
+	}
+	if size > c.capacity {
+		return
+	}
+	if ok {
+		c.lists[twoQA1out].remove(e)
+		e.value = value
+		e.size = size
+		c.reclaim(size)
+		e.list = twoQAm
+		c.lists[twoQAm].pushFront(e)
+		return
+	}
+	c.reclaim(size)
+	e = &cacheEntry{key: key, value: value, size: size, list: twoQA1in}
+	c.entries[key] = e
+	c.lists[twoQA1in].pushFront(e)
+}
+
+// reclaim evicts entries until an entry of the given size fits.
+func (c *twoQCache) reclaim(size int64) {
+	a1in, a1out, am := &c.lists[twoQA1in], &c.lists[twoQA1out], &c.lists[twoQAm]
+	for a1in.size+am.size+size > c.capacity {
+		if a1in.len > 0 && (a1in.size > c.kin || am.len == 0) {
+			e := a1in.back()
+			a1in.remove(e)
+			e.value = nil
+			e.list = twoQA1out
+			a1out.pushFront(e)
+			for a1out.size > c.kout {
+				g := a1out.back()
+				a1out.remove(g)
+				delete(c.entries, g.key)
+			}
+		} else {
+			e := am.back()
+			am.remove(e)
+			delete(c.entries, e.key)
+		}
+	}
+}
+
+func (c *twoQCache) Remove(key string) {
+	if e, ok := c.entries[key]; ok && e.list != twoQA1out {
+		c.lists[e.list].remove(e)
+		delete(c.entries, key)
+	}
+}
+
+func (c *twoQCache) peek(key string) (size int64, ok bool) {
+	if e, ok := c.entries[key]; ok && e.list != twoQA1out {
+		return e.size, true
+	}
+	return 0, false
+}
+
+// residentSize returns the total size of the resident entries.
+func (c *twoQCache) residentSize() int64 {
+	return c.lists[twoQA1in].size + c.lists[twoQAm].size
+}
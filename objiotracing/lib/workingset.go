@@ -0,0 +1,244 @@
+package lib
+
+import (
+	"math"
+	"math/bits"
+	"time"
+
+	"github.com/cockroachdb/pebble/objstorage/objstorageprovider/objiotracing"
+)
+
+// DefaultWorkingSetWindows are the window durations used by the UI.
+var DefaultWorkingSetWindows = []time.Duration{time.Minute, 10 * time.Minute, time.Hour}
+
+// WorkingSetSeries is the working set size over time, for a window duration
+// and (optionally) a level. The values are aligned with a TimeAxis: the value
+// for a tick is the working set of the window which contains the tick (or the
+// largest one, if the window is shorter than the tick).
+type WorkingSetSeries struct {
+	WindowSecs int `json:"window_secs"`
+	// Level is the level name (see LevelName), or empty for all levels.
+	Level  string    `json:"level"`
+	Blocks []float64 `json:"blocks"`
+	Bytes  []float64 `json:"bytes"`
+	// Estimated is set if some of the values are HyperLogLog estimates.
+	Estimated bool `json:"estimated,omitempty"`
+}
+
+// workingSetExactLimit is the number of distinct blocks in a window above which
+// we switch from exact counting to a HyperLogLog estimate.
+var workingSetExactLimit = 1 << 20
+
+// WorkingSet is a streaming estimator of the number of distinct blocks (and
+// their total size) read in consecutive windows of fixed duration, aligned
+// with the start of a TimeAxis; the windows are tumbling, not sliding. The
+// counts are exact until a window has more than about a million distinct
+// blocks, after which they are estimated with HyperLogLog; the byte estimate
+// is then the number of blocks times the average read size.
+type WorkingSet struct {
+	axis    TimeAxis
+	config  Config
+	windows []*workingSetWindow
+	// series[i] is the series for windows[i].
+	series []*WorkingSetSeries
+}
+
+// workingSetWindow tracks the current window, for a window duration and a
+// level.
+type workingSetWindow struct {
+	durationNanos int64
+	// level is LevelPlusOne, or -1 for all levels.
+	level int
+	// index is the index of the current window.
+	index int64
+	// exact contains the size of each distinct block while counting exactly;
+	// nil once we switched to hll.
+	exact      map[blockID]int64
+	exactBytes int64
+	hll        *hyperLogLog
+	// accesses and accessBytes are used to estimate the bytes with hll.
+	accesses    int64
+	accessBytes int64
+}
+
+// NewWorkingSet creates a WorkingSet estimator for the given window durations;
+// blockSize is the granularity of the blocks (see Config.BlockSize). Besides
+// the series for all levels, there is a series for each window duration and
+// level present in the trace.
+func NewWorkingSet(axis TimeAxis, blockSize int64, windows []time.Duration) *WorkingSet {
+	ws := &WorkingSet{axis: axis, config: Config{BlockSize: blockSize}}
+	for _, d := range windows {
+		ws.addWindow(int64(d), -1)
+	}
+	return ws
+}
+
+func (ws *WorkingSet) addWindow(durationNanos int64, level int) *workingSetWindow {
+	w := &workingSetWindow{
+		durationNanos: durationNanos,
+		level:         level,
+		exact:         make(map[blockID]int64),
+	}
+	s := &WorkingSetSeries{
+		WindowSecs: int(durationNanos / int64(time.Second)),
+		Blocks:     make([]float64, ws.axis.NumTicks),
+		Bytes:      make([]float64, ws.axis.NumTicks),
+	}
+	if level >= 0 {
+		s.Level = LevelName(uint8(level))
+	}
+	ws.windows = append(ws.windows, w)
+	ws.series = append(ws.series, s)
+	return w
+}
+
+// Add adds the reads in a batch of events (which must be in time order).
+func (ws *WorkingSet) Add(events []objiotracing.Event) {
+	for i := range events {
+		e := &events[i]
+		if e.Op != objiotracing.ReadOp && e.Op != objiotracing.RecordCacheHitOp {
+			continue
+		}
+		nanos := e.StartUnixNano - ws.axis.StartUnixNano
+		if nanos < 0 {
+			nanos = 0
+		}
+		id := blockID{source: EventSource(e), fileNum: uint64(e.FileNum), block: ws.config.block(e.Offset)}
+		size := ws.config.entrySize(e)
+		haveLevel := false
+		n := len(ws.windows)
+		for j := 0; j < n; j++ {
+			w := ws.windows[j]
+			if w.level != -1 && w.level != int(e.LevelPlusOne) {
+				continue
+			}
+			haveLevel = haveLevel || w.level != -1
+			ws.advance(j, nanos/w.durationNanos)
+			w.add(id, size)
+		}
+		if !haveLevel {
+			// First read for this level; add windows for it.
+			for j := 0; j < n; j++ {
+				if ws.windows[j].level == -1 {
+					w := ws.addWindow(ws.windows[j].durationNanos, int(e.LevelPlusOne))
+					w.index = nanos / w.durationNanos
+					w.add(id, size)
+				}
+			}
+		}
+	}
+}
+
+// advance moves window j to the given index, recording the current window.
+func (ws *WorkingSet) advance(j int, index int64) {
+	w := ws.windows[j]
+	if index == w.index {
+		return
+	}
+	ws.record(j)
+	w.index = index
+	w.exact = make(map[blockID]int64)
+	w.exactBytes = 0
+	w.hll = nil
+	w.accesses = 0
+	w.accessBytes = 0
+}
+
+// record sets the values of the ticks overlapping the current window j.
+func (ws *WorkingSet) record(j int) {
+	w := ws.windows[j]
+	s := ws.series[j]
+	blocks, bytes := w.estimate()
+	if w.hll != nil {
+		s.Estimated = true
+	}
+	start := w.index * w.durationNanos
+	end := start + w.durationNanos
+	tickNanos := ws.axis.TickNanos
+	for t := start / tickNanos; t*tickNanos < end && t < int64(ws.axis.NumTicks); t++ {
+		if blocks > s.Blocks[t] {
+			s.Blocks[t] = blocks
+			s.Bytes[t] = bytes
+		}
+	}
+}
+
+func (w *workingSetWindow) add(id blockID, size int64) {
+	w.accesses++
+	w.accessBytes += size
+	if w.hll != nil {
+		w.hll.add(id.hash())
+		return
+	}
+	if _, ok := w.exact[id]; ok {
+		return
+	}
+	w.exact[id] = size
+	w.exactBytes += size
+	if len(w.exact) > workingSetExactLimit {
+		w.hll = newHyperLogLog()
+		for id := range w.exact {
+			w.hll.add(id.hash())
+		}
+		w.exact = nil
+	}
+}
+
+// estimate returns the number of distinct blocks and their total size.
+func (w *workingSetWindow) estimate() (blocks, bytes float64) {
+	if w.hll == nil {
+		return float64(len(w.exact)), float64(w.exactBytes)
+	}
+	blocks = w.hll.estimate()
+	return blocks, blocks * float64(w.accessBytes) / float64(w.accesses)
+}
+
+// Finish returns the series; the series for all levels come first (in the
+// order of the window durations), followed by the per-level series.
+func (ws *WorkingSet) Finish() []WorkingSetSeries {
+	res := make([]WorkingSetSeries, len(ws.series))
+	for j := range ws.windows {
+		ws.record(j)
+		res[j] = *ws.series[j]
+	}
+	return res
+}
+
+// hyperLogLog is a HyperLogLog sketch (Flajolet et al.) with 2^14 registers
+// (a standard error of about 0.8%).
+type hyperLogLog struct {
+	registers [1 << hllPrecision]uint8
+}
+
+const hllPrecision = 14
+
+func newHyperLogLog() *hyperLogLog {
+	return &hyperLogLog{}
+}
+
+func (h *hyperLogLog) add(hash uint64) {
+	idx := hash >> (64 - hllPrecision)
+	rank := uint8(bits.LeadingZeros64(hash<<hllPrecision|1<<(hllPrecision-1)) + 1)
+	if rank > h.registers[idx] {
+		h.registers[idx] = rank
+	}
+}
+
+func (h *hyperLogLog) estimate() float64 {
+	const m = 1 << hllPrecision
+	alpha := 0.7213 / (1 + 1.079/m)
+	var sum float64
+	zeros := 0
+	for _, r := range h.registers {
+		sum += math.Ldexp(1, -int(r))
+		if r == 0 {
+			zeros++
+		}
+	}
+	e := alpha * m * m / sum
+	if e <= 2.5*m && zeros > 0 {
+		// Small range correction (linear counting).
+		e = m * math.Log(float64(m)/float64(zeros))
+	}
+	return e
+}
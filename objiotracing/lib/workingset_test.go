@@ -0,0 +1,65 @@
+package lib
+
+import (
+	"testing"
+	"time"
+
+	"github.com/cockroachdb/pebble/objstorage/objstorageprovider/objiotracing"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWorkingSet(t *testing.T) {
+	read := func(secs int64, levelPlusOne uint8, fileNum uint64, size int64) objiotracing.Event {
+		e := objiotracing.Event{
+			StartUnixNano: (1000 + secs) * int64(time.Second),
+			Op:            objiotracing.ReadOp,
+			LevelPlusOne:  levelPlusOne,
+			Size:          size,
+		}
+		setFileNum(&e.FileNum, fileNum)
+		return e
+	}
+	axis := TimeAxis{StartUnixNano: 1000 * int64(time.Second), TickNanos: 30 * int64(time.Second), NumTicks: 4}
+	ws := NewWorkingSet(axis, 0 /* blockSize */, []time.Duration{time.Minute, 10 * time.Minute})
+	ws.Add([]objiotracing.Event{
+		read(0, 7, 1, 100),
+		read(10, 7, 2, 200),
+		read(40, 7, 1, 100),
+	})
+	ws.Add([]objiotracing.Event{
+		read(70, 1, 3, 50),
+	})
+	series := ws.Finish()
+	type summary struct {
+		windowSecs int
+		level      string
+		blocks     []float64
+		bytes      []float64
+	}
+	var res []summary
+	for _, s := range series {
+		require.False(t, s.Estimated)
+		res = append(res, summary{s.WindowSecs, s.Level, s.Blocks, s.Bytes})
+	}
+	require.Equal(t, []summary{
+		{60, "", []float64{2, 2, 1, 1}, []float64{300, 300, 50, 50}},
+		{600, "", []float64{3, 3, 3, 3}, []float64{350, 350, 350, 350}},
+		{60, "L6", []float64{2, 2, 0, 0}, []float64{300, 300, 0, 0}},
+		{600, "L6", []float64{2, 2, 2, 2}, []float64{300, 300, 300, 300}},
+		{60, "L0", []float64{0, 0, 1, 1}, []float64{0, 0, 50, 50}},
+		{600, "L0", []float64{1, 1, 1, 1}, []float64{50, 50, 50, 50}},
+	}, res)
+
+	// Large windows switch to HyperLogLog.
+	defer func(old int) { workingSetExactLimit = old }(workingSetExactLimit)
+	workingSetExactLimit = 100
+	ws = NewWorkingSet(axis, 0 /* blockSize */, []time.Duration{time.Minute})
+	const n = 100000
+	for i := 0; i < n; i++ {
+		ws.Add([]objiotracing.Event{read(0, 7, uint64(i), 100), read(1, 7, uint64(i), 100)})
+	}
+	series = ws.Finish()
+	require.True(t, series[0].Estimated)
+	require.InEpsilon(t, n, series[0].Blocks[0], 0.03)
+	require.InEpsilon(t, 100*n, series[0].Bytes[0], 0.03)
+}
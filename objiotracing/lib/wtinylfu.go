@@ -0,0 +1,326 @@
+package lib
+
+import "math"
+
+// wTinyLFUCache implements Window TinyLFU, as in Caffeine (Einziger et al.,
+// "TinyLFU: A Highly Efficient Cache Admission Policy"). New entries go into
+// a small LRU window; entries evicted from the window compete with the
+// eviction candidate of the main cache (a segmented LRU) and are only admitted
+// if their estimated frequency is higher. The size of the window is adapted
+// with hill climbing on the hit rate.
+//
+// With byte-weighted capacity, the segment sizes are in bytes.
+type wTinyLFUCache struct {
+	capacity        int64
+	windowCapacity  int64
+	protectedTarget int64
+	entries         map[string]*cacheEntry
+	lists           [3]entryList
+	sketch          *freqSketch
+	// victims is used as temporary storage in admit.
+	victims []*cacheEntry
+
+	// Hill climbing state.
+	climbPeriod int
+	hits        int
+	misses      int
+	prevHitRate float64
+	// step is the amount (positive or negative) by which the window capacity
+	// is adjusted.
+	step        float64
+	initialStep float64
+}
+
+var _ sizedCache = (*wTinyLFUCache)(nil)
+var _ invalidatingCache = (*wTinyLFUCache)(nil)
+
+// Values for cacheEntry.list.
+const (
+	wTinyLFUWindow = iota
+	wTinyLFUProbation
+	wTinyLFUProtected
+)
+
+// newWTinyLFUCache creates a W-TinyLFU cache; expectedEntries is the expected
+// number of entries when the cache is full, used to size the frequency sketch
+// and the hill climbing sample period.
+func newWTinyLFUCache(capacity int64, expectedEntries int) *wTinyLFUCache {
+	if expectedEntries < 16 {
+		expectedEntries = 16
+	}
+	c := &wTinyLFUCache{
+		capacity:    capacity,
+		entries:     make(map[string]*cacheEntry),
+		sketch:      newFreqSketch(expectedEntries),
+		climbPeriod: 10 * expectedEntries,
+		prevHitRate: -1,
+		initialStep: 0.0625 * float64(capacity),
+	}
+	c.step = c.initialStep
+	c.setWindowCapacity(max64(capacity/100, 1))
+	return c
+}
+
+func (c *wTinyLFUCache) setWindowCapacity(windowCapacity int64) {
+	c.windowCapacity = windowCapacity
+	c.protectedTarget = (c.capacity - windowCapacity) * 8 / 10
+}
+
+func (c *wTinyLFUCache) Get(key string) interface{} {
+	c.sketch.increment(key)
+	e, ok := c.entries[key]
+	if ok {
+		c.hits++
+		c.touch(e)
+	} else {
+		c.misses++
+	}
+	if c.hits+c.misses >= c.climbPeriod {
+		c.climb()
+	}
+	if !ok {
+		return nil
+	}
+	return e.value
+}
+
+// touch updates the lists for an access to a resident entry.
+func (c *wTinyLFUCache) touch(e *cacheEntry) {
+	switch e.list {
+	case wTinyLFUWindow, wTinyLFUProtected:
+		c.lists[e.list].moveToFront(e)
+	case wTinyLFUProbation:
+		c.lists[wTinyLFUProbation].remove(e)
+		e.list = wTinyLFUProtected
+		c.lists[wTinyLFUProtected].pushFront(e)
+		c.demoteProtected()
+	}
+}
+
+// demoteProtected moves entries from the protected segment to the probation
+// segment until the protected segment fits its target size.
+func (c *wTinyLFUCache) demoteProtected() {
+	protected := &c.lists[wTinyLFUProtected]
+	for protected.size > c.protectedTarget {
+		e := protected.back()
+		protected.remove(e)
+		e.list = wTinyLFUProbation
+		c.lists[wTinyLFUProbation].pushFront(e)
+	}
+}
+
+func (c *wTinyLFUCache) Set(key string, value interface{}) {
+	c.SetSized(key, value, 1)
+}
+
+func (c *wTinyLFUCache) SetSized(key string, value interface{}, size int64) {
+	if e, ok := c.entries[key]; ok {
+		e.value = value
+		c.lists[e.list].resize(e, size)
+		c.touch(e)
+		c.maintain()
+		return
+	}
+	if size > c.capacity {
+		return
+	}
+	e := &cacheEntry{key: key, value: value, size: size, list: wTinyLFUWindow}
+	c.entries[key] = e
+	c.lists[wTinyLFUWindow].pushFront(e)
+	c.maintain()
+}
+
+// maintain moves entries out of the window (into the main cache, if admitted)
+// and evicts entries from the main cache until both fit their capacities.
+func (c *wTinyLFUCache) maintain() {
+	window := &c.lists[wTinyLFUWindow]
+	for window.size > c.windowCapacity {
+		e := window.back()
+		window.remove(e)
+		c.admit(e)
+	}
+	for c.mainSize() > c.capacity-c.windowCapacity {
+		c.evict(c.victim())
+	}
+	c.demoteProtected()
+}
+
+func (c *wTinyLFUCache) mainSize() int64 {
+	return c.lists[wTinyLFUProbation].size + c.lists[wTinyLFUProtected].size
+}
+
+// victim returns the eviction candidate of the main cache, or nil if it is
+// empty.
+func (c *wTinyLFUCache) victim() *cacheEntry {
+	if e := c.lists[wTinyLFUProbation].back(); e != nil {
+		return e
+	}
+	return c.lists[wTinyLFUProtected].back()
+}
+
+func (c *wTinyLFUCache) evict(e *cacheEntry) {
+	c.lists[e.list].remove(e)
+	delete(c.entries, e.key)
+}
+
+// admit decides whether an entry evicted from the window goes into the main
+// cache. As in Caffeine, the candidate is only admitted if its estimated
+// frequency is higher than that of the main cache entries that would have to
+// be evicted to make room for it (in eviction order); nothing is evicted if it
+// is rejected.
+func (c *wTinyLFUCache) admit(candidate *cacheEntry) {
+	candidateFreq := c.sketch.estimate(candidate.key)
+	excess := c.mainSize() + candidate.size - (c.capacity - c.windowCapacity)
+	victims := c.victims[:0]
+	defer func() {
+		for i := range victims {
+			victims[i] = nil
+		}
+		c.victims = victims[:0]
+	}()
+	for _, list := range []uint8{wTinyLFUProbation, wTinyLFUProtected} {
+		l := &c.lists[list]
+		for e := l.back(); e != nil && excess > 0; e = l.before(e) {
+			if candidateFreq <= c.sketch.estimate(e.key) {
+				delete(c.entries, candidate.key)
+				return
+			}
+			victims = append(victims, e)
+			excess -= e.size
+		}
+	}
+	if excess > 0 {
+		delete(c.entries, candidate.key)
+		return
+	}
+	for _, e := range victims {
+		c.evict(e)
+	}
+	candidate.list = wTinyLFUProbation
+	c.lists[wTinyLFUProbation].pushFront(candidate)
+}
+
+// climb adjusts the window capacity based on the hit rate during the last
+// sample period: if the hit rate got worse, the direction is reversed. The step
+// decays over time, and is restarted when the hit rate changes significantly
+// (e.g. when the workload changes).
+func (c *wTinyLFUCache) climb() {
+	hitRate := float64(c.hits) / float64(c.hits+c.misses)
+	c.hits, c.misses = 0, 0
+	if c.prevHitRate >= 0 {
+		if hitRate < c.prevHitRate {
+			c.step = -c.step
+		}
+		if math.Abs(hitRate-c.prevHitRate) > 0.05 {
+			c.step = math.Copysign(c.initialStep, c.step)
+		} else {
+			c.step *= 0.98
+		}
+	}
+	c.prevHitRate = hitRate
+
+	windowCapacity := c.windowCapacity + int64(c.step)
+	windowCapacity = max64(windowCapacity, 1)
+	windowCapacity = min64(windowCapacity, c.capacity*8/10)
+	c.setWindowCapacity(windowCapacity)
+	c.maintain()
+}
+
+func (c *wTinyLFUCache) Remove(key string) {
+	if e, ok := c.entries[key]; ok {
+		c.evict(e)
+	}
+}
+
+func (c *wTinyLFUCache) peek(key string) (size int64, ok bool) {
+	if e, ok := c.entries[key]; ok {
+		return e.size, true
+	}
+	return 0, false
+}
+
+// residentSize returns the total size of the resident entries.
+func (c *wTinyLFUCache) residentSize() int64 {
+	return c.lists[wTinyLFUWindow].size + c.mainSize()
+}
+
+// freqSketch is a count-min sketch with 4 rows of small saturating counters,
+// used to estimate access frequencies. All counters are halved periodically, so
+// that the estimates favor recent accesses.
+type freqSketch struct {
+	rows       [4][]uint8
+	mask       uint64
+	additions  int
+	sampleSize int
+}
+
+// freqSketchMax is the maximum value of a counter.
+const freqSketchMax = 15
+
+func newFreqSketch(expectedEntries int) *freqSketch {
+	width := 1
+	for width < expectedEntries {
+		width *= 2
+	}
+	s := &freqSketch{
+		mask:       uint64(width - 1),
+		sampleSize: 10 * expectedEntries,
+	}
+	for i := range s.rows {
+		s.rows[i] = make([]uint8, width)
+	}
+	return s
+}
+
+// index returns the counter index for the given key in the given row.
+func (s *freqSketch) index(h uint64, row int) uint64 {
+	// Double hashing, using the two halves of the hash.
+	return (h + uint64(row)*((h>>32)|1)) & s.mask
+}
+
+// increment increments the counters for the key; returns true if the counters
+// were aged as a result.
+func (s *freqSketch) increment(key string) bool {
+	h := fnv64a(key)
+	for i := range s.rows {
+		if idx := s.index(h, i); s.rows[i][idx] < freqSketchMax {
+			s.rows[i][idx]++
+		}
+	}
+	s.additions++
+	if s.additions >= s.sampleSize {
+		for i := range s.rows {
+			for j := range s.rows[i] {
+				s.rows[i][j] /= 2
+			}
+		}
+		s.additions /= 2
+		return true
+	}
+	return false
+}
+
+func (s *freqSketch) estimate(key string) uint8 {
+	h := fnv64a(key)
+	var res uint8 = freqSketchMax
+	for i := range s.rows {
+		if v := s.rows[i][s.index(h, i)]; v < res {
+			res = v
+		}
+	}
+	return res
+}
+
+// fnv64a returns the FNV-1a hash of a string (without allocating).
+func fnv64a(s string) uint64 {
+	const (
+		offset64 = 14695981039346656037
+		prime64  = 1099511628211
+	)
+	h := uint64(offset64)
+	for i := 0; i < len(s); i++ {
+		h ^= uint64(s[i])
+		h *= prime64
+	}
+	return h
+}
@@ -1,3 +1,3 @@
 #!/bin/sh
 
-go run ./cmd/server
\ No newline at end of file
+go run ./cmd/server $*